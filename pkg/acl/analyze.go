@@ -0,0 +1,307 @@
+package acl
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"strings"
+
+	"github.com/xflash-panda/acl-engine/pkg/acl/domain"
+)
+
+// FindingKind is the kind of problem reported by Analyze.
+type FindingKind int
+
+const (
+	// FindingUnreachable is a rule after a catch-all rule such as "direct(all)".
+	FindingUnreachable FindingKind = iota
+	// FindingDuplicate is a rule identical to an earlier one.
+	FindingDuplicate
+	// FindingShadowed is a rule that never matches, because an earlier rule
+	// with a different outbound matches everything it does.
+	FindingShadowed
+	// FindingRedundant is a rule that can be removed, because an earlier rule
+	// with the same outbound matches everything it does.
+	FindingRedundant
+	// FindingOverlap is a rule that partially overlaps an earlier rule with a
+	// different outbound, so that some of its connections (certain ports, or
+	// at certain times) go to the earlier outbound. Rules that are more general
+	// than an earlier rule (such as "proxy(suffix:google.com)" after
+	// "direct(www.google.com)") are not reported, as exceptions followed by a
+	// general rule are common.
+	FindingOverlap
+)
+
+func (k FindingKind) String() string {
+	switch k {
+	case FindingUnreachable:
+		return "unreachable"
+	case FindingDuplicate:
+		return "duplicate"
+	case FindingShadowed:
+		return "shadowed"
+	case FindingRedundant:
+		return "redundant"
+	case FindingOverlap:
+		return "overlap"
+	default:
+		return fmt.Sprintf("FindingKind(%d)", int(k))
+	}
+}
+
+// Finding is a problem with a rule found by Analyze.
+type Finding struct {
+	Kind    FindingKind
+	Rule    TextRule // the rule with the problem
+	Earlier TextRule // the earlier rule causing it
+	Message string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s at %s: %s", f.Kind, formatLocation(f.Rule.Source, f.Rule.LineNum, f.Rule.Column), f.Message)
+}
+
+// Analyze compiles rules and reports rules that are hidden by earlier ones,
+// since only the first matching rule is used. At most one finding is reported
+// per rule, in rule order.
+//
+// The analysis is conservative: a rule is only reported as shadowed if it can
+// be shown from the addresses alone (domains, IPs and CIDRs, identical
+// GeoIP/GeoSite entries, address sets, and and/or combinations of them), so
+// not every shadowed rule is found. Earlier rules with a schedule never
+// shadow later ones.
+//
+// Outbound names are not checked. Errors in the rules are returned as by
+// Compile, including with WithAllErrors.
+func Analyze(rules []TextRule, geoLoader GeoLoader, opts ...Option) ([]Finding, error) {
+	o := newOptions(opts)
+	outbounds := make(map[string]string)
+	for _, r := range rules {
+		outbounds[strings.ToLower(r.Outbound)] = strings.ToLower(r.Outbound)
+	}
+	compiled, _, err := compileRules(rules, outbounds, geoLoader, o)
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+	report := func(kind FindingKind, r, earlier *compiledRule[string], format string, args ...any) {
+		findings = append(findings, Finding{
+			Kind:    kind,
+			Rule:    r.Text,
+			Earlier: earlier.Text,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+	var catchAll *compiledRule[string]
+	for i := range compiled {
+		r := &compiled[i]
+		if catchAll != nil {
+			report(FindingUnreachable, r, catchAll, "rule is unreachable, %s matches everything", ruleRef(catchAll))
+			continue
+		}
+		if r.Schedule == nil && r.ProtoPort.matchesAll() && hostCovers(r.HostMatcher, &allMatcher{}) {
+			catchAll = r
+		}
+		reported := false
+		for j := range compiled[:i] {
+			e := &compiled[j]
+			switch {
+			case sameRule(e, r):
+				report(FindingDuplicate, r, e, "duplicate of %s", ruleRef(e))
+			case e.Schedule != nil:
+				continue
+			case !ruleCovers(e, r):
+				continue
+			case e.Outbound == r.Outbound && e.HijackAddress.Equal(r.HijackAddress):
+				report(FindingRedundant, r, e, "rule is redundant, %s already matches everything it matches", ruleRef(e))
+			default:
+				report(FindingShadowed, r, e, "rule never matches, %s (%s) matches everything it matches",
+					ruleRef(e), e.Text.Outbound)
+			}
+			reported = true
+			break
+		}
+		if reported {
+			continue
+		}
+		for j := range compiled[:i] {
+			e := &compiled[j]
+			if e.Outbound != r.Outbound && partiallyShadows(e, r) {
+				report(FindingOverlap, r, e, "rule partially overlaps %s, which sends part of its traffic to %s",
+					ruleRef(e), e.Text.Outbound)
+				break
+			}
+		}
+	}
+	return findings, nil
+}
+
+// ruleRef refers to a rule in finding messages.
+func ruleRef(r *compiledRule[string]) string {
+	return formatLocation(r.Text.Source, r.Text.LineNum, 0)
+}
+
+// sameRule reports whether two rules are written the same, ignoring case
+// and whitespace where it does not matter.
+func sameRule(a, b *compiledRule[string]) bool {
+	norm := func(s string) string {
+		return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+	}
+	return a.Outbound == b.Outbound &&
+		strings.EqualFold(a.Text.Address, b.Text.Address) &&
+		// Some addresses are case-sensitive (user:, regexp:)
+		hostCovers(a.HostMatcher, b.HostMatcher) && hostCovers(b.HostMatcher, a.HostMatcher) &&
+		norm(a.Text.ProtoPort) == norm(b.Text.ProtoPort) &&
+		a.HijackAddress.Equal(b.HijackAddress) &&
+		norm(a.Text.Schedule) == norm(b.Text.Schedule)
+}
+
+// ruleCovers reports whether rule a matches every connection that rule b
+// matches. It may return false negatives.
+func ruleCovers(a, b *compiledRule[string]) bool {
+	return a.Schedule == nil && a.ProtoPort.covers(&b.ProtoPort) && hostCovers(a.HostMatcher, b.HostMatcher)
+}
+
+// partiallyShadows reports whether the earlier rule a takes some, but not all,
+// of the connections of the later rule b: a matches all hosts of b but only
+// some of its protocols and ports (or only at some times), and b is not simply
+// a more general rule following a as an exception.
+func partiallyShadows(a, b *compiledRule[string]) bool {
+	return hostCovers(a.HostMatcher, b.HostMatcher) && a.ProtoPort.overlaps(&b.ProtoPort) &&
+		!(b.ProtoPort.covers(&a.ProtoPort) && hostCovers(b.HostMatcher, a.HostMatcher))
+}
+
+// matchesAll reports whether the filter passes every protocol and port.
+func (f *protoPortFilter) matchesAll() bool {
+	return f.covers(&anyProtoPort)
+}
+
+// covers reports whether f passes every protocol and port that g passes.
+func (f *protoPortFilter) covers(g *protoPortFilter) bool {
+	fs, gs := f.portSets(), g.portSets()
+	for i := range fs {
+		if !gs[i].subsetOf(fs[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// overlaps reports whether some protocol and port pass both f and g.
+func (f *protoPortFilter) overlaps(g *protoPortFilter) bool {
+	fs, gs := f.portSets(), g.portSets()
+	for i := range fs {
+		if len(fs[i].intersect(gs[i])) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hostCovers reports whether a matches every host that b matches.
+// It may return false negatives, but never false positives.
+func hostCovers(a, b hostMatcher) bool {
+	if _, ok := a.(*allMatcher); ok || a == b {
+		return true
+	}
+	// Decompose b first where that is exact, then a
+	if bo, ok := b.(*orMatcher); ok {
+		for _, m := range bo.Matchers {
+			if !hostCovers(a, m) {
+				return false
+			}
+		}
+		return true
+	}
+	if aa, ok := a.(*andMatcher); ok {
+		for _, m := range aa.Matchers {
+			if !hostCovers(m, b) {
+				return false
+			}
+		}
+		return true
+	}
+	if ba, ok := b.(*andMatcher); ok {
+		for _, m := range ba.Matchers {
+			if hostCovers(a, m) {
+				return true
+			}
+		}
+	}
+	if ao, ok := a.(*orMatcher); ok {
+		for _, m := range ao.Matchers {
+			if hostCovers(m, b) {
+				return true
+			}
+		}
+		return false
+	}
+	switch am := a.(type) {
+	case *inverseMatcher:
+		if bm, ok := b.(*inverseMatcher); ok {
+			// !x covers !y if y covers x
+			return hostCovers(bm.Matcher, am.Matcher)
+		}
+	case *ipMatcher:
+		if bm, ok := b.(*ipMatcher); ok {
+			return am.IP == bm.IP
+		}
+	case *cidrMatcher:
+		return prefixCovers(am.Prefix, b)
+	case *srcMatcher:
+		if bm, ok := b.(*srcMatcher); ok {
+			return prefixContains(am.Prefix, bm.Prefix)
+		}
+	case *domainMatcher:
+		if bm, ok := b.(*domainMatcher); ok {
+			return domainCovers(am, bm)
+		}
+	}
+	// Anything else (GeoIP, GeoSite, inbound, user...) only covers itself
+	return reflect.TypeOf(a) == reflect.TypeOf(b) && reflect.DeepEqual(a, b)
+}
+
+// prefixCovers reports whether every IP matched by b is in p.
+func prefixCovers(p netip.Prefix, b hostMatcher) bool {
+	switch bm := b.(type) {
+	case *ipMatcher:
+		return p.Contains(bm.IP)
+	case *cidrMatcher:
+		return prefixContains(p, bm.Prefix)
+	default:
+		return false
+	}
+}
+
+// prefixContains reports whether p contains all of q.
+func prefixContains(p, q netip.Prefix) bool {
+	return p.Bits() <= q.Bits() && p.Contains(q.Addr())
+}
+
+// domainCovers reports whether a matches every domain that b matches.
+func domainCovers(a, b *domainMatcher) bool {
+	switch a.Mode {
+	case domainMatchExact:
+		return b.Mode == domainMatchExact && a.Pattern == b.Pattern
+	case domainMatchWildcard:
+		switch b.Mode {
+		case domainMatchExact:
+			return domain.MatchWildcard(a.Pattern, b.Pattern)
+		case domainMatchWildcard:
+			return a.Pattern == b.Pattern
+		}
+	case domainMatchSuffix:
+		if strings.Contains(a.Pattern, "*") {
+			return false
+		}
+		switch b.Mode {
+		case domainMatchExact, domainMatchSuffix:
+			return b.Pattern == a.Pattern || strings.HasSuffix(b.Pattern, "."+a.Pattern)
+		case domainMatchWildcard:
+			// The end of the pattern is literal, so every match ends with it
+			return strings.HasSuffix(b.Pattern, "."+a.Pattern)
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package acl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyze(t *testing.T) {
+	type finding struct {
+		Kind        FindingKind
+		Line        int
+		EarlierLine int
+	}
+	tests := []struct {
+		name string
+		text string
+		want []finding
+	}{
+		{
+			name: "clean",
+			text: "direct(www.google.com)\nproxy(suffix:google.com)\nreject(all, udp/443)\nproxy(all)",
+			want: nil,
+		},
+		{
+			name: "catch-all",
+			text: "direct(all)\nreject(example.com)\nproxy(all, tcp)",
+			want: []finding{{FindingUnreachable, 2, 1}, {FindingUnreachable, 3, 1}},
+		},
+		{
+			name: "catch-all with port filter is not",
+			text: "direct(all, tcp)\nreject(example.com, udp)",
+			want: nil,
+		},
+		{
+			name: "duplicate",
+			text: "direct(example.com, TCP/443)\ndirect(example.com, tcp/443)",
+			want: []finding{{FindingDuplicate, 2, 1}},
+		},
+		{
+			name: "shadowed domain",
+			text: "direct(suffix:google.com)\nproxy(www.google.com)\nproxy(*.mail.google.com)\nproxy(google.com)",
+			want: []finding{{FindingShadowed, 2, 1}, {FindingShadowed, 3, 1}, {FindingShadowed, 4, 1}},
+		},
+		{
+			name: "wildcard",
+			text: "direct(*.example.com)\nproxy(www.example.com)\nproxy(example.com)",
+			want: []finding{{FindingShadowed, 2, 1}},
+		},
+		{
+			name: "redundant",
+			text: "direct(10.0.0.0/8)\ndirect(10.1.0.0/16, tcp)\ndirect(10.1.2.3)",
+			want: []finding{{FindingRedundant, 2, 1}, {FindingRedundant, 3, 1}},
+		},
+		{
+			name: "different hijack address is not redundant",
+			text: "direct(all, udp/53)\ndirect(10.0.0.1, udp/53, 127.0.0.1)",
+			want: []finding{{FindingShadowed, 2, 1}},
+		},
+		{
+			name: "ports",
+			text: `reject(all, "tcp/25,465,587")` + "\nreject(example.com, tcp/465)\nreject(example.com, tcp/443)\nreject(all, !tcp)\nproxy(1.1.1.1, udp/53)",
+			want: []finding{{FindingRedundant, 2, 1}, {FindingShadowed, 5, 4}},
+		},
+		{
+			name: "geo and sets",
+			text: "@lan = 10.0.0.0/8, 192.168.0.0/16\nproxy(geoip:us)\ndirect(@lan)\nproxy(geoip:US, tcp)\nproxy(192.168.1.0/24)\nproxy(!geoip:us)\ndirect(@LAN)",
+			want: []finding{{FindingRedundant, 4, 2}, {FindingShadowed, 5, 3}, {FindingDuplicate, 7, 3}},
+		},
+		{
+			name: "composite",
+			text: "direct(or(suffix:a.com, suffix:b.com))\nproxy(and(x.a.com, geoip:us))\nproxy(or(a.com, b.com))\nproxy(or(a.com, c.com))",
+			want: []finding{{FindingShadowed, 2, 1}, {FindingShadowed, 3, 1}},
+		},
+		{
+			name: "schedule only overlaps",
+			text: `reject(all, schedule="mon-fri 09:00-17:00 UTC")` + "\ndirect(example.com)",
+			want: []finding{{FindingOverlap, 2, 1}},
+		},
+		{
+			name: "overlap",
+			text: "proxy(10.0.0.0/8, tcp/443)\ndirect(10.1.0.0/16, \"tcp/80,443\")\ndirect(10.0.0.0/8, tcp)",
+			want: []finding{{FindingOverlap, 2, 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseTextRules(tt.text)
+			require.NoError(t, err)
+			findings, err := Analyze(rules, newTestGeoLoader())
+			require.NoError(t, err)
+			var got []finding
+			for _, f := range findings {
+				got = append(got, finding{f.Kind, f.Rule.LineNum, f.Earlier.LineNum})
+			}
+			assert.Equal(t, tt.want, got, "%v", findings)
+		})
+	}
+}
+
+func TestAnalyze_Messages(t *testing.T) {
+	rules, err := ParseTextRules("direct(suffix:google.com)\nproxy(www.google.com)", WithSource("test.acl"))
+	require.NoError(t, err)
+	findings, err := Analyze(rules, newTestGeoLoader())
+	require.NoError(t, err)
+	require.Len(t, findings, 1)
+	assert.Equal(t, "shadowed at test.acl line 2, column 1: rule never matches, test.acl line 1 (direct) matches everything it matches",
+		findings[0].String())
+}
+
+func TestAnalyze_Errors(t *testing.T) {
+	rules, err := ParseTextRules("direct(geoip:cm)\nproxy(10.0.0.0/33)")
+	require.NoError(t, err)
+	_, err = Analyze(rules, newTestGeoLoader(), WithAllErrors())
+	var errs ErrorList
+	require.ErrorAs(t, err, &errs)
+	assert.Len(t, errs, 2)
+}
+
+func Test_portSet_complement(t *testing.T) {
+	tests := []struct {
+		name string
+		s    portSet
+		want portSet
+	}{
+		{"empty", nil, allPorts},
+		{"all", allPorts, nil},
+		{"middle", portSet{{80, 80}, {443, 443}}, portSet{{0, 79}, {81, 442}, {444, 65535}}},
+		{"edges", portSet{{0, 10}, {65000, 65535}}, portSet{{11, 64999}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.s.complement())
+			assert.Equal(t, tt.s, tt.s.complement().complement())
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package acl
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+)
+
+// minShardSize is the smallest number of results a cache shard holds by
+// default. Smaller caches get fewer shards, so that results are not evicted
+// much earlier than the cache size suggests.
+const minShardSize = 64
+
+// CacheStats is a snapshot of the statistics of the match cache of a
+// CompiledRuleSet. It is empty if caching is disabled.
+type CacheStats struct {
+	Len      int // cached results
+	Capacity int // maximum number of cached results
+	Shards   int
+
+	Hits      uint64 // matches served from the cache
+	Misses    uint64 // matches that evaluated the rules
+	Evictions uint64 // results evicted to make room for others
+	Expired   uint64 // results dropped because they were older than the TTL
+}
+
+// HitRate returns the fraction of matches served from the cache, 0 if there
+// were none.
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// matchCache is an LRU cache of match results, split into shards by key, each
+// with its own lock, so that concurrent matches rarely wait for each other.
+// Each shard evicts its own least recently used results.
+type matchCache[O Outbound] struct {
+	shards    []cacheShard[O]
+	shardSize int // capacity of each shard
+	seed      maphash.Seed
+	ttl       time.Duration // 0 if results do not expire
+}
+
+type cacheShard[O Outbound] struct {
+	mu  sync.Mutex
+	lru *simplelru.LRU[matchResultCacheKey, cacheEntry[O]]
+
+	hits, misses, evictions, expired uint64
+
+	_ [64]byte // keep the locks of different shards on different cache lines
+}
+
+type cacheEntry[O Outbound] struct {
+	result  matchResult[O]
+	expires int64 // unix nanoseconds, 0 if it does not expire
+}
+
+// newMatchCache returns a cache of at least size results split into the given
+// number of shards (rounded up to a power of two), or a number based on
+// GOMAXPROCS if shards is 0.
+func newMatchCache[O Outbound](size, shards int, ttl time.Duration) (*matchCache[O], error) {
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0) * 4
+		for shards > 1 && size/shards < minShardSize {
+			shards /= 2
+		}
+	}
+	shards = 1 << bits.Len(uint(shards-1))
+	for shards > 1 && shards > size {
+		shards /= 2
+	}
+	c := &matchCache[O]{
+		shards:    make([]cacheShard[O], shards),
+		shardSize: (size + shards - 1) / shards,
+		seed:      maphash.MakeSeed(),
+		ttl:       ttl,
+	}
+	for i := range c.shards {
+		l, err := simplelru.NewLRU[matchResultCacheKey, cacheEntry[O]](c.shardSize, nil)
+		if err != nil {
+			return nil, err
+		}
+		c.shards[i].lru = l
+	}
+	return c, nil
+}
+
+func (c *matchCache[O]) shard(key matchResultCacheKey) *cacheShard[O] {
+	if len(c.shards) == 1 {
+		return &c.shards[0]
+	}
+	h := maphash.Comparable(c.seed, key)
+	return &c.shards[h&uint64(len(c.shards)-1)]
+}
+
+// Get returns the result cached for key, if it was computed in the given
+// schedule epoch and has not expired at now.
+func (c *matchCache[O]) Get(key matchResultCacheKey, epoch uint64, now time.Time) (matchResult[O], bool) {
+	sh := c.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	e, ok := sh.lru.Get(key)
+	if ok && e.expires != 0 && now.UnixNano() >= e.expires {
+		sh.lru.Remove(key)
+		sh.expired++
+		ok = false
+	}
+	if !ok || e.result.Epoch != epoch {
+		sh.misses++
+		return matchResult[O]{}, false
+	}
+	sh.hits++
+	return e.result, true
+}
+
+// Add caches the result for key, computed at now.
+func (c *matchCache[O]) Add(key matchResultCacheKey, result matchResult[O], now time.Time) {
+	e := cacheEntry[O]{result: result}
+	if c.ttl > 0 {
+		e.expires = now.Add(c.ttl).UnixNano()
+	}
+	sh := c.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.lru.Add(key, e) {
+		sh.evictions++
+	}
+}
+
+// Purge removes all results from the cache. The statistics are kept.
+func (c *matchCache[O]) Purge() {
+	for i := range c.shards {
+		sh := &c.shards[i]
+		sh.mu.Lock()
+		sh.lru.Purge()
+		sh.mu.Unlock()
+	}
+}
+
+// Stats returns the statistics of the cache, adding up those of the shards.
+func (c *matchCache[O]) Stats() CacheStats {
+	stats := CacheStats{Capacity: len(c.shards) * c.shardSize, Shards: len(c.shards)}
+	for i := range c.shards {
+		sh := &c.shards[i]
+		sh.mu.Lock()
+		stats.Len += sh.lru.Len()
+		stats.Hits += sh.hits
+		stats.Misses += sh.misses
+		stats.Evictions += sh.evictions
+		stats.Expired += sh.expired
+		sh.mu.Unlock()
+	}
+	return stats
+}
+
+func (s *compiledRuleSetImpl[O]) CacheStats() CacheStats {
+	if s.cache == nil {
+		return CacheStats{}
+	}
+	return s.cache.Stats()
+}
@@ -0,0 +1,172 @@
+package acl
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func compileCacheTestRules(t testing.TB, cacheSize int, opts ...Option) *compiledRuleSetImpl[string] {
+	rules, err := ParseTextRules(`
+direct(10.0.0.0/8)
+proxy(suffix:example.com)
+reject(all)
+`)
+	require.NoError(t, err)
+	rs, err := Compile(rules, map[string]string{"direct": "DIRECT", "proxy": "PROXY", "reject": "REJECT"},
+		cacheSize, &NilGeoLoader{}, opts...)
+	require.NoError(t, err)
+	return rs.(*compiledRuleSetImpl[string])
+}
+
+func TestMatchCache_Shards(t *testing.T) {
+	tests := []struct {
+		size, shards int
+		wantShards   int
+		wantCapacity int
+	}{
+		{16, 0, 1, 16}, // small caches are not sharded
+		{100, 1, 1, 100},
+		{100, 3, 4, 100}, // rounded up to a power of two
+		{100, 6, 8, 104}, // each shard holds 13
+		{4, 16, 4, 4},    // at least one result per shard
+		{1, 0, 1, 1},
+		{4096, 8, 8, 4096},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/%d", tt.size, tt.shards), func(t *testing.T) {
+			c, err := newMatchCache[string](tt.size, tt.shards, 0)
+			require.NoError(t, err)
+			stats := c.Stats()
+			assert.Equal(t, tt.wantShards, stats.Shards)
+			assert.Equal(t, tt.wantCapacity, stats.Capacity)
+		})
+	}
+
+	c, err := newMatchCache[string](1<<20, 0, 0)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, c.Stats().Shards, 1)
+	assert.LessOrEqual(t, c.Stats().Capacity, 1<<20+c.Stats().Shards)
+}
+
+func TestCompiledRuleSet_CacheStats(t *testing.T) {
+	rs := compileCacheTestRules(t, 2)
+	match := func(name string) string {
+		got, _ := rs.MatchHost(Host{Name: name}, ProtocolTCP, 443)
+		return got
+	}
+	assert.Equal(t, "PROXY", match("a.example.com"))
+	assert.Equal(t, "PROXY", match("a.example.com"))
+	assert.Equal(t, "REJECT", match("b.com"))
+	assert.Equal(t, "REJECT", match("c.com")) // evicts a.example.com
+	assert.Equal(t, "PROXY", match("A.Example.com"))
+
+	stats := rs.CacheStats()
+	assert.Equal(t, CacheStats{Len: 2, Capacity: 2, Shards: 1, Hits: 1, Misses: 4, Evictions: 2}, stats)
+	assert.InDelta(t, 0.2, stats.HitRate(), 1e-9)
+	assert.Zero(t, CacheStats{}.HitRate())
+}
+
+func TestCompiledRuleSet_CacheTTL(t *testing.T) {
+	rs := compileCacheTestRules(t, 16, WithCacheTTL(time.Minute))
+	now := time.Date(2024, 6, 7, 12, 0, 0, 0, time.UTC)
+	rs.Now = func() time.Time { return now }
+	host := HostInfo{Name: "www.example.com"}
+
+	steps := []struct {
+		now        time.Time
+		wantCached bool
+	}{
+		{now, false},
+		{now.Add(59 * time.Second), true},
+		{now.Add(time.Minute), false}, // expired
+		{now.Add(90 * time.Second), true},
+		{now.Add(3 * time.Minute), false},
+	}
+	for i, step := range steps {
+		now = step.now
+		d := rs.Explain(host, ProtocolTCP, 443)
+		assert.Equal(t, "PROXY", d.Outbound, "step %d", i)
+		assert.Equal(t, step.wantCached, d.Cached, "step %d", i)
+	}
+	stats := rs.CacheStats()
+	assert.Equal(t, uint64(2), stats.Expired)
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(3), stats.Misses)
+	assert.Equal(t, 1, stats.Len)
+}
+
+func TestCompiledRuleSet_NoCache(t *testing.T) {
+	rs := compileCacheTestRules(t, 0, WithHitCounters())
+	host := HostInfo{Name: "www.example.com"}
+	for range 3 {
+		d := rs.Explain(host, ProtocolTCP, 443)
+		assert.Equal(t, "PROXY", d.Outbound)
+		assert.False(t, d.Cached)
+		got, _ := rs.Match(host, ProtocolTCP, 443)
+		assert.Equal(t, "PROXY", got)
+	}
+	assert.Equal(t, CacheStats{}, rs.CacheStats())
+	assert.Equal(t, uint64(3), rs.HitStats().Rules[1].Hits)
+
+	_, err := Compile(nil, map[string]string{}, -1, &NilGeoLoader{})
+	assert.ErrorContains(t, err, "invalid cache size")
+}
+
+func TestCompiledRuleSet_CacheConcurrent(t *testing.T) {
+	rs := compileCacheTestRules(t, 256, WithCacheShards(8), WithCacheTTL(time.Hour))
+	var wg sync.WaitGroup
+	for g := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 1000 {
+				ip := netip.AddrFrom4([4]byte{10, 0, byte(g), byte(i)})
+				got, _ := rs.MatchHost(Host{IPv4: ip}, ProtocolTCP, 443)
+				assert.Equal(t, "DIRECT", got)
+				got, _ = rs.MatchHost(Host{Name: fmt.Sprintf("h%d.example.com", i%300)}, ProtocolUDP, 53)
+				assert.Equal(t, "PROXY", got)
+			}
+		}()
+	}
+	wg.Wait()
+	stats := rs.CacheStats()
+	assert.Equal(t, 8, stats.Shards)
+	assert.Equal(t, uint64(32000), stats.Hits+stats.Misses)
+	assert.LessOrEqual(t, stats.Len, stats.Capacity)
+	assert.Positive(t, stats.Evictions)
+}
+
+func TestCompiledRuleSet_MatchShardedCacheAllocs(t *testing.T) {
+	rs := compileCacheTestRules(t, 4096, WithCacheShards(16), WithCacheTTL(time.Hour))
+	host := Host{Name: "www.example.com", IPv4: netip.MustParseAddr("93.184.216.34")}
+	rs.MatchHost(host, ProtocolTCP, 443)
+	allocs := testing.AllocsPerRun(100, func() {
+		rs.MatchHost(host, ProtocolTCP, 443)
+	})
+	assert.Zero(t, allocs)
+}
+
+func BenchmarkCompiledRuleSet_MatchHostParallel(b *testing.B) {
+	for _, shards := range []int{1, 0} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			rs := compileCacheTestRules(b, 4096, WithCacheShards(shards))
+			hosts := make([]Host, 1024)
+			for i := range hosts {
+				hosts[i] = Host{IPv4: netip.AddrFrom4([4]byte{10, 1, byte(i >> 8), byte(i)})}
+			}
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					rs.MatchHost(hosts[i%len(hosts)], ProtocolTCP, 443)
+					i++
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,460 @@
+package acl
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ParseClashRules converts Clash / Mihomo rules, such as
+//
+//	DOMAIN-SUFFIX,google.com,Proxy
+//	IP-CIDR,10.0.0.0/8,DIRECT,no-resolve
+//	AND,((NETWORK,UDP),(DST-PORT,443)),REJECT
+//	MATCH,Proxy
+//
+// into ACL rules. text is either a Clash configuration, whose "rules" list is
+// converted, a YAML list of rules, or one rule per line ('#' starts a comment).
+//
+// The supported rule types are DOMAIN, DOMAIN-SUFFIX, DOMAIN-KEYWORD,
+// DOMAIN-REGEX, DOMAIN-WILDCARD, GEOSITE, GEOIP ("LAN" is "geoip:private"),
+// IP-CIDR, IP-CIDR6, IP-ASN, SRC-IP-CIDR, DST-PORT, NETWORK, IN-NAME, IN-USER,
+// MATCH and the logic rules AND, OR and NOT, as long as the result can be
+// written as an address and a protocol/port list (e.g. OR cannot combine
+// domains with ports). Other rule types (PROCESS-NAME, RULE-SET...) are
+// reported as errors.
+//
+// The "no-resolve" option is accepted and ignored: it only keeps Clash from
+// resolving domains for IP rules, while the router always resolves the host
+// before matching. The "src" option matches the source address, as in Clash.
+//
+// Targets are used as outbound names, except that DIRECT and REJECT (and its
+// REJECT-DROP and REJECT-TINYGIF variants) become the built-in "direct" and
+// "reject". Proxy and group names are kept as written, and must be outbounds
+// of the router (or renamed) for the rules to compile.
+//
+// The rules record their line and column in the text, and WithSource sets
+// their source. By default ParseClashRules stops at the first rule that cannot
+// be converted. With WithAllErrors, it skips such rules and returns the others
+// together with an ErrorList.
+func ParseClashRules(text string, opts ...Option) ([]TextRule, error) {
+	o := newOptions(opts)
+	lines, err := clashRuleLines(text)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]TextRule, 0, len(lines))
+	var errs ErrorList
+	for _, line := range lines {
+		rule, err := convertClashRule(line.Text)
+		if err != nil {
+			err := &InvalidSyntaxError{
+				Source:  o.source,
+				Line:    line.Text,
+				LineNum: line.Pos.Line,
+				Column:  line.Pos.Column,
+				Message: err.Error(),
+			}
+			if !o.allErrors {
+				return nil, err
+			}
+			errs = append(errs, err)
+			continue
+		}
+		rule.Source = o.source
+		rule.LineNum, rule.Column = line.Pos.Line, line.Pos.Column
+		rules = append(rules, rule)
+	}
+	return rules, errs.Err()
+}
+
+// clashLine is a single Clash rule and its position in the text.
+type clashLine struct {
+	Text string
+	Pos  Position
+}
+
+// clashRuleLines extracts the rules from a Clash configuration, a YAML list
+// or plain text.
+func clashRuleLines(text string) ([]clashLine, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(text), &doc); err != nil || len(doc.Content) == 0 {
+		// Not YAML, e.g. because of unquoted rules containing ": "
+		return plainClashRuleLines(text), nil
+	}
+	root := doc.Content[0]
+	switch root.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if root.Content[i].Value == "rules" {
+				return yamlClashRuleLines(root.Content[i+1])
+			}
+		}
+		return nil, fmt.Errorf("no rules found in Clash configuration")
+	case yaml.SequenceNode:
+		return yamlClashRuleLines(root)
+	default:
+		return plainClashRuleLines(text), nil
+	}
+}
+
+func yamlClashRuleLines(list *yaml.Node) ([]clashLine, error) {
+	if list.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("line %d: rules must be a list", list.Line)
+	}
+	lines := make([]clashLine, 0, len(list.Content))
+	for _, item := range list.Content {
+		if item.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("line %d: rule must be a string", item.Line)
+		}
+		lines = append(lines, clashLine{item.Value, Position{item.Line, item.Column}})
+	}
+	return lines, nil
+}
+
+func plainClashRuleLines(text string) []clashLine {
+	var lines []clashLine
+	for i, line := range strings.Split(text, "\n") {
+		if j := strings.IndexByte(line, '#'); j >= 0 {
+			line = line[:j]
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		column := strings.Index(line, trimmed) + 1
+		lines = append(lines, clashLine{trimmed, Position{i + 1, column}})
+	}
+	return lines
+}
+
+// clashOutbounds maps Clash's built-in targets to the built-in outbounds.
+var clashOutbounds = map[string]string{
+	"DIRECT":         "direct",
+	"REJECT":         "reject",
+	"REJECT-DROP":    "reject",
+	"REJECT-TINYGIF": "reject",
+}
+
+// convertClashRule converts a single Clash rule into a TextRule.
+func convertClashRule(line string) (TextRule, error) {
+	fields, err := splitClashRule(line)
+	if err != nil {
+		return TextRule{}, err
+	}
+	if len(fields) < 2 {
+		return TextRule{}, fmt.Errorf("expected TYPE,PAYLOAD,TARGET")
+	}
+	var target string
+	var cond condition
+	if typ := strings.ToUpper(fields[0]); typ == "MATCH" || typ == "FINAL" {
+		if len(fields) > 2 {
+			return TextRule{}, fmt.Errorf("too many fields for %s", typ)
+		}
+		target, cond = fields[1], hostCondition("")
+	} else {
+		if len(fields) < 3 {
+			return TextRule{}, fmt.Errorf("missing target")
+		}
+		target = fields[2]
+		cond, err = convertClashCond(fields[0], fields[1], fields[3:])
+		if err != nil {
+			return TextRule{}, err
+		}
+	}
+	if target == "" {
+		return TextRule{}, fmt.Errorf("empty target")
+	}
+	if ob, ok := clashOutbounds[strings.ToUpper(target)]; ok {
+		target = ob
+	} else if strings.EqualFold(target, "PASS") || strings.EqualFold(target, "COMPATIBLE") {
+		return TextRule{}, fmt.Errorf("unsupported target %s", target)
+	}
+	return cond.textRule(target)
+}
+
+// convertClashCond converts the condition of a Clash rule (or of a sub-rule
+// of a logic rule, which has no target).
+func convertClashCond(typ, payload string, params []string) (condition, error) {
+	typ = strings.ToUpper(typ)
+	noParams := func(c condition, err error) (condition, error) {
+		if err == nil && len(params) > 0 {
+			return condition{}, fmt.Errorf("unknown option %s for %s", params[0], typ)
+		}
+		return c, err
+	}
+	if payload == "" {
+		return condition{}, fmt.Errorf("empty payload")
+	}
+	switch typ {
+	case "DOMAIN":
+		if strings.Contains(payload, "*") {
+			return noParams(hostCondition("full:"+payload), checkDomain(payload))
+		}
+		return noParams(hostCondition(payload), checkDomain(payload))
+	case "DOMAIN-SUFFIX":
+		return noParams(hostCondition("suffix:"+strings.TrimPrefix(payload, ".")), checkDomain(payload))
+	case "DOMAIN-KEYWORD":
+		return noParams(hostCondition("keyword:"+payload), checkDomain(payload))
+	case "DOMAIN-REGEX":
+		return noParams(hostCondition("regexp:"+payload), nil)
+	case "DOMAIN-WILDCARD":
+		return noParams(hostCondition(payload), checkDomain(payload))
+	case "GEOSITE":
+		return noParams(hostCondition("geosite:"+strings.ToLower(payload)), nil)
+	case "GEOIP", "IP-CIDR", "IP-CIDR6", "IP-ASN":
+		return convertClashIPCond(typ, payload, params)
+	case "SRC-IP-CIDR":
+		if _, _, err := net.ParseCIDR(payload); err != nil {
+			return condition{}, fmt.Errorf("invalid CIDR %s", payload)
+		}
+		return noParams(hostCondition("src:"+payload), nil)
+	case "DST-PORT":
+		ports, err := parseProtoPort("*/" + strings.ReplaceAll(payload, "/", ","))
+		if err != nil {
+			return condition{}, fmt.Errorf("invalid port %s: %w", payload, err)
+		}
+		return noParams(condition{Ports: ports}, nil)
+	case "NETWORK":
+		proto := strings.ToLower(payload)
+		if proto != "tcp" && proto != "udp" {
+			return condition{}, fmt.Errorf("invalid network %s (expected TCP or UDP)", payload)
+		}
+		ports, _ := parseProtoPort(proto)
+		return noParams(condition{Ports: ports}, nil)
+	case "IN-NAME":
+		return noParams(clashHostList("inbound:", payload), nil)
+	case "IN-USER":
+		return noParams(clashHostList("user:", payload), nil)
+	case "AND", "OR", "NOT":
+		return noParams(convertClashLogic(typ, payload))
+	default:
+		return condition{}, fmt.Errorf("unsupported rule type %s", typ)
+	}
+}
+
+// convertClashIPCond converts the rule types that accept the no-resolve and
+// src options.
+func convertClashIPCond(typ, payload string, params []string) (condition, error) {
+	src := false
+	for _, p := range params {
+		switch strings.ToLower(p) {
+		case "no-resolve":
+		case "src":
+			src = true
+		default:
+			return condition{}, fmt.Errorf("unknown option %s for %s", p, typ)
+		}
+	}
+	switch typ {
+	case "GEOIP":
+		if src {
+			return condition{}, fmt.Errorf("unsupported option src for GEOIP")
+		}
+		if strings.EqualFold(payload, "LAN") {
+			return hostCondition("geoip:private"), nil
+		}
+		return hostCondition("geoip:" + strings.ToLower(payload)), nil
+	case "IP-ASN":
+		if src {
+			return condition{}, fmt.Errorf("unsupported option src for IP-ASN")
+		}
+		return hostCondition("asn:" + payload), nil
+	default:
+		if _, _, err := net.ParseCIDR(payload); err != nil {
+			return condition{}, fmt.Errorf("invalid CIDR %s", payload)
+		}
+		if src {
+			return hostCondition("src:" + payload), nil
+		}
+		return hostCondition(payload), nil
+	}
+}
+
+// convertClashLogic converts the sub-rules of an AND, OR or NOT rule, e.g.
+// "((DOMAIN,baidu.com),(NETWORK,UDP))".
+func convertClashLogic(typ, payload string) (condition, error) {
+	inner, ok := cutParens(payload)
+	if !ok {
+		return condition{}, fmt.Errorf("invalid %s payload %s (expected ((TYPE,PAYLOAD),...))", typ, payload)
+	}
+	items, err := splitClashSubRules(typ, inner)
+	if err != nil {
+		return condition{}, err
+	}
+	conds := make([]condition, len(items))
+	for i, item := range items {
+		sub, ok := cutParens(item)
+		if !ok {
+			return condition{}, fmt.Errorf("invalid %s sub-rule %s (expected (TYPE,PAYLOAD))", typ, item)
+		}
+		var fields []string
+		if subTyp, regex, ok := cutClashRegex(sub); ok {
+			fields = []string{subTyp, regex}
+		} else if fields, err = splitClashFields(sub); err != nil {
+			return condition{}, err
+		}
+		if len(fields) < 2 {
+			return condition{}, fmt.Errorf("invalid %s sub-rule %s (expected (TYPE,PAYLOAD))", typ, item)
+		}
+		if conds[i], err = convertClashCond(fields[0], fields[1], fields[2:]); err != nil {
+			return condition{}, err
+		}
+	}
+	switch typ {
+	case "AND":
+		return andConditions(conds), nil
+	case "OR":
+		return orConditions(conds)
+	default:
+		if len(conds) != 1 {
+			return condition{}, fmt.Errorf("NOT takes exactly one sub-rule")
+		}
+		return notCondition(conds[0])
+	}
+}
+
+// clashHostList converts a '/'-separated list of names, e.g. "alice/bob"
+// for IN-USER, into a single address.
+func clashHostList(prefix, payload string) condition {
+	names := strings.Split(payload, "/")
+	addrs := make([]string, len(names))
+	for i, name := range names {
+		addrs[i] = prefix + strings.TrimSpace(name)
+	}
+	return hostCondition(joinAddresses("or", addrs))
+}
+
+// splitClashRule splits a Clash rule into its fields. The payload of a logic
+// rule runs up to its last parenthesis, and that of a DOMAIN-REGEX rule up to
+// the comma before the target, as regular expressions may contain commas and
+// unbalanced (escaped) parentheses.
+func splitClashRule(line string) ([]string, error) {
+	typ, rest, _ := strings.Cut(line, ",")
+	typ = strings.TrimSpace(typ)
+	switch strings.ToUpper(typ) {
+	case "AND", "OR", "NOT":
+		end := strings.LastIndexByte(rest, ')')
+		if end < 0 {
+			break
+		}
+		fields := []string{typ, strings.TrimSpace(rest[:end+1])}
+		if after := strings.TrimSpace(rest[end+1:]); after != "" {
+			after, ok := strings.CutPrefix(after, ",")
+			if !ok {
+				return nil, fmt.Errorf("expected ',' after %s payload", typ)
+			}
+			for _, f := range strings.Split(after, ",") {
+				fields = append(fields, strings.TrimSpace(f))
+			}
+		}
+		return fields, nil
+	case "DOMAIN-REGEX":
+		if end := strings.LastIndexByte(rest, ','); end >= 0 {
+			return []string{typ, strings.TrimSpace(rest[:end]), strings.TrimSpace(rest[end+1:])}, nil
+		}
+	}
+	return splitClashFields(line)
+}
+
+// splitClashSubRules splits the sub-rules of a logic rule, "(A,a),(B,b)", at
+// the "),(" between them. Parentheses are counted to skip over those of nested
+// logic rules, but not in DOMAIN-REGEX payloads, which end at the next "),(".
+func splitClashSubRules(typ, s string) ([]string, error) {
+	var items []string
+	for s = strings.TrimSpace(s); s != ""; {
+		if s[0] != '(' {
+			return nil, fmt.Errorf("invalid %s sub-rule %s (expected (TYPE,PAYLOAD))", typ, s)
+		}
+		end := -1
+		if _, _, ok := cutClashRegex(s[1:]); ok {
+			// Up to the ')' before the next sub-rule, or the last one
+			end = len(s) - 1
+			for i := 1; i < len(s)-1; i++ {
+				if s[i] == ')' && startsSubRule(s[i+1:]) {
+					end = i
+					break
+				}
+			}
+		} else {
+			depth := 0
+			for i := 0; i < len(s) && end < 0; i++ {
+				switch s[i] {
+				case '(':
+					depth++
+				case ')':
+					if depth--; depth == 0 {
+						end = i
+					}
+				}
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses")
+			}
+		}
+		items = append(items, s[:end+1])
+		s = strings.TrimSpace(s[end+1:])
+		if s != "" {
+			rest, ok := strings.CutPrefix(s, ",")
+			if !ok {
+				return nil, fmt.Errorf("invalid %s sub-rule %s (expected (TYPE,PAYLOAD))", typ, items[len(items)-1]+s)
+			}
+			s = strings.TrimSpace(rest)
+		}
+	}
+	return items, nil
+}
+
+// startsSubRule reports whether s is a comma followed by another sub-rule.
+func startsSubRule(s string) bool {
+	s, ok := strings.CutPrefix(strings.TrimSpace(s), ",")
+	return ok && strings.HasPrefix(strings.TrimSpace(s), "(")
+}
+
+// cutClashRegex splits a DOMAIN-REGEX rule without target, "DOMAIN-REGEX,re",
+// into its type and payload, everything after the first comma.
+func cutClashRegex(rule string) (typ, regex string, ok bool) {
+	typ, regex, found := strings.Cut(rule, ",")
+	typ = strings.TrimSpace(typ)
+	if !found || !strings.EqualFold(typ, "DOMAIN-REGEX") {
+		return "", "", false
+	}
+	return typ, strings.TrimSpace(regex), true
+}
+
+// splitClashFields splits a Clash rule at the commas outside parentheses,
+// trimming the fields.
+func splitClashFields(s string) ([]string, error) {
+	var fields []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses")
+			}
+		case ',':
+			if depth == 0 {
+				fields = append(fields, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses")
+	}
+	return append(fields, strings.TrimSpace(s[start:])), nil
+}
+
+// cutParens returns s without the parentheses around it.
+func cutParens(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '(' || s[len(s)-1] != ')' {
+		return "", false
+	}
+	return s[1 : len(s)-1], true
+}
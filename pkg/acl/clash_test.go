@@ -0,0 +1,159 @@
+package acl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClashRules(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string // formatted ACL rule
+	}{
+		{"domain", "DOMAIN,www.google.com,Proxy", "proxy(www.google.com)"},
+		{"suffix", "DOMAIN-SUFFIX,google.com,Proxy", "proxy(suffix:google.com)"},
+		{"keyword", "DOMAIN-KEYWORD,google,Proxy", "proxy(keyword:google)"},
+		{"regex", `DOMAIN-REGEX,^api\.Example\.com$,Proxy`, `proxy(regexp:^api\.Example\.com$)`},
+		{"wildcard", "DOMAIN-WILDCARD,*.google.com,Proxy", "proxy(*.google.com)"},
+		{"geosite", "GEOSITE,Netflix,Proxy", "proxy(geosite:netflix)"},
+		{"geoip", "GEOIP,CN,DIRECT", "direct(geoip:cn)"},
+		{"geoip lan", "GEOIP,LAN,DIRECT,no-resolve", "direct(geoip:private)"},
+		{"cidr", "IP-CIDR,10.0.0.0/8,DIRECT,no-resolve", "direct(10.0.0.0/8)"},
+		{"cidr6", "IP-CIDR6,2620:0:2d0:200::7/32,DIRECT", "direct(2620:0:2d0:200::7/32)"},
+		{"cidr src", "IP-CIDR,192.168.1.0/24,DIRECT,src", "direct(src:192.168.1.0/24)"},
+		{"src cidr", "SRC-IP-CIDR,192.168.1.0/24,DIRECT", "direct(src:192.168.1.0/24)"},
+		{"asn", "IP-ASN,13335,Proxy", "proxy(asn:13335)"},
+		{"port", "DST-PORT,80/443/8000-9000,Proxy", `proxy(all, "*/80,443,8000-9000")`},
+		{"network", "NETWORK,udp,REJECT", "reject(all, udp)"},
+		{"inbound", "IN-NAME,office,DIRECT", "direct(inbound:office)"},
+		{"users", "IN-USER,alice/bob,Proxy", "proxy(or(user:alice, user:bob))"},
+		{"match", "MATCH,Proxy", "proxy(all)"},
+		{"reject drop", "DOMAIN,ads.example.com,REJECT-DROP", "reject(ads.example.com)"},
+		{"spaces", " DOMAIN-SUFFIX , google.com , Proxy ", "proxy(suffix:google.com)"},
+		{"and", "AND,((DOMAIN,example.com),(NETWORK,UDP),(DST-PORT,443)),REJECT", "reject(example.com, udp/443)"},
+		{"and hosts", "AND,((GEOSITE,google),(GEOIP,US,no-resolve)),Proxy", "proxy(and(geosite:google, geoip:us))"},
+		{"or hosts", "OR,((DOMAIN,a.com),(DOMAIN-SUFFIX,b.com)),Proxy", "proxy(or(a.com, suffix:b.com))"},
+		{"or ports", "OR,((NETWORK,UDP),(DST-PORT,53)),DIRECT", "direct(all, tcp/53 udp)"},
+		{"not host", "NOT,((GEOIP,CN)),Proxy", "proxy(!geoip:cn)"},
+		{"not port", "NOT,((DST-PORT,0-1023)),DIRECT", "direct(all, */1024-65535)"},
+		{"nested", "AND,((OR,((DOMAIN,a.com),(DOMAIN,b.com))),(NOT,((NETWORK,TCP)))),REJECT", "reject(or(a.com, b.com), udp)"},
+		{"or regex", `OR,((DOMAIN,a.com),(DOMAIN-REGEX,a(b,c))),Proxy`, `proxy(or(a.com, regexp:a(b,c)))`},
+		{"regex commas", `OR,((DOMAIN-REGEX,^a{1,3}\.com$),(DOMAIN,b.com)),Proxy`, `proxy(or("regexp:^a{1,3}\.com$", b.com))`},
+		{"regex paren", `AND,((DOMAIN-REGEX,^a\(b),(NETWORK,TCP)),Proxy`, `proxy(regexp:^a\(b, tcp)`},
+		{"regex top-level", `DOMAIN-REGEX,^a{1,3}\.com$,Proxy`, `proxy("regexp:^a{1,3}\.com$")`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseClashRules(tt.line)
+			require.NoError(t, err)
+			require.Len(t, rules, 1)
+			assert.Equal(t, tt.want+"\n", Format(rules))
+		})
+	}
+}
+
+func TestParseClashRules_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		wantErr string
+	}{
+		{"unsupported", "PROCESS-NAME,curl,DIRECT", "unsupported rule type PROCESS-NAME"},
+		{"unsupported in logic", "AND,((DOMAIN,a.com),(SRC-PORT,22)),DIRECT", "unsupported rule type SRC-PORT"},
+		{"missing target", "DOMAIN,a.com", "missing target"},
+		{"too few fields", "MATCH", "expected TYPE,PAYLOAD,TARGET"},
+		{"empty payload", "DOMAIN,,DIRECT", "empty payload"},
+		{"invalid cidr", "IP-CIDR,10.0.0.1,DIRECT", "invalid CIDR 10.0.0.1"},
+		{"invalid domain", "DOMAIN-SUFFIX,geoip:cn,DIRECT", "invalid domain geoip:cn"},
+		{"invalid network", "NETWORK,icmp,DIRECT", "invalid network icmp"},
+		{"invalid port", "DST-PORT,http,DIRECT", `invalid port http`},
+		{"unknown option", "DOMAIN,a.com,DIRECT,no-resolve", "unknown option no-resolve for DOMAIN"},
+		{"pass", "DOMAIN,a.com,PASS", "unsupported target PASS"},
+		{"or mixed", "OR,((DOMAIN,a.com),(NETWORK,UDP)),DIRECT", "OR cannot combine host and port sub-rules"},
+		{"not mixed", "NOT,((AND,((DOMAIN,a.com),(NETWORK,UDP)))),DIRECT", "NOT cannot negate"},
+		{"never matches", "AND,((NETWORK,TCP),(NETWORK,UDP)),DIRECT", "rule never matches"},
+		{"bad logic", "AND,(DOMAIN,a.com),DIRECT", "invalid AND sub-rule"},
+		{"unbalanced", "AND,((DOMAIN,a.com),DIRECT", "unbalanced parentheses"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseClashRules(tt.line)
+			var se *InvalidSyntaxError
+			require.ErrorAs(t, err, &se)
+			assert.Equal(t, 1, se.LineNum)
+			assert.Contains(t, se.Message, tt.wantErr)
+		})
+	}
+}
+
+func TestParseClashRules_Config(t *testing.T) {
+	config := `
+mixed-port: 7890
+proxies:
+  - name: hk
+    type: ss
+rules:
+  - DOMAIN-SUFFIX,google.com,Proxy
+  # Comments are skipped
+  - 'IP-CIDR,10.0.0.0/8,DIRECT,no-resolve'
+  - PROCESS-NAME,curl,DIRECT
+  - "MATCH,Proxy"
+`
+	rules, err := ParseClashRules(config, WithSource("config.yaml"), WithAllErrors())
+	var errs ErrorList
+	require.ErrorAs(t, err, &errs)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "invalid syntax at config.yaml line 10, column 5: unsupported rule type PROCESS-NAME: PROCESS-NAME,curl,DIRECT",
+		errs[0].Error())
+
+	require.Len(t, rules, 3)
+	assert.Equal(t, TextRule{Outbound: "Proxy", Address: "suffix:google.com", Source: "config.yaml", LineNum: 7, Column: 5}, rules[0])
+	assert.Equal(t, 9, rules[1].LineNum)
+	assert.Equal(t, 11, rules[2].LineNum)
+
+	_, err = ParseClashRules(config)
+	assert.ErrorContains(t, err, "line 10")
+
+	_, err = ParseClashRules("mixed-port: 7890\n")
+	assert.ErrorContains(t, err, "no rules found")
+}
+
+func TestParseClashRules_PlainLines(t *testing.T) {
+	text := "# Clash rules\nDOMAIN,a.com,DIRECT # comment\n\n  GEOIP,CN,DIRECT\nMATCH,Proxy\n"
+	rules, err := ParseClashRules(text)
+	require.NoError(t, err)
+	require.Len(t, rules, 3)
+	assert.Equal(t, "a.com", rules[0].Address)
+	assert.Equal(t, Position{4, 3}, Position{rules[1].LineNum, rules[1].Column})
+}
+
+func TestParseClashRules_Compiles(t *testing.T) {
+	rules, err := ParseClashRules(`
+- DOMAIN-SUFFIX,google.com,Proxy
+- AND,((DOMAIN,example.com),(NETWORK,UDP),(DST-PORT,443)),REJECT
+- GEOIP,CN,DIRECT
+- MATCH,Proxy
+`)
+	require.NoError(t, err)
+	outbounds := map[string]string{"direct": "DIRECT", "reject": "REJECT", "proxy": "PROXY"}
+	rs, err := Compile(rules, outbounds, 100, newTestGeoLoader())
+	require.NoError(t, err)
+	tests := []struct {
+		host  HostInfo
+		proto Protocol
+		port  uint16
+		want  string
+	}{
+		{HostInfo{Name: "www.google.com"}, ProtocolTCP, 443, "PROXY"},
+		{HostInfo{Name: "example.com"}, ProtocolUDP, 443, "REJECT"},
+		{HostInfo{Name: "example.com"}, ProtocolTCP, 443, "PROXY"},
+		{HostInfo{IPv4: []byte{1, 0, 1, 1}}, ProtocolTCP, 80, "DIRECT"},
+	}
+	for _, tt := range tests {
+		got, _ := rs.Match(tt.host, tt.proto, tt.port)
+		assert.Equal(t, tt.want, got, "%s", tt.host)
+	}
+}
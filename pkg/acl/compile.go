@@ -2,13 +2,19 @@ package acl
 
 import (
 	"fmt"
+	"iter"
 	"net"
+	"net/netip"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/xflash-panda/acl-engine/pkg/acl/geodat"
-
-	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/xflash-panda/acl-engine/pkg/acl/iptree"
+	"github.com/xflash-panda/acl-engine/pkg/acl/mmdb"
 )
 
 type Protocol int
@@ -36,86 +42,304 @@ type Outbound interface {
 	any
 }
 
+// HostInfo describes the destination of a connection, and optionally the
+// session it belongs to. See Host for the same with netip addresses.
 type HostInfo struct {
 	Name string
 	IPv4 net.IP
 	IPv6 net.IP
+
+	// Optional metadata of the session the connection belongs to,
+	// for src:, inbound: and user: conditions.
+	SrcIP   net.IP
+	SrcPort uint16
+	Inbound string // tag of the inbound (listener) the connection came from
+	User    string // authenticated user
 }
 
+// String returns the destination part of the HostInfo.
 func (h HostInfo) String() string {
 	return fmt.Sprintf("%s|%s|%s", h.Name, h.IPv4, h.IPv6)
 }
 
 type CompiledRuleSet[O Outbound] interface {
+	// Match returns the outbound of the first rule matching the connection, and
+	// the IP address to redirect it to, if the rule has one. The port of the
+	// hijack address is ignored, and a domain is returned as nil.
+	//
+	// Deprecated: Use MatchAddress, which returns the whole hijack address.
 	Match(host HostInfo, proto Protocol, port uint16) (O, net.IP)
+	// MatchAddress returns the outbound of the first rule matching the
+	// connection, and the address to redirect it to, if the rule has one.
+	MatchAddress(host HostInfo, proto Protocol, port uint16) (O, *HijackAddress)
+	// MatchHost is like MatchAddress, for a Host. A cached result is returned
+	// without allocating.
+	MatchHost(host Host, proto Protocol, port uint16) (O, *HijackAddress)
+	// Explain is like Match, but also reports which rule matched and why.
+	// With WithTrace, it also reports every rule evaluated.
+	Explain(host HostInfo, proto Protocol, port uint16, opts ...Option) MatchDetail[O]
+	// HitStats returns the hit counters of the rules, if enabled with WithHitCounters.
+	HitStats() HitStats
+	// ResetHitStats resets the hit counters, and returns their values before the reset.
+	ResetHitStats() HitStats
+	// CacheStats returns the size and hit rate of the match cache.
+	CacheStats() CacheStats
 }
 
 type compiledRule[O Outbound] struct {
 	Outbound      O
 	HostMatcher   hostMatcher
-	Protocol      Protocol
-	HasPortFilter bool
-	StartPort     uint16
-	EndPort       uint16
-	HijackAddress net.IP
+	ProtoPort     protoPortFilter
+	HijackAddress *HijackAddress
+	Schedule      *schedule // nil if the rule is always active
+	Text          TextRule  // the rule this was compiled from
 }
 
-func (r *compiledRule[O]) Match(host HostInfo, proto Protocol, port uint16) bool {
-	if r.Protocol != ProtocolBoth && r.Protocol != proto {
-		return false
-	}
-	if r.HasPortFilter && (port < r.StartPort || port > r.EndPort) {
+func (r *compiledRule[O]) Match(host Host, proto Protocol, port uint16) bool {
+	if !r.ProtoPort.Match(proto, port) {
 		return false
 	}
 	return r.HostMatcher.Match(host)
 }
 
+// Active reports whether the schedule of the rule, if any, is active at now.
+func (r *compiledRule[O]) Active(now time.Time) bool {
+	return r.Schedule == nil || r.Schedule.Active(now)
+}
+
 type matchResult[O Outbound] struct {
 	Outbound      O
-	HijackAddress net.IP
+	HijackAddress *HijackAddress
+	Rule          int    // index of the matching rule, -1 if none
+	Epoch         uint64 // schedule epoch the result was computed in
 }
 
 type compiledRuleSetImpl[O Outbound] struct {
-	Rules []compiledRule[O]
-	Cache *lru.Cache[matchResultCacheKey, matchResult[O]] // key: HostInfo.String()
+	Rules   []compiledRule[O]
+	Session sessionUsage // session metadata that is part of the cache key
+
+	// cache caches match results by normalized Host, protocol and port.
+	// nil if caching is disabled.
+	cache *matchCache[O]
+
+	// index indexes the domain and IP rules, so that Match does not evaluate
+	// them one by one. nil if there are none.
+	index *ruleIndex
+
+	// Now returns the current time for rule schedules. nil means time.Now.
+	Now func() time.Time
+	// Cached results are only valid as long as no schedule changes. epoch is
+	// incremented (and the cache purged) at the first Match after nextChange,
+	// the earliest time at which a schedule may change (unix nanoseconds, 0 if
+	// no rule has a schedule).
+	epoch      atomic.Uint64
+	nextChange atomic.Int64
+	scheduleMu sync.Mutex
+
+	// Hit counters, only if enabled with WithHitCounters: one per rule, and
+	// one for connections no rule matched.
+	hits    []atomic.Uint64
+	noMatch atomic.Uint64
+}
+
+func newCompiledRuleSet[O Outbound](rules []compiledRule[O], cacheSize int,
+	session sessionUsage, o *options,
+) (*compiledRuleSetImpl[O], error) {
+	if cacheSize < 0 {
+		return nil, fmt.Errorf("invalid cache size %d", cacheSize)
+	}
+	s := &compiledRuleSetImpl[O]{Rules: rules, Session: session, index: newRuleIndex(rules)}
+	if cacheSize > 0 {
+		cache, err := newMatchCache[O](cacheSize, o.cacheShards, o.cacheTTL)
+		if err != nil {
+			return nil, err
+		}
+		s.cache = cache
+	}
+	if o.hitCounters {
+		s.hits = make([]atomic.Uint64, len(rules))
+	}
+	for _, r := range rules {
+		if r.Schedule != nil {
+			// Computed at the first Match, so that Now can still be replaced
+			s.nextChange.Store(1)
+			break
+		}
+	}
+	return s, nil
+}
+
+// currentEpoch returns the current time and schedule epoch, starting a new
+// epoch if a schedule may have changed since the last call.
+func (s *compiledRuleSetImpl[O]) currentEpoch() (time.Time, uint64) {
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+	next := s.nextChange.Load()
+	if next == 0 || now.UnixNano() < next {
+		return now, s.epoch.Load()
+	}
+	s.scheduleMu.Lock()
+	defer s.scheduleMu.Unlock()
+	if now.UnixNano() < s.nextChange.Load() {
+		// Another goroutine got here first
+		return now, s.epoch.Load()
+	}
+	var earliest time.Time
+	for _, r := range s.Rules {
+		if r.Schedule == nil {
+			continue
+		}
+		if t := r.Schedule.NextChange(now); earliest.IsZero() || t.Before(earliest) {
+			earliest = t
+		}
+	}
+	epoch := s.epoch.Add(1)
+	if s.cache != nil {
+		s.cache.Purge()
+	}
+	s.nextChange.Store(earliest.UnixNano())
+	return now, epoch
 }
 
 type matchResultCacheKey struct {
-	Host  string
+	Name  string
+	IPv4  netip.Addr
+	IPv6  netip.Addr
 	Proto Protocol
 	Port  uint16
+	// Session metadata, only set if used by at least one rule,
+	// so that it does not make caching useless otherwise.
+	SrcIP   netip.Addr
+	Inbound string
+	User    string
+}
+
+// sessionUsage records which session metadata the rules of a set depend on.
+type sessionUsage struct {
+	SrcIP   bool
+	Inbound bool
+	User    bool
 }
 
 func (s *compiledRuleSetImpl[O]) Match(host HostInfo, proto Protocol, port uint16) (O, net.IP) {
-	host.Name = strings.ToLower(host.Name) // Normalize host name to lower case
+	outbound, hijack := s.MatchHost(host.Host(), proto, port)
+	if hijack == nil {
+		return outbound, nil
+	}
+	return outbound, hijack.IP
+}
+
+func (s *compiledRuleSetImpl[O]) MatchAddress(host HostInfo, proto Protocol, port uint16) (O, *HijackAddress) {
+	return s.MatchHost(host.Host(), proto, port)
+}
+
+func (s *compiledRuleSetImpl[O]) MatchHost(host Host, proto Protocol, port uint16) (O, *HijackAddress) {
+	result, _, _ := s.match(&host, proto, port)
+	if s.hits != nil {
+		// Cached results count for the rule they came from
+		if result.Rule >= 0 {
+			s.hits[result.Rule].Add(1)
+		} else {
+			s.noMatch.Add(1)
+		}
+	}
+	return result.Outbound, result.HijackAddress
+}
+
+// match returns the result for a connection, from the cache if possible, and
+// the time it was evaluated at. host is normalized in place.
+func (s *compiledRuleSetImpl[O]) match(host *Host, proto Protocol, port uint16,
+) (result matchResult[O], now time.Time, cached bool) {
+	host.normalize()
+	now, epoch := s.currentEpoch()
+	if s.cache == nil {
+		return s.firstMatch(*host, proto, port, now), now, false
+	}
 	key := matchResultCacheKey{
-		Host:  host.String(),
+		Name:  host.Name,
+		IPv4:  host.IPv4,
+		IPv6:  host.IPv6,
 		Proto: proto,
 		Port:  port,
 	}
-	if result, ok := s.Cache.Get(key); ok {
-		return result.Outbound, result.HijackAddress
+	if s.Session.SrcIP {
+		key.SrcIP = host.SrcIP
 	}
-	for _, rule := range s.Rules {
-		if rule.Match(host, proto, port) {
-			result := matchResult[O]{rule.Outbound, rule.HijackAddress}
-			s.Cache.Add(key, result)
-			return result.Outbound, result.HijackAddress
+	if s.Session.Inbound {
+		key.Inbound = strings.ToLower(host.Inbound)
+	}
+	if s.Session.User {
+		key.User = host.User
+	}
+	if result, ok := s.cache.Get(key, epoch, now); ok {
+		return result, now, true
+	}
+	result = s.firstMatch(*host, proto, port, now)
+	result.Epoch = epoch
+	s.cache.Add(key, result, now) // No match should also be cached
+	return result, now, false
+}
+
+// firstMatch evaluates the rules for a connection, and returns the first one
+// that matches. It is separate from match so that the variables captured by
+// the loop body do not escape in the cached case.
+func (s *compiledRuleSetImpl[O]) firstMatch(host Host, proto Protocol, port uint16, now time.Time) matchResult[O] {
+	for i := range s.candidates(host) {
+		rule := &s.Rules[i]
+		if rule.Active(now) && rule.Match(host, proto, port) {
+			return matchResult[O]{Outbound: rule.Outbound, HijackAddress: rule.HijackAddress, Rule: i}
 		}
 	}
-	// No match should also be cached
-	var zero O
-	s.Cache.Add(key, matchResult[O]{zero, nil})
-	return zero, nil
+	return matchResult[O]{Rule: -1}
+}
+
+// candidates returns the indexes of the rules that may match host, in
+// ascending order, so that the first of them that matches is the first
+// matching rule.
+func (s *compiledRuleSetImpl[O]) candidates(host Host) iter.Seq[int] {
+	if s.index == nil {
+		return allRules(len(s.Rules))
+	}
+	return s.index.candidates(host)
 }
 
 type CompilationError struct {
+	Source  string
 	LineNum int
+	Column  int
 	Message string
+	// Suggestion is a close match for a misspelled name (outbound, GeoIP
+	// country code or GeoSite name), if one was found.
+	Suggestion string
 }
 
 func (e *CompilationError) Error() string {
-	return fmt.Sprintf("error at line %d: %s", e.LineNum, e.Message)
+	msg := fmt.Sprintf("error at %s: %s", formatLocation(e.Source, e.LineNum, e.Column), e.Message)
+	if e.Suggestion != "" {
+		msg += fmt.Sprintf(" (did you mean %q?)", e.Suggestion)
+	}
+	return msg
+}
+
+// addressError returns a *CompilationError for an invalid address. The position
+// is filled in by Compile from the TextRule the address belongs to.
+func addressError(suggestion, format string, args ...any) *CompilationError {
+	return &CompilationError{
+		Message:    fmt.Sprintf(format, args...),
+		Suggestion: suggestion,
+	}
+}
+
+// addressErrorAt is like addressError, but for an error at a known position
+// inside the address (e.g. in an argument of a composite address).
+func addressErrorAt(pos Position, suggestion, format string, args ...any) *CompilationError {
+	err := addressError(suggestion, format, args...)
+	if pos.IsValid() {
+		err.LineNum, err.Column = pos.Line, pos.Column
+	}
+	return err
 }
 
 type GeoLoader interface {
@@ -123,196 +347,538 @@ type GeoLoader interface {
 	LoadGeoSite() (map[string]*geodat.GeoSite, error)
 }
 
+// ASNLoader is an optional extension of GeoLoader, required by ASN rules
+// such as "asn:13335". The keys of the map are AS numbers in decimal.
+type ASNLoader interface {
+	LoadASN() (map[string]*geodat.GeoIP, error)
+}
+
 // Compile compiles TextRules into a CompiledRuleSet.
 // Names in the outbounds map MUST be in all lower case.
+// Match results are cached in an LRU cache of cacheSize results (see
+// WithCacheShards and WithCacheTTL); a cacheSize of 0 disables caching, for
+// example for a rule set that is only evaluated once.
 // We want on-demand loading of GeoIP/GeoSite databases, so instead of passing the
 // databases directly, we use a GeoLoader interface to load them only when needed
 // by at least one rule.
+// Address set definitions (see TextRule.SetName) can be referenced by any rule,
+// regardless of their order. Each set is compiled once and shared by all rules
+// referencing it.
+// By default Compile stops at the first error. With WithAllErrors, it checks
+// every rule and returns all errors as an ErrorList.
 func Compile[O Outbound](rules []TextRule, outbounds map[string]O,
-	cacheSize int, geoLoader GeoLoader,
+	cacheSize int, geoLoader GeoLoader, opts ...Option,
 ) (CompiledRuleSet[O], error) {
-	compiledRules := make([]compiledRule[O], len(rules))
-	for i, rule := range rules {
-		outbound, ok := outbounds[strings.ToLower(rule.Outbound)]
-		if !ok {
-			return nil, &CompilationError{rule.LineNum, fmt.Sprintf("outbound %s not found", rule.Outbound)}
+	o := newOptions(opts)
+	compiledRules, session, err := compileRules(rules, outbounds, geoLoader, o)
+	if err != nil {
+		return nil, err
+	}
+	return newCompiledRuleSet(compiledRules, cacheSize, session, o)
+}
+
+// compileRules compiles the rules (but not the address set definitions) of a
+// rule file, and returns the session metadata they use.
+func compileRules[O Outbound](rules []TextRule, outbounds map[string]O,
+	geoLoader GeoLoader, o *options,
+) ([]compiledRule[O], sessionUsage, error) {
+	if _, ok := outbounds[includeDirective]; ok {
+		return nil, sessionUsage{}, fmt.Errorf("invalid outbound name %s: reserved for include directives", includeDirective)
+	}
+	c := newCompiler(geoLoader)
+	c.baseDir = o.baseDir
+	var errs ErrorList
+	for i := range rules {
+		if rules[i].SetName == "" {
+			continue
 		}
-		hm, errStr := compileHostMatcher(rule.Address, geoLoader)
-		if errStr != "" {
-			return nil, &CompilationError{rule.LineNum, errStr}
+		if err := c.define(&rules[i]); err != nil {
+			if !o.allErrors {
+				return nil, sessionUsage{}, err
+			}
+			errs = append(errs, err)
 		}
-		proto, hasPortFilter, startPort, endPort, ok := parseProtoPort(rule.ProtoPort)
+	}
+	compiledRules := make([]compiledRule[O], 0, len(rules))
+	for _, rule := range rules {
+		if rule.SetName != "" || rule.isComment() {
+			continue
+		}
+		if rule.isInclude() {
+			// Kept by WithComments for Format, but not expanded
+			err := &CompilationError{
+				Source:  rule.Source,
+				LineNum: rule.LineNum,
+				Column:  rule.Column,
+				Message: "include directives cannot be compiled, parse the rules without WithComments",
+			}
+			if !o.allErrors {
+				return nil, sessionUsage{}, err
+			}
+			errs = append(errs, err)
+			continue
+		}
+		cr, ruleErrs := compileRule(rule, outbounds, c, o.allErrors)
+		if len(ruleErrs) > 0 {
+			if !o.allErrors {
+				return nil, sessionUsage{}, ruleErrs[0]
+			}
+			errs = append(errs, ruleErrs...)
+			continue
+		}
+		compiledRules = append(compiledRules, cr)
+	}
+	// Sets not referenced by any rule still have to be valid
+	for _, err := range c.compileUnusedSets() {
+		if !o.allErrors {
+			return nil, sessionUsage{}, err
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, sessionUsage{}, errs
+	}
+	return compiledRules, c.session, nil
+}
+
+// compiler holds the state shared by all rules compiled by a single Compile call.
+type compiler struct {
+	geoLoader GeoLoader
+	sets      map[string]*addressSet // key: lower case set name
+	setOrder  []*addressSet          // in definition order
+	// setStack is the chain of sets currently being compiled, used to detect
+	// recursive references.
+	setStack []string
+	session  sessionUsage
+	baseDir  string                        // for relative "list:" paths
+	dir      string                        // of the rule or set being compiled, overrides baseDir
+	lists    map[string]*domainListMatcher // key: path
+}
+
+func newCompiler(geoLoader GeoLoader) *compiler {
+	return &compiler{
+		geoLoader: geoLoader,
+		sets:      make(map[string]*addressSet),
+	}
+}
+
+// compileRule compiles a single TextRule. Unless allErrors is set, it returns
+// at most one error.
+func compileRule[O Outbound](rule TextRule, outbounds map[string]O,
+	c *compiler, allErrors bool,
+) (compiledRule[O], ErrorList) {
+	var errs ErrorList
+	// fail records an error at pos (or at the start of the rule), and reports
+	// whether compilation of the rule should stop.
+	fail := func(pos Position, err error) bool {
+		ce, ok := err.(*CompilationError)
 		if !ok {
-			return nil, &CompilationError{rule.LineNum, fmt.Sprintf("invalid protocol/port: %s", rule.ProtoPort)}
+			ce = &CompilationError{Message: err.Error()}
+		}
+		if ce.Source == "" {
+			// Errors inside address sets already carry the source of the definition
+			ce.Source = rule.Source
 		}
-		var hijackAddress net.IP
-		if rule.HijackAddress != "" {
-			hijackAddress = net.ParseIP(rule.HijackAddress)
-			if hijackAddress == nil {
-				return nil, &CompilationError{rule.LineNum, fmt.Sprintf("invalid hijack address (must be an IP address): %s", rule.HijackAddress)}
+		if ce.LineNum == 0 {
+			ce.LineNum = rule.LineNum
+			if pos.IsValid() {
+				ce.LineNum, ce.Column = pos.Line, pos.Column
 			}
 		}
-		compiledRules[i] = compiledRule[O]{outbound, hm, proto, hasPortFilter, startPort, endPort, hijackAddress}
+		errs = append(errs, ce)
+		return !allErrors
 	}
-	cache, err := lru.New[matchResultCacheKey, matchResult[O]](cacheSize)
+
+	outbound, ok := outbounds[strings.ToLower(rule.Outbound)]
+	if !ok {
+		err := &CompilationError{
+			Message:    fmt.Sprintf("outbound %s not found", rule.Outbound),
+			Suggestion: suggest(strings.ToLower(rule.Outbound), mapKeys(outbounds)),
+		}
+		if fail(Position{rule.LineNum, rule.Column}, err) {
+			return compiledRule[O]{}, errs
+		}
+	}
+	c.dir = rule.dir
+	hm, err := c.compileHostMatcher(rule.Address, rule.AddressPos)
 	if err != nil {
-		return nil, err
+		if fail(rule.AddressPos, err) {
+			return compiledRule[O]{}, errs
+		}
 	}
-	return &compiledRuleSetImpl[O]{compiledRules, cache}, nil
-}
-
-// parseProtoPort parses the protocol and port from a protoPort string.
-// protoPort must be in one of the following formats:
-//
-//	proto/port
-//	proto/*
-//	proto
-//	*/port
-//	*/*
-//	*
-//	[empty] (same as *)
-//
-// proto must be either "tcp" or "udp", case-insensitive.
-func parseProtoPort(protoPort string) (proto Protocol, hasPortFilter bool, startPort uint16, endPort uint16, ok bool) {
-	protoPort = strings.ToLower(protoPort)
-	if protoPort == "" || protoPort == "*" || protoPort == "*/*" {
-		return ProtocolBoth, false, 0, 0, true
-	}
-	parts := strings.SplitN(protoPort, "/", 2)
-	if len(parts) == 1 {
-		// No port, only protocol
-		switch parts[0] {
-		case "tcp":
-			return ProtocolTCP, false, 0, 0, true
-		case "udp":
-			return ProtocolUDP, false, 0, 0, true
-		default:
-			return ProtocolBoth, false, 0, 0, false
-		}
-	} else {
-		// Both protocol and port
-		switch parts[0] {
-		case "tcp":
-			proto = ProtocolTCP
-		case "udp":
-			proto = ProtocolUDP
-		case "*":
-			proto = ProtocolBoth
-		default:
-			return ProtocolBoth, false, 0, 0, false
-		}
-		if parts[1] == "*" {
-			return proto, false, 0, 0, true
-		}
-		// We allow either a single port or a range (e.g. "1000-2000")
-		ports := strings.SplitN(strings.TrimSpace(parts[1]), "-", 2)
-		if len(ports) == 1 {
-			p64, err := strconv.ParseUint(parts[1], 10, 16)
-			if err != nil {
-				return ProtocolBoth, false, 0, 0, false
-			}
-			startPort = uint16(p64)
-			endPort = startPort
-		} else {
-			p64, err := strconv.ParseUint(ports[0], 10, 16)
-			if err != nil {
-				return ProtocolBoth, false, 0, 0, false
-			}
-			startPort = uint16(p64)
-			p64, err = strconv.ParseUint(ports[1], 10, 16)
-			if err != nil {
-				return ProtocolBoth, false, 0, 0, false
+	protoPort, err := parseProtoPort(rule.ProtoPort)
+	if err != nil {
+		err := fmt.Errorf("invalid protocol/port: %s: %w", rule.ProtoPort, err)
+		if fail(rule.ProtoPortPos, err) {
+			return compiledRule[O]{}, errs
+		}
+	}
+	var hijackAddress *HijackAddress
+	if rule.HijackAddress != "" {
+		hijackAddress, err = parseHijackAddress(rule.HijackAddress)
+		if err != nil {
+			err := fmt.Errorf("invalid hijack address: %s: %w", rule.HijackAddress, err)
+			if fail(rule.HijackAddressPos, err) {
+				return compiledRule[O]{}, errs
 			}
-			endPort = uint16(p64)
-			if startPort > endPort {
-				return ProtocolBoth, false, 0, 0, false
+		}
+	}
+	var sch *schedule
+	if rule.Schedule != "" {
+		sch, err = parseSchedule(rule.Schedule)
+		if err != nil {
+			err := fmt.Errorf("invalid schedule: %s: %w", rule.Schedule, err)
+			if fail(rule.SchedulePos, err) {
+				return compiledRule[O]{}, errs
 			}
 		}
-		return proto, true, startPort, endPort, true
 	}
+	if len(errs) > 0 {
+		return compiledRule[O]{}, errs
+	}
+	return compiledRule[O]{
+		Outbound:      outbound,
+		HostMatcher:   hm,
+		ProtoPort:     protoPort,
+		HijackAddress: hijackAddress,
+		Schedule:      sch,
+		Text:          rule,
+	}, nil
 }
 
-func compileHostMatcher(addr string, geoLoader GeoLoader) (hostMatcher, string) {
+// compileHostMatcher compiles an address into a hostMatcher.
+// pos is the position of addr in the source text, used to locate errors
+// inside composite addresses.
+func (c *compiler) compileHostMatcher(addr string, pos Position) (hostMatcher, error) {
+	raw := addr                  // original case, for regular expressions
 	addr = strings.ToLower(addr) // Normalize to lower case
+	if op, argsOffset, ok := cutOperator(addr); ok {
+		// Composite matcher, e.g. "and(geosite:netflix, geoip:us)"
+		return c.compileCompositeMatcher(op, raw[argsOffset:], pos.offset(argsOffset))
+	}
+	if strings.HasPrefix(addr, "!") {
+		// Negated matcher, e.g. "!geoip:cn"
+		rest := strings.TrimSpace(raw[1:])
+		if len(rest) == 0 {
+			return nil, addressError("", "empty address after '!'")
+		}
+		m, err := c.compileHostMatcher(rest, pos.offset(len(raw)-len(rest)))
+		if err != nil {
+			return nil, err
+		}
+		if gm, ok := m.(*geoipMatcher); ok && !strings.HasPrefix(rest, "@") {
+			// GeoIP matchers can be inverted in place, unless shared by an address set
+			gm.Inverse = !gm.Inverse
+			return gm, nil
+		}
+		return &inverseMatcher{m}, nil
+	}
+	if name, found := strings.CutPrefix(addr, "@"); found {
+		// Address set reference, e.g. "@lan"
+		if len(name) == 0 {
+			return nil, addressError("", "empty address set name")
+		}
+		return c.resolveSet(name, pos)
+	}
+	if strings.HasPrefix(addr, "src:") {
+		// Source address matcher, e.g. "src:10.1.0.0/16"
+		src := addr[4:]
+		if len(src) == 0 {
+			return nil, addressError("", "empty source address")
+		}
+		prefix, err := parseIPOrCIDR(src)
+		if err != nil {
+			return nil, addressError("", "invalid source address: %s", src)
+		}
+		c.session.SrcIP = true
+		return &srcMatcher{prefix}, nil
+	}
+	if strings.HasPrefix(addr, "inbound:") {
+		// Inbound tag matcher, e.g. "inbound:office"
+		tag := addr[8:]
+		if len(tag) == 0 {
+			return nil, addressError("", "empty inbound tag")
+		}
+		c.session.Inbound = true
+		return &inboundMatcher{tag}, nil
+	}
+	if strings.HasPrefix(addr, "user:") {
+		// User matcher, e.g. "user:alice". User names are case-sensitive.
+		user := raw[5:]
+		if len(user) == 0 {
+			return nil, addressError("", "empty user name")
+		}
+		c.session.User = true
+		return &userMatcher{user}, nil
+	}
 	if addr == "*" || addr == "all" {
 		// Match all hosts
-		return &allMatcher{}, ""
+		return &allMatcher{}, nil
 	}
 	if strings.HasPrefix(addr, "geoip:") {
 		// GeoIP matcher
 		country := addr[6:]
 		if len(country) == 0 {
-			return nil, "empty GeoIP country code"
+			return nil, addressError("", "empty GeoIP country code")
+		}
+		gMap, err := c.geoLoader.LoadGeoIP()
+		list, ok := gMap[country]
+		if err != nil || !ok || list == nil {
+			if builtin := builtinGeoIPList(country); builtin != nil {
+				// Built-in code such as "private", for when the database
+				// has no such entry, or there is no database
+				return newGeoIPMatcher(builtin)
+			}
 		}
-		gMap, err := geoLoader.LoadGeoIP()
 		if err != nil {
-			return nil, err.Error()
+			return nil, err
 		}
-		list, ok := gMap[country]
 		if !ok || list == nil {
-			return nil, fmt.Sprintf("GeoIP country code %s not found", country)
+			candidates := append(mapKeys(gMap), mapKeys(builtinGeoIP)...)
+			return nil, addressError(suggest(country, candidates), "GeoIP country code %s not found", country)
 		}
 		m, err := newGeoIPMatcher(list)
 		if err != nil {
-			return nil, err.Error()
+			return nil, err
 		}
-		return m, ""
+		return m, nil
+	}
+	if strings.HasPrefix(addr, "asn:") {
+		// ASN matcher
+		asn, err := mmdb.ParseASN(addr[4:])
+		if err != nil {
+			return nil, addressError("", "%v", err)
+		}
+		loader, ok := c.geoLoader.(ASNLoader)
+		if !ok {
+			return nil, addressError("", "ASN rules are not supported by the GeoLoader")
+		}
+		aMap, err := loader.LoadASN()
+		if err != nil {
+			return nil, err
+		}
+		list, ok := aMap[strconv.FormatUint(uint64(asn), 10)]
+		if !ok || list == nil {
+			return nil, addressError("", "AS%d not found", asn)
+		}
+		m, err := newGeoIPMatcher(list)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
 	}
 	if strings.HasPrefix(addr, "geosite:") {
 		// GeoSite matcher
 		name, attrs := parseGeoSiteName(addr[8:])
 		if len(name) == 0 {
-			return nil, "empty GeoSite name"
+			return nil, addressError("", "empty GeoSite name")
 		}
-		gMap, err := geoLoader.LoadGeoSite()
+		gMap, err := c.geoLoader.LoadGeoSite()
 		if err != nil {
-			return nil, err.Error()
+			return nil, err
 		}
 		list, ok := gMap[name]
 		if !ok || list == nil {
-			return nil, fmt.Sprintf("GeoSite name %s not found", name)
+			return nil, addressError(suggest(name, mapKeys(gMap)), "GeoSite name %s not found", name)
 		}
 		m, err := newGeositeMatcher(list, attrs)
 		if err != nil {
-			return nil, err.Error()
+			return nil, err
 		}
-		return m, ""
+		return m, nil
+	}
+	if strings.HasPrefix(addr, "list:") {
+		// Hosts file, domain list or AdBlock filter list, e.g. "list:/etc/acl/ads.txt"
+		return c.compileDomainList(strings.TrimSpace(raw[len("list:"):]))
+	}
+	if name, found := strings.CutPrefix(addr, "ruleset:"); found {
+		// sing-box rule-set of domains and IPs
+		return c.compileRuleSet(name)
+	}
+	for _, p := range domainRulePrefixes {
+		if !strings.HasPrefix(addr, p.Prefix) {
+			continue
+		}
+		// v2ray-style domain rule, e.g. "keyword:google"
+		value := addr[len(p.Prefix):]
+		if p.Type == geodat.Domain_Regex {
+			value = raw[len(p.Prefix):]
+		}
+		if len(value) == 0 {
+			return nil, addressError("", "empty %s", p.Desc)
+		}
+		m, err := newGeositeMatcher(&geodat.GeoSite{
+			Domain: []*geodat.Domain{{Type: p.Type, Value: value}},
+		}, nil)
+		if err != nil {
+			return nil, addressError("", "invalid %s %s: %v", p.Desc, value, err)
+		}
+		return m, nil
 	}
 	if strings.HasPrefix(addr, "suffix:") {
 		// Domain suffix matcher
 		suffix := addr[7:]
 		if len(suffix) == 0 {
-			return nil, "empty domain suffix"
+			return nil, addressError("", "empty domain suffix")
 		}
 		return &domainMatcher{
 			Pattern: suffix,
 			Mode:    domainMatchSuffix,
-		}, ""
+		}, nil
 	}
 	if strings.Contains(addr, "/") {
 		// CIDR matcher
-		_, ipnet, err := net.ParseCIDR(addr)
+		prefix, err := parseIPOrCIDR(addr)
 		if err != nil {
-			return nil, fmt.Sprintf("invalid CIDR address: %s", addr)
+			return nil, addressError("", "invalid CIDR address: %s", addr)
 		}
-		return &cidrMatcher{ipnet}, ""
+		return &cidrMatcher{prefix}, nil
 	}
 	if ip := net.ParseIP(addr); ip != nil {
 		// Single IP matcher
-		return &ipMatcher{ip}, ""
+		return &ipMatcher{iptree.AddrFromIP(ip)}, nil
 	}
 	if strings.Contains(addr, "*") {
 		// Wildcard domain matcher
 		return &domainMatcher{
 			Pattern: addr,
 			Mode:    domainMatchWildcard,
-		}, ""
+		}, nil
 	}
 	// Nothing else matched, treat it as a non-wildcard domain
 	return &domainMatcher{
 		Pattern: addr,
 		Mode:    domainMatchExact,
-	}, ""
+	}, nil
+}
+
+// domainRulePrefixes are the v2ray-style domain rule prefixes. They are
+// compiled with the same semantics as the corresponding GeoSite entries.
+var domainRulePrefixes = []struct {
+	Prefix string
+	Type   geodat.Domain_Type
+	Desc   string // for error messages
+}{
+	{"full:", geodat.Domain_Full, "domain"},
+	{"domain:", geodat.Domain_RootDomain, "domain"},
+	{"keyword:", geodat.Domain_Plain, "keyword"},
+	{"regexp:", geodat.Domain_Regex, "regular expression"},
+}
+
+// compositeOperators are the operators that can be used in composite addresses.
+var compositeOperators = []string{"and", "or", "not"}
+
+// cutOperator splits a composite address such as "and(a, b)" into the operator
+// name and the offset of the text following the opening parenthesis.
+func cutOperator(addr string) (op string, argsOffset int, ok bool) {
+	i := 0
+	for i < len(addr) && addr[i] >= 'a' && addr[i] <= 'z' {
+		i++
+	}
+	if i == 0 {
+		return "", 0, false
+	}
+	j := i
+	for j < len(addr) && (addr[j] == ' ' || addr[j] == '\t') {
+		j++
+	}
+	if j == len(addr) || addr[j] != '(' {
+		return "", 0, false
+	}
+	return addr[:i], j + 1, true
+}
+
+// compileCompositeMatcher compiles the arguments of a composite address into
+// an and/or/not matcher. args is the text after the opening parenthesis, and
+// argsPos its position in the source text (zero if unknown).
+func (c *compiler) compileCompositeMatcher(op, args string, argsPos Position) (hostMatcher, error) {
+	// Positions reported by the scanner are only meaningful if we know where
+	// the arguments are in the source text.
+	known := argsPos.IsValid()
+	at := func(p Position) Position {
+		if known {
+			return p
+		}
+		return Position{}
+	}
+
+	if !slices.Contains(compositeOperators, op) {
+		return nil, addressErrorAt(argsPos.offset(-len(op)-1), suggest(op, compositeOperators),
+			"unknown operator %s", op)
+	}
+	s := newScannerAt(args, argsPos)
+	textArgs, syntaxErr := s.scanArgs()
+	if syntaxErr != nil {
+		return nil, addressErrorAt(at(Position{syntaxErr.LineNum, syntaxErr.Column}), "",
+			"invalid syntax in %s(): %s", op, syntaxErr.Message)
+	}
+	s.skipSpace()
+	if !s.eof() {
+		return nil, addressErrorAt(at(s.pos()), "", "unexpected %q after %s()", s.peek(), op)
+	}
+	if op == "not" && len(textArgs) != 1 {
+		return nil, addressErrorAt(at(textArgs[0].Pos), "", "not() takes exactly one address, got %d", len(textArgs))
+	}
+
+	matchers, err := c.compileAddressList(textArgs, known, op+"()")
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "and":
+		return &andMatcher{matchers}, nil
+	case "or":
+		return &orMatcher{matchers}, nil
+	default:
+		return &inverseMatcher{matchers[0]}, nil
+	}
+}
+
+// compileAddressList compiles the addresses of a composite address or an address
+// set. If known is false, the positions of the arguments are not meaningful.
+// where describes the list in error messages.
+func (c *compiler) compileAddressList(args []textArg, known bool, where string) ([]hostMatcher, error) {
+	matchers := make([]hostMatcher, len(args))
+	for i, arg := range args {
+		pos := arg.Pos
+		if !known {
+			pos = Position{}
+		}
+		if arg.Value == "" {
+			return nil, addressErrorAt(pos, "", "empty address in %s", where)
+		}
+		m, err := c.compileHostMatcher(arg.Value, pos)
+		if err != nil {
+			ce, ok := err.(*CompilationError)
+			if !ok {
+				return nil, addressErrorAt(pos, "", "%v", err)
+			}
+			if ce.LineNum == 0 && known {
+				ce.LineNum, ce.Column = pos.Line, pos.Column
+			}
+			return nil, ce
+		}
+		matchers[i] = m
+	}
+	return matchers, nil
+}
+
+// parseIPOrCIDR parses a CIDR, or a single IP as a host route. IPv4-mapped
+// IPv6 addresses are converted to IPv4.
+func parseIPOrCIDR(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return iptree.Normalize(iptree.PrefixFromIPNet(ipnet)), nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP address: %s", s)
+	}
+	addr := iptree.AddrFromIP(ip)
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
 }
 
 func parseGeoSiteName(s string) (string, []string) {
@@ -1,9 +1,16 @@
 package acl
 
 import (
+	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xflash-panda/acl-engine/pkg/acl/geodat"
+	"github.com/xflash-panda/acl-engine/pkg/acl/iptree"
+	"github.com/xflash-panda/acl-engine/pkg/acl/ruleset"
 )
 
 func Test_parseGeoSiteName(t *testing.T) {
@@ -64,113 +71,838 @@ func Test_compiledRule_MatchPort0(t *testing.T) {
 	// but StartPort==0 causes the port filter to be skipped entirely,
 	// matching ALL ports instead.
 	rule := compiledRule[string]{
-		Outbound:      "test",
-		HostMatcher:   &allMatcher{},
-		Protocol:      ProtocolTCP,
-		HasPortFilter: true,
-		StartPort:     0,
-		EndPort:       0,
+		Outbound:    "test",
+		HostMatcher: &allMatcher{},
+		ProtoPort:   protoPortFilter{TCP: portSet{{0, 0}}},
 	}
 
 	// Port 0 should match
-	assert.True(t, rule.Match(HostInfo{Name: "example.com"}, ProtocolTCP, 0),
+	assert.True(t, rule.Match(Host{Name: "example.com"}, ProtocolTCP, 0),
 		"tcp/0 rule should match port 0")
 
 	// Port 443 should NOT match a port-0-only rule
-	assert.False(t, rule.Match(HostInfo{Name: "example.com"}, ProtocolTCP, 443),
+	assert.False(t, rule.Match(Host{Name: "example.com"}, ProtocolTCP, 443),
 		"tcp/0 rule should NOT match port 443")
 
 	// Port 80 should NOT match a port-0-only rule
-	assert.False(t, rule.Match(HostInfo{Name: "example.com"}, ProtocolTCP, 80),
+	assert.False(t, rule.Match(Host{Name: "example.com"}, ProtocolTCP, 80),
 		"tcp/0 rule should NOT match port 80")
 }
 
 func Test_parseProtoPort(t *testing.T) {
 	tests := []struct {
-		name           string
-		protoPort      string
-		wantProto      Protocol
-		wantPortFilter bool
-		wantStart      uint16
-		wantEnd        uint16
-		wantOK         bool
+		name      string
+		protoPort string
+		want      protoPortFilter
+		wantErr   bool
 	}{
 		{
 			name:      "empty",
 			protoPort: "",
-			wantProto: ProtocolBoth,
-			wantOK:    true,
+			want:      protoPortFilter{TCP: allPorts, UDP: allPorts},
 		},
 		{
 			name:      "wildcard",
 			protoPort: "*",
-			wantProto: ProtocolBoth,
-			wantOK:    true,
+			want:      protoPortFilter{TCP: allPorts, UDP: allPorts},
+		},
+		{
+			name:      "wildcard with wildcard port",
+			protoPort: "*/*",
+			want:      protoPortFilter{TCP: allPorts, UDP: allPorts},
 		},
 		{
 			name:      "tcp only",
 			protoPort: "tcp",
-			wantProto: ProtocolTCP,
-			wantOK:    true,
+			want:      protoPortFilter{TCP: allPorts},
 		},
 		{
 			name:      "udp only",
 			protoPort: "udp",
-			wantProto: ProtocolUDP,
-			wantOK:    true,
+			want:      protoPortFilter{UDP: allPorts},
 		},
 		{
-			name:           "tcp with port",
-			protoPort:      "tcp/443",
-			wantProto:      ProtocolTCP,
-			wantPortFilter: true,
-			wantStart:      443,
-			wantEnd:        443,
-			wantOK:         true,
+			name:      "tcp with port",
+			protoPort: "tcp/443",
+			want:      protoPortFilter{TCP: portSet{{443, 443}}},
 		},
 		{
-			name:           "udp with port range",
-			protoPort:      "udp/6881-6889",
-			wantProto:      ProtocolUDP,
-			wantPortFilter: true,
-			wantStart:      6881,
-			wantEnd:        6889,
-			wantOK:         true,
+			name:      "udp with port range",
+			protoPort: "udp/6881-6889",
+			want:      protoPortFilter{UDP: portSet{{6881, 6889}}},
 		},
 		{
-			name:           "tcp with port 0",
-			protoPort:      "tcp/0",
-			wantProto:      ProtocolTCP,
-			wantPortFilter: true,
-			wantStart:      0,
-			wantEnd:        0,
-			wantOK:         true,
+			name:      "tcp with port 0",
+			protoPort: "tcp/0",
+			want:      protoPortFilter{TCP: portSet{{0, 0}}},
 		},
 		{
 			name:      "tcp with wildcard port",
 			protoPort: "tcp/*",
-			wantProto: ProtocolTCP,
-			wantOK:    true,
+			want:      protoPortFilter{TCP: allPorts},
+		},
+		{
+			name:      "both with port",
+			protoPort: "*/443",
+			want:      protoPortFilter{TCP: portSet{{443, 443}}, UDP: portSet{{443, 443}}},
 		},
 		{
 			name:      "invalid port range",
 			protoPort: "tcp/9000-8000",
-			wantOK:    false,
+			wantErr:   true,
 		},
 		{
 			name:      "invalid protocol",
 			protoPort: "icmp",
-			wantOK:    false,
+			wantErr:   true,
+		},
+		{
+			name:      "negated tcp with port",
+			protoPort: "!tcp/22",
+			want:      protoPortFilter{TCP: portSet{{22, 22}}, Inverse: true},
+		},
+		{
+			name:      "negated udp",
+			protoPort: "! UDP",
+			want:      protoPortFilter{UDP: allPorts, Inverse: true},
+		},
+		{
+			name:      "double negation",
+			protoPort: "!!tcp",
+			want:      protoPortFilter{TCP: allPorts},
+		},
+		{
+			name:      "negation without protocol",
+			protoPort: "!",
+			wantErr:   true,
+		},
+		{
+			name:      "port list",
+			protoPort: "tcp/25,465,587",
+			want:      protoPortFilter{TCP: portSet{{25, 25}, {465, 465}, {587, 587}}},
+		},
+		{
+			name:      "port list with ranges, merged and sorted",
+			protoPort: "udp/5353, 53, 1000-2000, 1500-2500, 2501",
+			want:      protoPortFilter{UDP: portSet{{53, 53}, {1000, 2501}, {5353, 5353}}},
+		},
+		{
+			name:      "per-protocol lists",
+			protoPort: "TCP/80,443 udp/53,853",
+			want:      protoPortFilter{TCP: portSet{{80, 80}, {443, 443}}, UDP: portSet{{53, 53}, {853, 853}}},
+		},
+		{
+			name:      "per-protocol lists with commas",
+			protoPort: "tcp/80,udp/53,*/8080",
+			want:      protoPortFilter{TCP: portSet{{80, 80}, {8080, 8080}}, UDP: portSet{{53, 53}, {8080, 8080}}},
+		},
+		{
+			name:      "protocol without ports and port list",
+			protoPort: "tcp, udp/53",
+			want:      protoPortFilter{TCP: allPorts, UDP: portSet{{53, 53}}},
+		},
+		{
+			name:      "port without protocol",
+			protoPort: "53",
+			wantErr:   true,
+		},
+		{
+			name:      "port after protocol without ports",
+			protoPort: "tcp,53",
+			wantErr:   true,
+		},
+		{
+			name:      "invalid port in list",
+			protoPort: "tcp/80,http",
+			wantErr:   true,
+		},
+		{
+			name:      "port out of range",
+			protoPort: "tcp/65536",
+			wantErr:   true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			proto, hasPortFilter, start, end, ok := parseProtoPort(tt.protoPort)
-			assert.Equal(t, tt.wantProto, proto)
-			assert.Equal(t, tt.wantPortFilter, hasPortFilter)
-			assert.Equal(t, tt.wantStart, start)
-			assert.Equal(t, tt.wantEnd, end)
-			assert.Equal(t, tt.wantOK, ok)
+			got, err := parseProtoPort(tt.protoPort)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
 		})
 	}
 }
+
+func Test_portSet_Contains(t *testing.T) {
+	var ranges []portRange
+	for p := uint16(100); p < 200; p += 10 {
+		ranges = append(ranges, portRange{p, p + 2})
+	}
+	set := newPortSet(ranges)
+	for p := 0; p <= 65535; p++ {
+		want := p >= 100 && p < 200 && p%10 <= 2
+		if got := set.Contains(uint16(p)); got != want {
+			t.Fatalf("Contains(%d) = %v, want %v", p, got, want)
+		}
+	}
+	assert.False(t, portSet(nil).Contains(0))
+	assert.True(t, allPorts.Contains(65535))
+}
+
+func Test_protoPortFilter_Match(t *testing.T) {
+	f := protoPortFilter{TCP: portSet{{80, 80}}, UDP: portSet{{53, 53}}}
+	assert.True(t, f.Match(ProtocolTCP, 80))
+	assert.False(t, f.Match(ProtocolTCP, 53))
+	assert.True(t, f.Match(ProtocolUDP, 53))
+	assert.False(t, f.Match(ProtocolBoth, 80))
+	assert.True(t, anyProtoPort.Match(ProtocolBoth, 80))
+
+	f.Inverse = true
+	assert.False(t, f.Match(ProtocolTCP, 80))
+	assert.True(t, f.Match(ProtocolTCP, 53))
+}
+
+func TestCompile_PortLists(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT", "reject": "REJECT"}
+	rules, err := ParseTextRules(`
+reject(all, "tcp/25,465,587 udp/53,853,5353")
+direct(all, "!tcp/1-1023")
+`)
+	require.NoError(t, err)
+	rs, err := Compile[string](rules, outbounds, 16, newTestGeoLoader())
+	require.NoError(t, err)
+
+	host := HostInfo{Name: "example.com"}
+	for _, port := range []uint16{25, 465, 587} {
+		got, _ := rs.Match(host, ProtocolTCP, port)
+		assert.Equal(t, "REJECT", got, "tcp/%d", port)
+	}
+	for _, port := range []uint16{53, 853, 5353} {
+		got, _ := rs.Match(host, ProtocolUDP, port)
+		assert.Equal(t, "REJECT", got, "udp/%d", port)
+	}
+	got, _ := rs.Match(host, ProtocolUDP, 25)
+	assert.Equal(t, "DIRECT", got)
+	got, _ = rs.Match(host, ProtocolTCP, 8080)
+	assert.Equal(t, "DIRECT", got)
+	got, _ = rs.Match(host, ProtocolTCP, 443)
+	assert.Equal(t, "", got)
+}
+
+func TestCompile_UnquotedPortLists(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT", "reject": "REJECT"}
+	rules, err := ParseTextRules(`
+reject(all, tcp/25,465,587)
+direct(all, udp/53,853, 127.0.0.1)
+reject(all, udp/5353,8000-8001,tcp/22 23)
+direct(all, tcp/80, 8080.example.com)
+`)
+	require.NoError(t, err)
+	require.Len(t, rules, 4)
+	assert.Equal(t, "tcp/25,465,587", rules[0].ProtoPort)
+	assert.Empty(t, rules[0].HijackAddress)
+	assert.Equal(t, "udp/53,853", rules[1].ProtoPort)
+	assert.Equal(t, "127.0.0.1", rules[1].HijackAddress)
+	assert.Equal(t, "udp/5353,8000-8001,tcp/22 23", rules[2].ProtoPort)
+	assert.Equal(t, "8080.example.com", rules[3].HijackAddress)
+
+	rs, err := Compile[string](rules, outbounds, 16, newTestGeoLoader())
+	require.NoError(t, err)
+	host := HostInfo{Name: "example.com"}
+	tests := []struct {
+		proto Protocol
+		port  uint16
+		want  string
+	}{
+		{ProtocolTCP, 25, "REJECT"},
+		{ProtocolTCP, 587, "REJECT"},
+		{ProtocolUDP, 587, ""},
+		{ProtocolUDP, 853, "DIRECT"},
+		{ProtocolUDP, 8001, "REJECT"},
+		{ProtocolTCP, 23, "REJECT"},
+		{ProtocolTCP, 80, "DIRECT"},
+		{ProtocolTCP, 443, ""},
+	}
+	for _, tt := range tests {
+		got, _ := rs.Match(host, tt.proto, tt.port)
+		assert.Equal(t, tt.want, got, "%s/%d", tt.proto, tt.port)
+	}
+}
+
+type testGeoLoader struct {
+	GeoIP   map[string]*geodat.GeoIP
+	GeoSite map[string]*geodat.GeoSite
+	ASN     map[string]*geodat.GeoIP
+	RuleSet map[string]*ruleset.RuleSet
+}
+
+func (l *testGeoLoader) LoadGeoIP() (map[string]*geodat.GeoIP, error) {
+	return l.GeoIP, nil
+}
+
+func (l *testGeoLoader) LoadGeoSite() (map[string]*geodat.GeoSite, error) {
+	return l.GeoSite, nil
+}
+
+func (l *testGeoLoader) LoadASN() (map[string]*geodat.GeoIP, error) {
+	return l.ASN, nil
+}
+
+func (l *testGeoLoader) LoadRuleSet(name string) (*ruleset.RuleSet, error) {
+	return l.RuleSet[name], nil
+}
+
+func newTestGeoLoader() *testGeoLoader {
+	return &testGeoLoader{
+		GeoIP: map[string]*geodat.GeoIP{
+			"cn": {CountryCode: "CN", Cidr: []*geodat.CIDR{{Ip: []byte{1, 0, 1, 0}, Prefix: 24}}},
+			"us": {CountryCode: "US", Cidr: []*geodat.CIDR{{Ip: []byte{8, 8, 8, 0}, Prefix: 24}}},
+		},
+		GeoSite: map[string]*geodat.GeoSite{
+			"netflix": {CountryCode: "NETFLIX", Domain: []*geodat.Domain{{Type: geodat.Domain_RootDomain, Value: "netflix.com"}}},
+			"google":  {CountryCode: "GOOGLE", Domain: []*geodat.Domain{{Type: geodat.Domain_RootDomain, Value: "google.com"}}},
+		},
+		ASN: map[string]*geodat.GeoIP{
+			"13335": {CountryCode: "AS13335", Cidr: []*geodat.CIDR{
+				{Ip: []byte{1, 1, 1, 0}, Prefix: 24},
+				{Ip: net.ParseIP("2606:4700::"), Prefix: 32},
+			}},
+			"4134": {CountryCode: "AS4134", Cidr: []*geodat.CIDR{{Ip: []byte{1, 0, 1, 0}, Prefix: 24}}},
+		},
+	}
+}
+
+func TestCompile_BuiltinGeoIP(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT", "reject": "REJECT", "proxy": "PROXY"}
+	rules, err := ParseTextRules(`
+reject(geoip:multicast)
+reject(geoip:reserved)
+direct(geoip:loopback, tcp/22)
+direct(geoip:private)
+proxy(!geoip:private, udp)
+`)
+	require.NoError(t, err)
+	// Built-in codes need no GeoIP database
+	rs, err := Compile[string](rules, outbounds, 16, &NilGeoLoader{})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		ip    string
+		proto Protocol
+		want  string
+	}{
+		{"rfc1918", "172.20.1.1", ProtocolTCP, "DIRECT"},
+		{"cgnat", "100.100.1.1", ProtocolTCP, "DIRECT"},
+		{"link-local", "169.254.169.254", ProtocolTCP, "DIRECT"},
+		{"ula", "fd12::1", ProtocolTCP, "DIRECT"},
+		{"loopback v6", "::1", ProtocolTCP, "DIRECT"},
+		{"multicast", "239.255.255.250", ProtocolUDP, "REJECT"},
+		{"multicast v6", "ff02::fb", ProtocolUDP, "REJECT"},
+		{"documentation", "203.0.113.7", ProtocolTCP, "REJECT"},
+		{"broadcast", "255.255.255.255", ProtocolUDP, "REJECT"},
+		{"public", "8.8.8.8", ProtocolTCP, ""},
+		{"public negated", "8.8.8.8", ProtocolUDP, "PROXY"},
+		{"not cgnat", "100.128.0.1", ProtocolTCP, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := HostInfo{IPv4: net.ParseIP(tt.ip)}
+			if host.IPv4.To4() == nil {
+				host = HostInfo{IPv6: net.ParseIP(tt.ip)}
+			}
+			got, _ := rs.Match(host, tt.proto, 22)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// A database entry is used instead of the built-in list
+	loader := newTestGeoLoader()
+	loader.GeoIP["private"] = &geodat.GeoIP{CountryCode: "PRIVATE", Cidr: []*geodat.CIDR{
+		{Ip: []byte{10, 0, 0, 0}, Prefix: 8},
+		{Ip: []byte{100, 128, 0, 0}, Prefix: 16},
+	}}
+	rs, err = Compile[string](rules, outbounds, 16, loader)
+	require.NoError(t, err)
+	for ip, want := range map[string]string{
+		"10.1.1.1":    "DIRECT",
+		"100.128.0.1": "DIRECT", // only in the database
+		"127.0.0.1":   "DIRECT", // built-in loopback, not in the database
+		"172.20.1.1":  "",
+	} {
+		got, _ := rs.Match(HostInfo{IPv4: net.ParseIP(ip)}, ProtocolTCP, 22)
+		assert.Equal(t, want, got, ip)
+	}
+
+	// Built-in codes are suggested for typos
+	rules, err = ParseTextRules("direct(geoip:privat)")
+	require.NoError(t, err)
+	_, err = Compile[string](rules, outbounds, 16, &NilGeoLoader{})
+	assert.ErrorContains(t, err, `did you mean "private"?`)
+}
+
+func Test_builtinGeoIPList(t *testing.T) {
+	for code := range builtinGeoIP {
+		list := builtinGeoIPList(code)
+		require.NotNil(t, list, code)
+		m, err := newGeoIPMatcher(list)
+		require.NoError(t, err, code)
+		assert.Equal(t, len(list.Cidr), m.Nets.Len(), code)
+		for _, cidr := range list.Cidr {
+			assert.True(t, m.matchIP(iptree.AddrFromIP(cidr.Ip)), "%s: %s/%d", code, net.IP(cidr.Ip), cidr.Prefix)
+		}
+	}
+	assert.Nil(t, builtinGeoIPList("cn"))
+}
+
+func TestCompile_SessionConditions(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT", "proxy": "PROXY", "reject": "REJECT"}
+	rules, err := ParseTextRules(`
+reject(and(src:10.1.0.0/16, geoip:cn))
+direct(src:10.1.2.3)
+proxy(or(inbound:Office, user:Alice))
+reject(and(!inbound:office, suffix:example.com), tcp)
+`)
+	require.NoError(t, err)
+	rs, err := Compile[string](rules, outbounds, 16, newTestGeoLoader())
+	require.NoError(t, err)
+
+	cn := net.ParseIP("1.0.1.1")
+	tests := []struct {
+		name string
+		host HostInfo
+		want string
+	}{
+		{"src and geoip", HostInfo{IPv4: cn, SrcIP: net.ParseIP("10.1.9.9")}, "REJECT"},
+		{"src single ip", HostInfo{IPv4: net.ParseIP("8.8.8.8"), SrcIP: net.ParseIP("10.1.2.3")}, "DIRECT"},
+		{"same destination, other src", HostInfo{IPv4: cn, SrcIP: net.ParseIP("10.2.0.1")}, ""},
+		{"inbound", HostInfo{IPv4: cn, Inbound: "office"}, "PROXY"},
+		{"user", HostInfo{IPv4: cn, User: "Alice"}, "PROXY"},
+		{"user is case-sensitive", HostInfo{IPv4: cn, User: "alice"}, ""},
+		{"negated inbound", HostInfo{Name: "www.example.com", Inbound: "home"}, "REJECT"},
+		{"negated inbound, office", HostInfo{Name: "www.example.com", Inbound: "OFFICE"}, "PROXY"},
+	}
+	// Run twice, so that the second round is served from the cache
+	for round := 0; round < 2; round++ {
+		for _, tt := range tests {
+			got, _ := rs.Match(tt.host, ProtocolTCP, 443)
+			assert.Equal(t, tt.want, got, "round %d: %s", round, tt.name)
+		}
+	}
+
+	errTests := []struct {
+		text    string
+		wantMsg string
+	}{
+		{"direct(src:)", "empty source address"},
+		{"direct(src:example.com)", "invalid source address: example.com"},
+		{"direct(src:10.0.0.0/33)", "invalid source address"},
+		{"direct(inbound:)", "empty inbound tag"},
+		{"direct(user:)", "empty user name"},
+	}
+	for _, tt := range errTests {
+		rules, err := ParseTextRules(tt.text)
+		require.NoError(t, err)
+		_, err = Compile[string](rules, outbounds, 16, newTestGeoLoader())
+		assert.ErrorContains(t, err, tt.wantMsg, tt.text)
+	}
+}
+
+func TestCompile_ASN(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT", "proxy": "PROXY"}
+	rules, err := ParseTextRules(`
+proxy(asn:13335)
+direct(and(ASN:AS4134, geoip:cn))
+proxy(!asn:as4134, udp)
+`)
+	require.NoError(t, err)
+	rs, err := Compile[string](rules, outbounds, 16, newTestGeoLoader())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		host  HostInfo
+		proto Protocol
+		want  string
+	}{
+		{"asn v4", HostInfo{IPv4: net.ParseIP("1.1.1.1")}, ProtocolTCP, "PROXY"},
+		{"asn v6", HostInfo{IPv6: net.ParseIP("2606:4700::1111")}, ProtocolTCP, "PROXY"},
+		{"AS prefix", HostInfo{IPv4: net.ParseIP("1.0.1.1")}, ProtocolTCP, "DIRECT"},
+		{"negated", HostInfo{IPv4: net.ParseIP("9.9.9.9")}, ProtocolUDP, "PROXY"},
+		{"no match", HostInfo{IPv4: net.ParseIP("9.9.9.9")}, ProtocolTCP, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := rs.Match(tt.host, tt.proto, 443)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	errTests := []struct {
+		text    string
+		loader  GeoLoader
+		wantMsg string
+	}{
+		{"direct(asn:AS)", newTestGeoLoader(), `invalid AS number "as"`},
+		{"direct(asn:0)", newTestGeoLoader(), `invalid AS number "0"`},
+		{"direct(asn:15169)", newTestGeoLoader(), "AS15169 not found"},
+		{"direct(asn:15169)", &NilGeoLoader{}, "AS15169 not found"},
+		{"direct(asn:15169)", &struct{ GeoLoader }{&NilGeoLoader{}}, "not supported by the GeoLoader"},
+	}
+	for _, tt := range errTests {
+		rules, err := ParseTextRules(tt.text)
+		require.NoError(t, err)
+		_, err = Compile[string](rules, outbounds, 16, tt.loader)
+		assert.ErrorContains(t, err, tt.wantMsg, tt.text)
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT", "proxy": "PROXY", "reject": "REJECT"}
+	text := `direct(all)
+prxy(suffix:example.com)
+proxy(geosite:netflx)
+direct(geoip:cm, tcp/9000-8000)
+reject(10.0.0.0/33)
+direct(all, udp/53, 127.0.0.1:0)
+`
+	rules, err := ParseTextRules(text, WithSource("test.acl"))
+	require.NoError(t, err)
+
+	t.Run("first error", func(t *testing.T) {
+		_, err := Compile[string](rules, outbounds, 16, newTestGeoLoader())
+		var compErr *CompilationError
+		require.ErrorAs(t, err, &compErr)
+		assert.Equal(t, `error at test.acl line 2, column 1: outbound prxy not found (did you mean "proxy"?)`, err.Error())
+	})
+
+	t.Run("all errors", func(t *testing.T) {
+		_, err := Compile[string](rules, outbounds, 16, newTestGeoLoader(), WithAllErrors())
+		var errs ErrorList
+		require.ErrorAs(t, err, &errs)
+		want := []struct {
+			line, column int
+			suggestion   string
+		}{
+			{2, 1, "proxy"},
+			{3, 7, "netflix"},
+			{4, 8, "cn"},
+			{4, 18, ""},
+			{5, 8, ""},
+			{6, 21, ""},
+		}
+		require.Len(t, errs, len(want), err.Error())
+		for i, w := range want {
+			compErr := errs[i].(*CompilationError)
+			assert.Equal(t, "test.acl", compErr.Source)
+			assert.Equal(t, w.line, compErr.LineNum, compErr.Error())
+			assert.Equal(t, w.column, compErr.Column, compErr.Error())
+			assert.Equal(t, w.suggestion, compErr.Suggestion, compErr.Error())
+		}
+	})
+}
+
+func TestCompile_DomainRules(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT", "proxy": "PROXY", "reject": "REJECT"}
+	rules, err := ParseTextRules(`
+reject(keyword:doubleclick)
+proxy(regexp:^api\d*\.example\.(com|net)$)
+direct(full:example.com)
+proxy(DOMAIN:Example.org)
+direct(!regexp:\.cn$, udp)
+`)
+	require.NoError(t, err)
+	rs, err := Compile[string](rules, outbounds, 16, newTestGeoLoader())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		host  string
+		proto Protocol
+		want  string
+	}{
+		{"keyword", "ad.doubleclick.net", ProtocolTCP, "REJECT"},
+		{"regexp", "api2.example.net", ProtocolTCP, "PROXY"},
+		{"regexp is case insensitive for hosts", "API.Example.com", ProtocolTCP, "PROXY"},
+		{"regexp no match", "apix.example.com", ProtocolTCP, ""},
+		{"full", "example.com", ProtocolTCP, "DIRECT"},
+		{"full subdomain", "www.example.com", ProtocolTCP, ""},
+		{"domain", "example.org", ProtocolTCP, "PROXY"},
+		{"domain subdomain", "www.example.org", ProtocolTCP, "PROXY"},
+		{"domain partial label", "badexample.org", ProtocolUDP, "DIRECT"},
+		{"negated regexp", "example.cn", ProtocolUDP, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := rs.Match(HostInfo{Name: tt.host}, tt.proto, 443)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	errTests := []struct {
+		text    string
+		wantMsg string
+	}{
+		{`direct("regexp:a(b")`, "invalid regular expression a(b"},
+		{`direct(regexp:a\Z)`, "invalid regular expression"},
+		{`direct(keyword:)`, "empty keyword"},
+		{`direct(and(all, full:))`, "empty domain"},
+	}
+	for _, tt := range errTests {
+		rules, err := ParseTextRules(tt.text)
+		require.NoError(t, err)
+		_, err = Compile[string](rules, outbounds, 16, newTestGeoLoader())
+		assert.ErrorContains(t, err, tt.wantMsg, tt.text)
+	}
+}
+
+func TestCompile_Negation(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT", "proxy": "PROXY", "reject": "REJECT"}
+	rules, err := ParseTextRules(`
+reject(all, !tcp/22)
+direct(!geoip:cn)
+proxy(!suffix:corp.example)
+direct(all)
+`)
+	require.NoError(t, err)
+	rs, err := Compile[string](rules, outbounds, 16, newTestGeoLoader())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		host  HostInfo
+		proto Protocol
+		port  uint16
+		want  string
+	}{
+		{"not tcp/22 is rejected", HostInfo{Name: "example.com"}, ProtocolUDP, 22, "REJECT"},
+		{"not geoip:cn goes direct", HostInfo{IPv4: net.ParseIP("8.8.8.8")}, ProtocolTCP, 22, "DIRECT"},
+		{"cn is not corp.example", HostInfo{Name: "www.example.cn", IPv4: net.ParseIP("1.0.1.1")}, ProtocolTCP, 22, "PROXY"},
+		{"corp.example falls through", HostInfo{Name: "git.corp.example", IPv4: net.ParseIP("1.0.1.2")}, ProtocolTCP, 22, "DIRECT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := rs.Match(tt.host, tt.proto, tt.port)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err = Compile[string]([]TextRule{{Outbound: "direct", Address: "!"}}, outbounds, 16, newTestGeoLoader())
+	assert.Error(t, err)
+}
+
+func TestCompile_Composite(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT", "proxy": "PROXY", "reject": "REJECT"}
+	rules, err := ParseTextRules(`
+proxy(and(geosite:netflix, geoip:us))
+reject(or(suffix:a.com, suffix:b.com), tcp)
+direct(and(suffix:corp.example, not(or(geoip:cn, "x,y.corp.example"))))
+proxy(AND (!geoip:cn, \
+    suffix:example.com))
+`)
+	require.NoError(t, err)
+	rs, err := Compile[string](rules, outbounds, 16, newTestGeoLoader())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		host  HostInfo
+		proto Protocol
+		want  string
+	}{
+		{"netflix in us", HostInfo{Name: "www.netflix.com", IPv4: net.ParseIP("8.8.8.8")}, ProtocolTCP, "PROXY"},
+		{"netflix outside us", HostInfo{Name: "www.netflix.com", IPv4: net.ParseIP("9.9.9.9")}, ProtocolTCP, ""},
+		{"or first", HostInfo{Name: "www.a.com"}, ProtocolTCP, "REJECT"},
+		{"or second", HostInfo{Name: "b.com"}, ProtocolTCP, "REJECT"},
+		{"or wrong protocol", HostInfo{Name: "b.com"}, ProtocolUDP, ""},
+		{"nested not", HostInfo{Name: "git.corp.example", IPv4: net.ParseIP("8.8.8.8")}, ProtocolTCP, "DIRECT"},
+		{"nested not excluded by geoip", HostInfo{Name: "git.corp.example", IPv4: net.ParseIP("1.0.1.1")}, ProtocolTCP, ""},
+		{"nested not excluded by quoted name", HostInfo{Name: "x,y.corp.example"}, ProtocolTCP, ""},
+		{"continued and with negation", HostInfo{Name: "example.com", IPv4: net.ParseIP("8.8.8.8")}, ProtocolUDP, "PROXY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := rs.Match(tt.host, tt.proto, 443)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCompile_CompositeErrors(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT"}
+	tests := []struct {
+		name       string
+		text       string
+		wantLine   int
+		wantColumn int
+		wantMsg    string
+	}{
+		{"nested bad cidr", "direct(and(all, or(1.1.1.1, 10.0.0.0/33)))", 1, 29, "invalid CIDR address"},
+		{"nested unknown geosite", "direct(or(suffix:a.com, geosite:netflx))", 1, 25, `did you mean "netflix"?`},
+		{"unknown operator", "direct(nand(a, b))", 1, 8, `unknown operator nand (did you mean "and"?)`},
+		{"not with two args", "direct(not(a, b))", 1, 12, "not() takes exactly one address"},
+		{"empty arg", "direct(and(a, , b))", 1, 15, "empty address in and()"},
+		{"trailing text", "direct(and(a, b) c)", 1, 18, `unexpected 'c' after and()`},
+		{"continued", "direct(and(a, \\\n  10.0.0.0/33))", 2, 3, "invalid CIDR address"},
+		{"negated nested", "direct(!or(a, 10.0.0.0/33))", 1, 15, "invalid CIDR address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseTextRules(tt.text)
+			require.NoError(t, err)
+			_, err = Compile[string](rules, outbounds, 16, newTestGeoLoader())
+			var compErr *CompilationError
+			require.ErrorAs(t, err, &compErr)
+			assert.Equal(t, tt.wantLine, compErr.LineNum, err.Error())
+			assert.Equal(t, tt.wantColumn, compErr.Column, err.Error())
+			assert.Contains(t, err.Error(), tt.wantMsg)
+		})
+	}
+}
+
+func TestCompile_AddressSets(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT", "proxy": "PROXY", "reject": "REJECT"}
+	rules, err := ParseTextRules(`
+reject(and(@Streaming, !@lan))
+direct(@lan)
+proxy(or(@streaming, geoip:us), udp)
+@lan = 10.0.0.0/8, 192.168.0.0/16, fd00::/8
+@streaming = geosite:netflix, suffix:hulu.com
+@unused = example.com
+`)
+	require.NoError(t, err)
+	rs, err := Compile[string](rules, outbounds, 16, newTestGeoLoader())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		host  HostInfo
+		proto Protocol
+		want  string
+	}{
+		{"lan v4", HostInfo{IPv4: net.ParseIP("192.168.1.1")}, ProtocolTCP, "DIRECT"},
+		{"lan v6", HostInfo{IPv6: net.ParseIP("fd00::1")}, ProtocolTCP, "DIRECT"},
+		{"streaming", HostInfo{Name: "www.hulu.com"}, ProtocolTCP, "REJECT"},
+		{"streaming in lan", HostInfo{Name: "www.netflix.com", IPv4: net.ParseIP("10.1.1.1")}, ProtocolTCP, "DIRECT"},
+		{"set in or", HostInfo{IPv4: net.ParseIP("8.8.8.8")}, ProtocolUDP, "PROXY"},
+		{"no match", HostInfo{Name: "example.com"}, ProtocolTCP, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := rs.Match(tt.host, tt.proto, 443)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// Both references to @lan share one compiled matcher
+	impl := rs.(*compiledRuleSetImpl[string])
+	require.Len(t, impl.Rules, 3)
+	lan := impl.Rules[1].HostMatcher
+	inv := impl.Rules[0].HostMatcher.(*andMatcher).Matchers[1].(*inverseMatcher)
+	assert.Same(t, lan, inv.Matcher)
+}
+
+func TestCompile_AddressSetErrors(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT"}
+	tests := []struct {
+		name       string
+		text       string
+		wantLine   int
+		wantColumn int
+		wantMsg    string
+	}{
+		{"undefined", "@lan = 10.0.0.0/8\ndirect(@lam)", 2, 8, `undefined address set @lam (did you mean "lan"?)`},
+		{"undefined in composite", "direct(and(all, @x))", 1, 17, "undefined address set @x"},
+		{"recursive", "@a = 1.1.1.1, @b\n@b = @a\ndirect(@a)", 2, 6, "recursive reference to address set @a (@a -> @b -> @a)"},
+		{"self reference", "@a = @a\ndirect(@a)", 1, 6, "(@a -> @a)"},
+		{"error in definition", "direct(@lan)\n@lan = 10.0.0.0/8, 10.0.0.0/33", 2, 20, "invalid CIDR address"},
+		{"error in unused definition", "@lan = geoip:cm", 1, 8, `did you mean "cn"?`},
+		{"duplicate", "@lan = a\n@LAN = b", 2, 1, "address set @LAN already defined at line 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseTextRules(tt.text)
+			require.NoError(t, err)
+			_, err = Compile[string](rules, outbounds, 16, newTestGeoLoader())
+			var compErr *CompilationError
+			require.ErrorAs(t, err, &compErr)
+			assert.Equal(t, tt.wantLine, compErr.LineNum, err.Error())
+			assert.Equal(t, tt.wantColumn, compErr.Column, err.Error())
+			assert.Contains(t, err.Error(), tt.wantMsg)
+		})
+	}
+}
+
+func TestCompile_Schedule(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT", "reject": "REJECT"}
+	rules, err := ParseTextRules(`
+reject(suffix:games.com, schedule="mon-fri 09:00-17:00 UTC")
+direct(all)
+`)
+	require.NoError(t, err)
+	rs, err := Compile[string](rules, outbounds, 16, &NilGeoLoader{})
+	require.NoError(t, err)
+
+	impl := rs.(*compiledRuleSetImpl[string])
+	now := time.Date(2024, 6, 7, 16, 59, 0, 0, time.UTC) // Friday
+	impl.Now = func() time.Time { return now }
+	host := HostInfo{Name: "www.games.com"}
+
+	steps := []struct {
+		now  time.Time
+		want string
+	}{
+		{time.Date(2024, 6, 7, 16, 59, 0, 0, time.UTC), "REJECT"},
+		{time.Date(2024, 6, 7, 16, 59, 59, 0, time.UTC), "REJECT"}, // cached
+		{time.Date(2024, 6, 7, 17, 0, 0, 0, time.UTC), "DIRECT"},   // cached result expired
+		{time.Date(2024, 6, 10, 8, 0, 0, 0, time.UTC), "DIRECT"},   // Monday
+		{time.Date(2024, 6, 10, 9, 30, 0, 0, time.UTC), "REJECT"},
+	}
+	for _, step := range steps {
+		now = step.now
+		got, _ := rs.Match(host, ProtocolTCP, 443)
+		assert.Equal(t, step.want, got, step.now.String())
+	}
+}
+
+func TestCompile_ScheduleError(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT"}
+	rules, err := ParseTextRules(`direct(all, schedule="mon-fri 9:00-25:00")`)
+	require.NoError(t, err)
+	_, err = Compile[string](rules, outbounds, 16, &NilGeoLoader{})
+	var compErr *CompilationError
+	require.ErrorAs(t, err, &compErr)
+	assert.Equal(t, 1, compErr.LineNum)
+	assert.Equal(t, 13, compErr.Column)
+	assert.Contains(t, err.Error(), `invalid time "25:00"`)
+}
+
+func TestCompile_RegexpEscapes(t *testing.T) {
+	// Backslashes in regular expressions are passed on as written
+	rules, err := ParseTextRules(`
+proxy(regexp:^a\(b\)$)
+proxy("regexp:^x{1,3}\.com$")
+reject(or(regexp:^c\,d$, regexp:^e\#f$))
+direct(all)
+`)
+	require.NoError(t, err)
+	rs, err := Compile(rules, map[string]string{"direct": "DIRECT", "proxy": "PROXY", "reject": "REJECT"}, 16, &NilGeoLoader{})
+	require.NoError(t, err)
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"a(b)", "PROXY"},
+		{"ab", "DIRECT"},
+		{"xx.com", "PROXY"},
+		{"xxxx.com", "DIRECT"},
+		{"c,d", "REJECT"},
+		{"e#f", "REJECT"},
+	}
+	for _, tt := range tests {
+		got, _ := rs.Match(HostInfo{Name: tt.name}, ProtocolTCP, 443)
+		assert.Equal(t, tt.want, got, tt.name)
+	}
+}
@@ -0,0 +1,122 @@
+package acl
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// condition is the condition of a rule converted from another format (Clash
+// rules, sing-box rule-sets), split into the host part and the protocol/port
+// part, so that and/or/not combinations of them can be written as a TextRule.
+type condition struct {
+	Address string          // empty for all hosts
+	Ports   protoPortFilter // never inverse
+}
+
+func hostCondition(addr string) condition {
+	return condition{Address: addr, Ports: anyProtoPort}
+}
+
+func (c *condition) allPorts() bool {
+	return c.Ports.matchesAll()
+}
+
+// textRule returns the rule sending connections matching c to outbound.
+func (c *condition) textRule(outbound string) (TextRule, error) {
+	if len(c.Ports.TCP) == 0 && len(c.Ports.UDP) == 0 {
+		return TextRule{}, fmt.Errorf("rule never matches, no protocol and port matches all its conditions")
+	}
+	rule := TextRule{Outbound: outbound, Address: c.Address}
+	if rule.Address == "" {
+		rule.Address = "all"
+	}
+	if ports := c.Ports.String(); ports != "*" {
+		rule.ProtoPort = ports
+	}
+	return rule, nil
+}
+
+func andConditions(conds []condition) condition {
+	var addrs []string
+	ports := anyProtoPort
+	for _, c := range conds {
+		if c.Address != "" {
+			addrs = append(addrs, c.Address)
+		}
+		ports.TCP = ports.TCP.intersect(c.Ports.TCP)
+		ports.UDP = ports.UDP.intersect(c.Ports.UDP)
+	}
+	return condition{Address: joinAddresses("and", addrs), Ports: ports}
+}
+
+// orConditions combines conditions that either all match hosts or all match
+// protocols and ports, as an address cannot depend on the port.
+func orConditions(conds []condition) (condition, error) {
+	var addrs []string
+	var ports protoPortFilter
+	for _, c := range conds {
+		switch {
+		case c.Address == "" && c.allPorts():
+			return hostCondition(""), nil
+		case c.Address == "":
+			ports.TCP = newPortSet(slices.Concat(ports.TCP, c.Ports.TCP))
+			ports.UDP = newPortSet(slices.Concat(ports.UDP, c.Ports.UDP))
+		case c.allPorts():
+			addrs = append(addrs, c.Address)
+		default:
+			return condition{}, fmt.Errorf("OR cannot combine sub-rules matching both hosts and ports")
+		}
+	}
+	switch {
+	case len(addrs) == 0:
+		return condition{Ports: ports}, nil
+	case len(ports.TCP) == 0 && len(ports.UDP) == 0:
+		return hostCondition(joinAddresses("or", addrs)), nil
+	default:
+		return condition{}, fmt.Errorf("OR cannot combine host and port sub-rules")
+	}
+}
+
+func notCondition(c condition) (condition, error) {
+	switch {
+	case c.Address == "" && c.allPorts():
+		return condition{}, fmt.Errorf("NOT of a sub-rule matching everything never matches")
+	case c.Address == "":
+		return condition{Ports: protoPortFilter{
+			TCP: c.Ports.TCP.complement(),
+			UDP: c.Ports.UDP.complement(),
+		}}, nil
+	case c.allPorts():
+		return hostCondition("!" + c.Address), nil
+	default:
+		return condition{}, fmt.Errorf("NOT cannot negate a sub-rule matching both hosts and ports")
+	}
+}
+
+// joinAddresses combines addresses with a composite operator, if there is
+// more than one, quoting those that contain commas or parentheses, as in
+// regular expressions.
+func joinAddresses(op string, addrs []string) string {
+	switch len(addrs) {
+	case 0:
+		return ""
+	case 1:
+		return addrs[0]
+	default:
+		items := make([]string, len(addrs))
+		for i, addr := range addrs {
+			items[i] = quoteArg(addr)
+		}
+		return op + "(" + strings.Join(items, ", ") + ")"
+	}
+}
+
+// checkDomain rejects domains that would be read as another kind of
+// address, such as "geoip:cn", and keywords that no domain can contain.
+func checkDomain(domain string) error {
+	if strings.ContainsAny(domain, ":/@!()\" \t") {
+		return fmt.Errorf("invalid domain %s", domain)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package domain
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// IndexEntry is a rule of an Index: an exact domain, or a domain suffix with
+// the same meaning as in NewMatcher ("google.com" matches the domain and its
+// subdomains, ".google.com" only its subdomains).
+type IndexEntry struct {
+	Domain string
+	Suffix bool
+	ID     int
+}
+
+// Index finds all the rules that match a domain, using the same succinct trie
+// as Matcher. Unlike Matcher, which only reports whether any rule matches, it
+// returns the IDs of the matching rules, so that callers can pick the first
+// one in their own order.
+type Index struct {
+	set       *succinctSet
+	leafRanks []int32 // rank index of set.leaves
+	// The IDs of the entries of the key ending at the leaf with rank r are
+	// ids[offsets[r]:offsets[r+1]], in ascending order.
+	offsets []int32
+	ids     []int
+}
+
+// NewIndex creates an index of the given entries. Several entries may have the
+// same domain.
+func NewIndex(entries []IndexEntry) *Index {
+	byKey := make(map[string][]int, len(entries))
+	for _, e := range entries {
+		domain := strings.ToLower(e.Domain)
+		key := reverseDomain(domain)
+		if e.Suffix {
+			if rest, ok := strings.CutPrefix(domain, "."); ok {
+				key = reverseDomain(string(prefixLabel) + "." + rest)
+			} else {
+				key = reverseDomain(string(rootLabel) + domain)
+			}
+		}
+		byKey[key] = append(byKey[key], e.ID)
+	}
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	x := &Index{set: newSuccinctSet(keys)}
+	x.leafRanks = indexRank64(x.set.leaves)
+	lists := make([][]int, len(keys))
+	for _, key := range keys {
+		node, ok := x.node(key)
+		if !ok {
+			panic("domain: index key not found: " + key)
+		}
+		ids := byKey[key]
+		slices.Sort(ids)
+		lists[x.leafRank(node)] = ids
+	}
+	x.offsets = make([]int32, 0, len(keys)+1)
+	for _, ids := range lists {
+		x.offsets = append(x.offsets, int32(len(x.ids))) // #nosec G115 -- entry count fits in int32
+		x.ids = append(x.ids, ids...)
+	}
+	x.offsets = append(x.offsets, int32(len(x.ids))) // #nosec G115 -- entry count fits in int32
+	return x
+}
+
+// AppendMatches appends the IDs of the entries matching domain to dst, in
+// ascending order, and returns the extended slice.
+func (x *Index) AppendMatches(dst []int, domain string) []int {
+	if len(x.set.labels) == 0 || domain == "" {
+		return dst
+	}
+	start := len(dst)
+	key := reverseDomain(strings.ToLower(domain))
+	node := 0
+	for i := 0; ; i++ {
+		next := -1
+		for bmIdx := x.edges(node); getBit(x.set.labelBitmap, bmIdx) == 0; bmIdx++ {
+			label := x.set.labels[bmIdx-node]
+			switch {
+			case label == prefixLabel && i < len(key) && i > 0 && key[i-1] == '.':
+				// ".google.com", and the domain has more labels
+				dst = x.appendIDs(dst, x.child(bmIdx))
+			case label == rootLabel && (i == len(key) || key[i] == '.'):
+				// "google.com", and the domain is google.com or a subdomain
+				dst = x.appendIDs(dst, x.child(bmIdx))
+			case i < len(key) && label == key[i]:
+				next = x.child(bmIdx)
+			}
+		}
+		if i == len(key) {
+			if getBit(x.set.leaves, node) != 0 {
+				dst = x.appendIDs(dst, node)
+			}
+			break
+		}
+		if next < 0 {
+			break
+		}
+		node = next
+	}
+	if len(dst)-start > 1 {
+		slices.Sort(dst[start:])
+	}
+	return dst
+}
+
+// edges returns the position in the label bitmap of the first edge of node.
+func (x *Index) edges(node int) int {
+	if node == 0 {
+		return 0
+	}
+	return selectIthOne(x.set.labelBitmap, x.set.ranks, x.set.selects, node-1) + 1
+}
+
+// child returns the node that the edge at position bmIdx leads to.
+func (x *Index) child(bmIdx int) int {
+	return countZeros(x.set.labelBitmap, x.set.ranks, bmIdx+1)
+}
+
+// node returns the node at the end of key.
+func (x *Index) node(key string) (int, bool) {
+	node := 0
+	for i := 0; i < len(key); i++ {
+		next := -1
+		for bmIdx := x.edges(node); getBit(x.set.labelBitmap, bmIdx) == 0; bmIdx++ {
+			if x.set.labels[bmIdx-node] == key[i] {
+				next = x.child(bmIdx)
+				break
+			}
+		}
+		if next < 0 {
+			return 0, false
+		}
+		node = next
+	}
+	return node, getBit(x.set.leaves, node) != 0
+}
+
+// leafRank returns the number of leaves before node.
+func (x *Index) leafRank(node int) int {
+	r, _ := rank64(x.set.leaves, x.leafRanks, int32(node)) // #nosec G115 -- node index fits in int32
+	return int(r)
+}
+
+func (x *Index) appendIDs(dst []int, leaf int) []int {
+	r := x.leafRank(leaf)
+	return append(dst, x.ids[x.offsets[r]:x.offsets[r+1]]...)
+}
@@ -0,0 +1,106 @@
+package domain
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestIndex_AppendMatches(t *testing.T) {
+	index := NewIndex([]IndexEntry{
+		{Domain: "example.com", Suffix: true, ID: 5},
+		{Domain: "www.example.com", ID: 1},
+		{Domain: ".example.com", Suffix: true, ID: 3},
+		{Domain: "Example.com", ID: 7},
+		{Domain: "www.example.com", ID: 0},
+		{Domain: "com", Suffix: true, ID: 9},
+		{Domain: "service.io", Suffix: true, ID: 2},
+		{Domain: "api.service.io", Suffix: true, ID: 4},
+	})
+
+	tests := []struct {
+		domain string
+		want   []int
+	}{
+		{"www.example.com", []int{0, 1, 3, 5, 9}},
+		{"WWW.Example.COM", []int{0, 1, 3, 5, 9}},
+		{"example.com", []int{5, 7, 9}},
+		{"a.b.example.com", []int{3, 5, 9}},
+		{"myexample.com", []int{9}},
+		{"com", []int{9}},
+		{"api.service.io", []int{2, 4}},
+		{"x.api.service.io", []int{2, 4}},
+		{"xapi.service.io", []int{2}},
+		{"service.io.cn", nil},
+		{"io", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			got := index.AppendMatches(nil, tt.domain)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("AppendMatches(%q) = %v, want %v", tt.domain, got, tt.want)
+			}
+		})
+	}
+
+	// AppendMatches keeps the contents of dst
+	if got := index.AppendMatches([]int{42}, "example.com"); !slices.Equal(got, []int{42, 5, 7, 9}) {
+		t.Errorf("AppendMatches with dst = %v", got)
+	}
+}
+
+func TestIndex_Empty(t *testing.T) {
+	index := NewIndex(nil)
+	if got := index.AppendMatches(nil, "example.com"); len(got) != 0 {
+		t.Errorf("empty index matched %v", got)
+	}
+}
+
+func TestIndex_MatchesMatcher(t *testing.T) {
+	// An index gives the same answers as a Matcher of the same rules
+	var domains, suffixes []string
+	var entries []IndexEntry
+	for i := range 300 {
+		name := fmt.Sprintf("host%d.example%d.com", i, i%7)
+		switch i % 3 {
+		case 0:
+			domains = append(domains, name)
+			entries = append(entries, IndexEntry{Domain: name, ID: i})
+		case 1:
+			suffixes = append(suffixes, name)
+			entries = append(entries, IndexEntry{Domain: name, Suffix: true, ID: i})
+		default:
+			suffixes = append(suffixes, "."+name)
+			entries = append(entries, IndexEntry{Domain: "." + name, Suffix: true, ID: i})
+		}
+	}
+	matcher := NewMatcher(domains, suffixes)
+	index := NewIndex(entries)
+	for i := range 300 {
+		for _, domain := range []string{
+			fmt.Sprintf("host%d.example%d.com", i, i%7),
+			fmt.Sprintf("a.host%d.example%d.com", i, i%7),
+			fmt.Sprintf("host%d.example%d.com", i, (i+1)%7),
+		} {
+			got := index.AppendMatches(nil, domain)
+			if want := matcher.Match(domain); (len(got) > 0) != want {
+				t.Errorf("AppendMatches(%q) = %v, Match = %v", domain, got, want)
+			}
+		}
+	}
+}
+
+func BenchmarkIndex_AppendMatches(b *testing.B) {
+	entries := make([]IndexEntry, 20000)
+	for i := range entries {
+		entries[i] = IndexEntry{Domain: fmt.Sprintf("host%d.example%c.com", i, 'a'+i%26), Suffix: i%2 == 0, ID: i}
+	}
+	index := NewIndex(entries)
+	var dst []int
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dst = index.AppendMatches(dst[:0], "www.host10000.examplem.com")
+	}
+}
@@ -70,10 +70,31 @@ func (m *Matcher) Match(domain string) bool {
 	return m.has(reverseDomain(domain))
 }
 
+// Lookup is like Match, but also returns the rule that matched, as passed to
+// NewMatcher, and whether it is a suffix rule (from domainSuffix).
+func (m *Matcher) Lookup(domain string) (rule string, suffix bool, ok bool) {
+	if m.set == nil || len(m.set.labels) == 0 {
+		return "", false, false
+	}
+	key := reverseDomain(strings.ToLower(domain))
+	n, suffix, ok := m.lookup(key)
+	if !ok {
+		return "", false, false
+	}
+	return reverseDomain(key[:n]), suffix, true
+}
+
 // has performs the actual matching on the reversed domain.
 func (m *Matcher) has(key string) bool {
+	_, _, ok := m.lookup(key)
+	return ok
+}
+
+// lookup matches the reversed domain key. The matching rule, reversed, is
+// key[:n], and suffix reports whether it is a suffix rule.
+func (m *Matcher) lookup(key string) (n int, suffix bool, ok bool) {
 	if len(m.set.labelBitmap) == 0 || len(m.set.labels) == 0 {
-		return false
+		return 0, false, false
 	}
 
 	var nodeId, bmIdx int
@@ -86,20 +107,20 @@ func (m *Matcher) has(key string) bool {
 		for {
 			// Check if we've reached the end of this node's edges
 			if getBit(m.set.labelBitmap, bmIdx) != 0 {
-				return false // No matching edge found
+				return 0, false, false // No matching edge found
 			}
 
 			// Bounds check for labels array
 			labelIdx := bmIdx - nodeId
 			if labelIdx < 0 || labelIdx >= len(m.set.labels) {
-				return false
+				return 0, false, false
 			}
 
 			nextLabel := m.set.labels[labelIdx]
 
 			// Check for suffix match marker
 			if nextLabel == prefixLabel {
-				return true // Found suffix match
+				return i, true, true // Found suffix match
 			}
 
 			// Check for root domain marker
@@ -108,7 +129,7 @@ func (m *Matcher) has(key string) bool {
 				hasNext := getBit(m.set.leaves, nextNodeId) != 0
 				// If current char is dot and node is leaf, we have subdomain match
 				if currentChar == '.' && hasNext {
-					return true
+					return i, true, true
 				}
 			}
 
@@ -123,30 +144,30 @@ func (m *Matcher) has(key string) bool {
 		// Move to next node
 		nodeId = countZeros(m.set.labelBitmap, m.set.ranks, bmIdx+1)
 		if nodeId <= 0 {
-			return false
+			return 0, false, false
 		}
 		bmIdx = selectIthOne(m.set.labelBitmap, m.set.ranks, m.set.selects, nodeId-1) + 1
 	}
 
 	// Check if we're at a leaf node (exact match)
 	if getBit(m.set.leaves, nodeId) != 0 {
-		return true
+		return len(key), false, true
 	}
 
 	// Check for suffix/root markers after consuming all input
 	for {
 		if getBit(m.set.labelBitmap, bmIdx) != 0 {
-			return false
+			return 0, false, false
 		}
 
 		labelIdx := bmIdx - nodeId
 		if labelIdx < 0 || labelIdx >= len(m.set.labels) {
-			return false
+			return 0, false, false
 		}
 
 		nextLabel := m.set.labels[labelIdx]
 		if nextLabel == prefixLabel || nextLabel == rootLabel {
-			return true
+			return len(key), true, true
 		}
 		bmIdx++
 	}
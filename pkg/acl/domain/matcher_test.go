@@ -256,6 +256,35 @@ func TestMatcher_MixedDomainTypes(t *testing.T) {
 	}
 }
 
+func TestMatcher_Lookup(t *testing.T) {
+	matcher := NewMatcher([]string{"api.service.io", "cdn.example.net"}, []string{"example.com", ".service.io"})
+
+	tests := []struct {
+		domain     string
+		wantRule   string
+		wantSuffix bool
+		wantOk     bool
+	}{
+		{"example.com", "example.com", true, true},
+		{"Mail.Example.com", "example.com", true, true},
+		{"app.service.io", ".service.io", true, true},
+		{"api.service.io", ".service.io", true, true},
+		{"cdn.example.net", "cdn.example.net", false, true},
+		{"service.io", "", false, false},
+		{"example.net", "", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			rule, suffix, ok := matcher.Lookup(tt.domain)
+			if rule != tt.wantRule || suffix != tt.wantSuffix || ok != tt.wantOk {
+				t.Errorf("Lookup(%q) = %q, %v, %v, want %q, %v, %v",
+					tt.domain, rule, suffix, ok, tt.wantRule, tt.wantSuffix, tt.wantOk)
+			}
+		})
+	}
+}
+
 // Benchmark tests
 func BenchmarkMatcher_Match_Hit_First(b *testing.B) {
 	// Benchmark matching first domain in list
@@ -0,0 +1,215 @@
+package domain
+
+import (
+	"math/bits"
+	"slices"
+	"strings"
+)
+
+// MatchWildcard reports whether name matches pattern, in which "*" matches any
+// sequence of characters, including none and dots. It does not backtrack: the
+// literal parts between the stars are searched for from left to right, so the
+// time it takes grows linearly with the length of name, however many stars
+// pattern has.
+func MatchWildcard(pattern, name string) bool {
+	first, rest, ok := strings.Cut(pattern, "*")
+	if !ok {
+		return name == pattern
+	}
+	if !strings.HasPrefix(name, first) {
+		return false
+	}
+	name = name[len(first):]
+	last := rest[strings.LastIndexByte(rest, '*')+1:]
+	rest = rest[:len(rest)-len(last)]
+	if len(name) < len(last) || !strings.HasSuffix(name, last) {
+		return false
+	}
+	name = name[:len(name)-len(last)]
+	// The leftmost match of each literal part leaves the most room for the next
+	for rest != "" {
+		var part string
+		part, rest, _ = strings.Cut(rest, "*")
+		if part == "" {
+			continue
+		}
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+	return true
+}
+
+// WildcardEntry is a wildcard pattern of a WildcardSet, as in MatchWildcard.
+type WildcardEntry struct {
+	Pattern string
+	ID      int
+}
+
+// WildcardSet finds all the wildcard patterns that match a domain, in a single
+// pass over the domain. The patterns are compiled into one nondeterministic
+// automaton with a state per character of each pattern, and a state per run of
+// stars, which is simulated with one bit per state.
+type WildcardSet struct {
+	words int // length of the state bit sets, in uint64s
+
+	// class maps the bytes of the domain to the rows of literal; bytes that
+	// do not appear in any pattern are class 0.
+	class [256]uint8
+	// literal[c*words:(c+1)*words] has the states that move to the next one
+	// on a byte of class c.
+	literal []uint64
+	star    []uint64 // states that stay active on any byte, and match none
+	start   []uint64 // initial states, with the states after leading stars
+	final   []uint64 // the last state of each pattern
+
+	// finals are the final states in ascending order, and ids[i] the IDs of
+	// the patterns ending at finals[i], in ascending order.
+	finals []int
+	ids    [][]int
+}
+
+// NewWildcardSet compiles the given patterns. Several entries may have the
+// same pattern.
+func NewWildcardSet(entries []WildcardEntry) *WildcardSet {
+	byPattern := make(map[string][]int, len(entries))
+	var patterns []string
+	for _, e := range entries {
+		pattern := collapseStars(strings.ToLower(e.Pattern))
+		if _, ok := byPattern[pattern]; !ok {
+			patterns = append(patterns, pattern)
+		}
+		byPattern[pattern] = append(byPattern[pattern], e.ID)
+	}
+	slices.Sort(patterns)
+
+	states := 0
+	for _, p := range patterns {
+		states += len(p) + 1
+	}
+	s := &WildcardSet{words: (states + 63) / 64}
+	classes := 1
+	for _, p := range patterns {
+		for i := 0; i < len(p); i++ {
+			if p[i] != '*' && s.class[p[i]] == 0 {
+				s.class[p[i]] = uint8(classes) // #nosec G115 -- at most 255 distinct bytes
+				classes++
+			}
+		}
+	}
+	s.literal = make([]uint64, classes*s.words)
+	s.star = make([]uint64, s.words)
+	s.start = make([]uint64, s.words)
+	s.final = make([]uint64, s.words)
+
+	state := 0
+	for _, p := range patterns {
+		setState(s.start, state)
+		for i := 0; i < len(p); i++ {
+			if p[i] == '*' {
+				setState(s.star, state+i)
+			} else {
+				c := int(s.class[p[i]])
+				setState(s.literal[c*s.words:(c+1)*s.words], state+i)
+			}
+		}
+		state += len(p)
+		setState(s.final, state)
+		s.finals = append(s.finals, state)
+		ids := byPattern[p]
+		slices.Sort(ids)
+		s.ids = append(s.ids, slices.Compact(ids))
+		state++
+	}
+	s.closure(s.start)
+	return s
+}
+
+// AppendMatches appends the IDs of the patterns matching domain to dst, in
+// ascending order, and returns the extended slice.
+func (s *WildcardSet) AppendMatches(dst []int, domain string) []int {
+	if len(s.finals) == 0 {
+		return dst
+	}
+	var buf [8]uint64
+	var cur, next []uint64
+	if 2*s.words <= len(buf) {
+		cur, next = buf[:s.words], buf[s.words:2*s.words]
+	} else {
+		states := make([]uint64, 2*s.words)
+		cur, next = states[:s.words], states[s.words:]
+	}
+	copy(cur, s.start)
+	for i := 0; i < len(domain); i++ {
+		if !s.step(next, cur, domain[i]) {
+			return dst
+		}
+		cur, next = next, cur
+	}
+
+	start := len(dst)
+	for w, word := range cur {
+		word &= s.final[w]
+		for word != 0 {
+			state := w*64 + bits.TrailingZeros64(word)
+			word &= word - 1
+			i, _ := slices.BinarySearch(s.finals, state)
+			dst = append(dst, s.ids[i]...)
+		}
+	}
+	if len(dst)-start > 1 {
+		slices.Sort(dst[start:])
+		dst = append(dst[:start], slices.Compact(dst[start:])...)
+	}
+	return dst
+}
+
+// step computes in next the states after reading b in the states cur, and
+// reports whether any state is active. It adds the states after the active
+// stars in the same pass, like closure.
+func (s *WildcardSet) step(next, cur []uint64, b byte) bool {
+	c := int(s.class[b])
+	literal := s.literal[c*s.words : (c+1)*s.words]
+	star := s.star[:len(cur)]
+	next = next[:len(cur)]
+	var carry, starCarry, active uint64
+	for w, states := range cur {
+		moved := states & literal[w]
+		n := moved<<1 | carry | states&star[w]
+		stars := n & star[w]
+		n |= stars<<1 | starCarry
+		next[w] = n
+		carry, starCarry = moved>>63, stars>>63
+		active |= n
+	}
+	return active != 0
+}
+
+// closure adds to states the states after their stars, since a star may match
+// nothing, and reports whether any state is active. Runs of stars are
+// collapsed, so the state after a star is never a star.
+func (s *WildcardSet) closure(states []uint64) bool {
+	var carry, active uint64
+	for w := range states {
+		stars := states[w] & s.star[w]
+		states[w] |= stars<<1 | carry
+		carry = stars >> 63
+		active |= states[w]
+	}
+	return active != 0
+}
+
+// collapseStars replaces runs of stars in pattern with a single star, which
+// matches the same.
+func collapseStars(pattern string) string {
+	for strings.Contains(pattern, "**") {
+		pattern = strings.ReplaceAll(pattern, "**", "*")
+	}
+	return pattern
+}
+
+func setState(set []uint64, i int) {
+	set[i/64] |= 1 << (i % 64)
+}
@@ -0,0 +1,172 @@
+package domain
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// backtrackMatch is the straightforward (exponential) definition of a wildcard
+// match, to compare against.
+func backtrackMatch(pattern, name string) bool {
+	if pattern == "" {
+		return name == ""
+	}
+	if pattern[0] == '*' {
+		return backtrackMatch(pattern[1:], name) || (name != "" && backtrackMatch(pattern, name[1:]))
+	}
+	return name != "" && name[0] == pattern[0] && backtrackMatch(pattern[1:], name[1:])
+}
+
+func TestMatchWildcard(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.example.com", "www.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", ".example.com", true},
+		{"*example.com", "example.com", true},
+		{"www.*.com", "www.example.com", true},
+		{"www.*.com", "www.com", false},
+		{"api*.example.*", "api2.example.org", true},
+		{"api*.example.*", "www.example.org", false},
+		{"a*a", "a", false},
+		{"a*a", "aa", true},
+		{"*", "", true},
+		{"**", "x", true},
+		{"*.中国", "例子.中国", true},
+		{"例*.中国", "例子.中国", true},
+		{"例*.中国", "子.中国", false},
+		{"exact.com", "exact.com", true},
+		{"exact.com", "www.exact.com", false},
+	}
+	for _, tt := range tests {
+		if got := MatchWildcard(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("MatchWildcard(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchWildcard_Random(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	random := func(alphabet string, n int) string {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = alphabet[r.IntN(len(alphabet))]
+		}
+		return string(b)
+	}
+	for range 20000 {
+		pattern, name := random("ab.*", r.IntN(8)), random("ab.", r.IntN(10))
+		if got, want := MatchWildcard(pattern, name), backtrackMatch(pattern, name); got != want {
+			t.Fatalf("MatchWildcard(%q, %q) = %v, want %v", pattern, name, got, want)
+		}
+	}
+}
+
+func TestWildcardSet_AppendMatches(t *testing.T) {
+	set := NewWildcardSet([]WildcardEntry{
+		{"*.example.com", 0},
+		{"www.*.com", 1},
+		{"API*.example.*", 2}, // patterns are case-insensitive
+		{"*.example.com", 3},
+		{"a**b", 4},
+		{"*", 5},
+		{"*.中国", 6},
+	})
+	tests := []struct {
+		name string
+		want []int
+	}{
+		{"www.example.com", []int{0, 1, 3, 5}},
+		{"api2.example.org", []int{2, 5}},
+		{"api2.example.com", []int{0, 2, 3, 5}},
+		{"ab", []int{4, 5}},
+		{"axxxb", []int{4, 5}},
+		{"例子.中国", []int{5, 6}},
+		{"", []int{5}},
+	}
+	for _, tt := range tests {
+		if got := set.AppendMatches(nil, tt.name); !slices.Equal(got, tt.want) {
+			t.Errorf("AppendMatches(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if got := NewWildcardSet(nil).AppendMatches(nil, "example.com"); got != nil {
+		t.Errorf("empty set AppendMatches() = %v", got)
+	}
+}
+
+func TestWildcardSet_Random(t *testing.T) {
+	// Compare with matching every pattern, for sets spanning many words
+	r := rand.New(rand.NewPCG(3, 4))
+	random := func(alphabet string, n int) string {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = alphabet[r.IntN(len(alphabet))]
+		}
+		return string(b)
+	}
+	var entries []WildcardEntry
+	for i := range 300 {
+		entries = append(entries, WildcardEntry{random("abc.*", 1+r.IntN(10)), i})
+	}
+	set := NewWildcardSet(entries)
+	for range 2000 {
+		name := random("abc.", r.IntN(16))
+		var want []int
+		for _, e := range entries {
+			if MatchWildcard(e.Pattern, name) {
+				want = append(want, e.ID)
+			}
+		}
+		if got := set.AppendMatches(nil, name); !slices.Equal(got, want) {
+			t.Fatalf("AppendMatches(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestWildcard_Pathological(t *testing.T) {
+	// Exponential for a backtracking matcher
+	pattern := strings.Repeat("*a", 30) + "*b"
+	name := strings.Repeat("a", 4096)
+	if MatchWildcard(pattern, name) {
+		t.Error("MatchWildcard() = true")
+	}
+	if got := NewWildcardSet([]WildcardEntry{{pattern, 0}}).AppendMatches(nil, name); got != nil {
+		t.Errorf("AppendMatches() = %v", got)
+	}
+}
+
+func TestWildcardSet_AppendMatchesAllocs(t *testing.T) {
+	set := NewWildcardSet([]WildcardEntry{{"*.example.com", 0}, {"www.*", 1}})
+	dst := make([]int, 0, 4)
+	allocs := testing.AllocsPerRun(100, func() {
+		dst = set.AppendMatches(dst[:0], "www.example.com")
+	})
+	if allocs != 0 {
+		t.Errorf("AppendMatches() allocates %v times", allocs)
+	}
+}
+
+func BenchmarkWildcardSet_AppendMatches(b *testing.B) {
+	r := rand.New(rand.NewPCG(1, 2))
+	entries := make([]WildcardEntry, 1000)
+	for i := range entries {
+		label := make([]byte, 4+r.IntN(8))
+		for j := range label {
+			label[j] = 'a' + byte(r.IntN(26))
+		}
+		entries[i] = WildcardEntry{Pattern: "*" + string(label) + "*.com", ID: i}
+	}
+	set := NewWildcardSet(entries)
+	var dst []int
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dst = set.AppendMatches(dst[:0], "www.some-long-subdomain.example.com")
+	}
+}
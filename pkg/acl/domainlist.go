@@ -0,0 +1,282 @@
+package acl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+
+	"github.com/xflash-panda/acl-engine/pkg/acl/domain"
+)
+
+// DomainList is a list of domains to block (or otherwise route), as read by
+// ReadDomainList.
+type DomainList struct {
+	Domains  []string // exact domains
+	Suffixes []string // "example.com" and its subdomains, or only the subdomains for ".example.com"
+
+	// Exceptions are domain suffixes excluded from the list, from AdBlock
+	// exception rules ("@@||example.com^").
+	Exceptions []string
+
+	// Skipped is the number of AdBlock rules that were skipped because they do
+	// not apply to whole domains, such as element hiding rules, URL patterns
+	// and rules with modifiers.
+	Skipped int
+}
+
+// hostsIgnoredNames are the names of hosts files that are not blocked domains.
+var hostsIgnoredNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+}
+
+// ReadDomainList reads a list of domains in one of the common blocklist
+// formats, detected from its first rule:
+//
+//   - hosts files ("0.0.0.0 ads.example.com"), whose names are exact domains
+//     (the addresses are ignored, as are names such as "localhost"),
+//   - plain domain lists, one exact domain per line, or "*.example.com" for
+//     the subdomains of example.com,
+//   - AdBlock / AdGuard filter lists, whose "||example.com^" rules block
+//     example.com and its subdomains, and "@@||example.com^" rules are
+//     exceptions. Other rules are counted in DomainList.Skipped.
+//
+// Hosts and plain domain lines can be mixed, and '#' starts a comment in them.
+// In AdBlock lists, comments start with '!', and the list can start with a
+// header such as "[Adblock Plus 2.0]".
+//
+// Domains are converted to lower case, and internationalized domain names to
+// punycode ("xn--" labels), the form in which host names are matched. Invalid
+// lines are reported as InvalidSyntaxErrors, located with WithSource. By
+// default ReadDomainList stops at the first one; with WithAllErrors, it skips
+// them and returns the list together with an ErrorList.
+func ReadDomainList(r io.Reader, opts ...Option) (*DomainList, error) {
+	o := newOptions(opts)
+	list := &DomainList{}
+	var errs ErrorList
+	adblock, detected := false, false
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if !detected {
+			if line == "" || line[0] == '#' {
+				continue
+			}
+			adblock = isAdBlockLine(line)
+			detected = true
+		}
+		var err error
+		if adblock {
+			err = list.addAdBlockLine(line)
+		} else {
+			err = list.addHostsLine(line)
+		}
+		if err != nil {
+			err := &InvalidSyntaxError{Source: o.source, Line: line, LineNum: lineNum, Message: err.Error()}
+			if !o.allErrors {
+				return nil, err
+			}
+			errs = append(errs, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, errs.Err()
+}
+
+// isAdBlockLine reports whether the first rule of a list is in AdBlock syntax.
+func isAdBlockLine(line string) bool {
+	return line[0] == '!' || line[0] == '[' || line[0] == '|' || strings.HasPrefix(line, "@@")
+}
+
+// addHostsLine adds the domains of a hosts file or plain domain list line.
+func (l *DomainList) addHostsLine(line string) error {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(strings.ToLower(line))
+	switch {
+	case len(fields) == 0:
+		return nil
+	case net.ParseIP(fields[0]) != nil:
+		if len(fields) == 1 {
+			return fmt.Errorf("missing host name")
+		}
+		for _, name := range fields[1:] {
+			if hostsIgnoredNames[name] || net.ParseIP(name) != nil {
+				continue
+			}
+			name, err := listDomainName(name)
+			if err != nil {
+				return err
+			}
+			l.Domains = append(l.Domains, name)
+		}
+	case len(fields) == 1:
+		if sub, ok := strings.CutPrefix(fields[0], "*."); ok {
+			sub, err := listDomainName(sub)
+			if err != nil {
+				return err
+			}
+			l.Suffixes = append(l.Suffixes, "."+sub)
+			return nil
+		}
+		name, err := listDomainName(fields[0])
+		if err != nil {
+			return err
+		}
+		l.Domains = append(l.Domains, name)
+	default:
+		return fmt.Errorf("expected a domain, or an IP address followed by host names")
+	}
+	return nil
+}
+
+// addAdBlockLine adds the domain of an AdBlock rule that blocks (or excepts)
+// a whole domain, and counts other rules as skipped.
+func (l *DomainList) addAdBlockLine(line string) error {
+	if line == "" || line[0] == '!' || line[0] == '[' {
+		return nil
+	}
+	rule, exception := strings.CutPrefix(line, "@@")
+	name, ok := strings.CutPrefix(rule, "||")
+	if !ok {
+		l.Skipped++
+		return nil
+	}
+	name, modifiers, _ := strings.Cut(name, "$")
+	name, ok = strings.CutSuffix(strings.TrimSuffix(name, "|"), "^")
+	if !ok || (modifiers != "" && !strings.EqualFold(modifiers, "important")) ||
+		strings.ContainsAny(name, "*/^|?=&") {
+		// Not a whole domain ("||ads.example.com/banner"), or only for some requests
+		l.Skipped++
+		return nil
+	}
+	name, err := listDomainName(strings.ToLower(name))
+	if err != nil {
+		return err
+	}
+	if exception {
+		l.Exceptions = append(l.Exceptions, name)
+	} else {
+		l.Suffixes = append(l.Suffixes, name)
+	}
+	return nil
+}
+
+// checkDomainName checks a domain name, e.g. of a domain list. Besides
+// letters, digits, '-' and '.', it allows '_' (which appears in blocklists)
+// and non-ASCII characters.
+func checkDomainName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.Contains(name, "..") ||
+		strings.IndexFunc(name, func(r rune) bool {
+			return r < utf8.RuneSelf && !('a' <= r && r <= 'z' || '0' <= r && r <= '9' || r == '-' || r == '_' || r == '.')
+		}) >= 0 {
+		return fmt.Errorf("invalid domain %s", name)
+	}
+	return nil
+}
+
+// listDomainName checks a domain name of a domain list, and converts it to
+// ASCII (punycode), the form in which the list is matched against host names.
+func listDomainName(name string) (string, error) {
+	if err := checkDomainName(name); err != nil {
+		return "", err
+	}
+	if strings.IndexFunc(name, func(r rune) bool { return r >= utf8.RuneSelf }) < 0 {
+		return name, nil
+	}
+	ascii, err := idna.ToASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("invalid domain %s", name)
+	}
+	return ascii, nil
+}
+
+// domainListMatcher matches the domains of a DomainList, using succinct tries.
+type domainListMatcher struct {
+	Path       string
+	Matcher    *domain.Matcher
+	Exceptions *domain.Matcher // nil if there are none
+}
+
+func newDomainListMatcher(path string, list *DomainList) *domainListMatcher {
+	m := &domainListMatcher{Path: path, Matcher: domain.NewMatcher(list.Domains, list.Suffixes)}
+	if len(list.Exceptions) > 0 {
+		m.Exceptions = domain.NewMatcher(nil, list.Exceptions)
+	}
+	return m
+}
+
+func (m *domainListMatcher) Match(host Host) bool {
+	return host.Name != "" && m.Matcher.Match(host.Name) &&
+		(m.Exceptions == nil || !m.Exceptions.Match(host.Name))
+}
+
+// explain returns the entry of the list that matched host, as an address.
+func (m *domainListMatcher) explain(host Host) string {
+	rule, suffix, ok := m.Matcher.Lookup(host.Name)
+	switch {
+	case !ok:
+		return ""
+	case !suffix:
+		return rule
+	case strings.HasPrefix(rule, "."):
+		return "*" + rule
+	default:
+		return "suffix:" + rule
+	}
+}
+
+// compileDomainList compiles a "list:" address. A relative path is resolved
+// against the directory of the file containing the rule, like includes, or
+// else the base directory. Lists are read once per compilation, and rules
+// referring to the same list share its matcher.
+func (c *compiler) compileDomainList(path string) (hostMatcher, error) {
+	if path == "" {
+		return nil, addressError("", "empty list path")
+	}
+	dir := c.dir
+	if dir == "" {
+		dir = c.baseDir
+	}
+	if !filepath.IsAbs(path) && dir != "" {
+		path = filepath.Join(dir, path)
+	}
+	if m, ok := c.lists[path]; ok {
+		return m, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, addressError("", "cannot read domain list: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	list, err := ReadDomainList(f, WithSource(path))
+	if err != nil {
+		return nil, addressError("", "%v", err)
+	}
+	m := newDomainListMatcher(path, list)
+	if c.lists == nil {
+		c.lists = make(map[string]*domainListMatcher)
+	}
+	c.lists[path] = m
+	return m, nil
+}
@@ -0,0 +1,233 @@
+package acl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadDomainList(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want DomainList
+	}{
+		{
+			name: "hosts",
+			text: `# Blocklist
+127.0.0.1 localhost
+::1 localhost ip6-localhost
+0.0.0.0 0.0.0.0
+0.0.0.0 Ads.Example.com tracker.example.net # trackers
+  0.0.0.0	metrics.example.org
+`,
+			want: DomainList{Domains: []string{"ads.example.com", "tracker.example.net", "metrics.example.org"}},
+		},
+		{
+			name: "domains",
+			text: "ads.example.com\n*.tracker.example.net\n\n# comment\nmetrics.example.org # inline\n",
+			want: DomainList{
+				Domains:  []string{"ads.example.com", "metrics.example.org"},
+				Suffixes: []string{".tracker.example.net"},
+			},
+		},
+		{
+			name: "mixed hosts and domains",
+			text: "0.0.0.0 a.example.com\nb.example.com\n",
+			want: DomainList{Domains: []string{"a.example.com", "b.example.com"}},
+		},
+		{
+			name: "adblock",
+			text: `[Adblock Plus 2.0]
+! Title: Ads
+||ads.example.com^
+||Tracker.Example.net^$important
+||cdn.example.org^|
+@@||good.ads.example.com^
+||example.com/banner.js
+||example.org^$third-party
+##.ad-banner
+/banner/*
+`,
+			want: DomainList{
+				Suffixes:   []string{"ads.example.com", "tracker.example.net", "cdn.example.org"},
+				Exceptions: []string{"good.ads.example.com"},
+				Skipped:    4,
+			},
+		},
+		{
+			name: "internationalized domains",
+			text: "0.0.0.0 Bücher.example\n*.例え.jp\nxn--bcher-kva.example\n",
+			want: DomainList{
+				Domains:  []string{"xn--bcher-kva.example", "xn--bcher-kva.example"},
+				Suffixes: []string{".xn--r8jz45g.jp"},
+			},
+		},
+		{
+			name: "internationalized adblock domains",
+			text: "||bücher.example^\n@@||www.例え.jp^\n",
+			want: DomainList{Suffixes: []string{"xn--bcher-kva.example"}, Exceptions: []string{"www.xn--r8jz45g.jp"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := ReadDomainList(strings.NewReader(tt.text))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, *list)
+		})
+	}
+}
+
+func TestReadDomainList_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr string
+	}{
+		{"hosts without name", "0.0.0.0 a.com\n0.0.0.0\n", "invalid syntax at ads.txt line 2: missing host name: 0.0.0.0"},
+		{"too many fields", "a.com b.com\n", "expected a domain"},
+		{"invalid domain", "a..com\n", "invalid domain a..com"},
+		{"invalid host name", "0.0.0.0 geoip:cn\n", "invalid domain geoip:cn"},
+		{"adblock after hosts", "a.com\n||b.com^\n", "invalid domain ||b.com^"},
+		{"invalid adblock domain", "||a.com^\n||(b).com^\n", "invalid domain (b).com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadDomainList(strings.NewReader(tt.text), WithSource("ads.txt"))
+			var se *InvalidSyntaxError
+			require.ErrorAs(t, err, &se)
+			assert.Contains(t, se.Error(), tt.wantErr)
+		})
+	}
+
+	list, err := ReadDomainList(strings.NewReader("a.com\nb c\nd.com\na..b\n"), WithAllErrors())
+	var errs ErrorList
+	require.ErrorAs(t, err, &errs)
+	assert.Len(t, errs, 2)
+	assert.Equal(t, []string{"a.com", "d.com"}, list.Domains)
+}
+
+func TestCompile_DomainList(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Ads.txt"), []byte(`! AdBlock list
+||ads.example.com^
+@@||ok.ads.example.com^
+`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hosts"), []byte("0.0.0.0 tracker.example.net\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.txt"), []byte("a.com\na b c\n"), 0o644))
+
+	outbounds := map[string]string{"direct": "DIRECT", "reject": "REJECT"}
+	rules, err := ParseTextRules(`
+reject(list:Ads.txt)
+reject(list:` + filepath.Join(dir, "hosts") + `, tcp/443)
+direct(!list:Ads.txt)
+`)
+	require.NoError(t, err)
+	rs, err := Compile(rules, outbounds, 100, &NilGeoLoader{}, WithBaseDir(dir))
+	require.NoError(t, err)
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"ads.example.com", "REJECT"},
+		{"x.ads.example.com", "REJECT"},
+		{"ok.ads.example.com", "DIRECT"},
+		{"tracker.example.net", "REJECT"},
+		{"sub.tracker.example.net", "DIRECT"},
+		{"example.com", "DIRECT"},
+	}
+	for _, tt := range tests {
+		got, _ := rs.Match(HostInfo{Name: tt.name}, ProtocolTCP, 443)
+		assert.Equal(t, tt.want, got, tt.name)
+	}
+	d := rs.Explain(HostInfo{Name: "x.ads.example.com"}, ProtocolTCP, 443)
+	assert.Equal(t, "suffix:ads.example.com", d.Hit)
+
+	// Rules share the matcher of a list
+	crs := rs.(*compiledRuleSetImpl[string])
+	assert.Same(t, crs.Rules[0].HostMatcher, crs.Rules[2].HostMatcher.(*inverseMatcher).Matcher)
+
+	for rule, wantErr := range map[string]string{
+		"reject(list:missing.txt)": "cannot read domain list",
+		"reject(list:bad.txt)":     "line 2: expected a domain",
+		"reject(list:)":            "empty list path",
+	} {
+		rules, err := ParseTextRules(rule)
+		require.NoError(t, err)
+		_, err = Compile(rules, outbounds, 100, &NilGeoLoader{}, WithBaseDir(dir))
+		assert.ErrorContains(t, err, wantErr, rule)
+	}
+}
+
+func TestCompile_DomainListInclude(t *testing.T) {
+	// Relative list paths are resolved against the directory of the file
+	// containing the rule or set, like includes
+	dir := writeFiles(t, map[string]string{
+		"main.acl":            "include(sub/ads.acl)\nreject(list:top.txt)\ndirect(@ok)\nproxy(all)\n",
+		"top.txt":             "top.example.com\n",
+		"ads.txt":             "wrong.example.com\n",
+		"sub/ads.acl":         "@ok = list:ok.txt\nreject(list:ads.txt)\ninclude(deeper/more.acl)\n",
+		"sub/ads.txt":         "ads.example.com\n",
+		"sub/ok.txt":          "ok.example.com\n",
+		"sub/deeper/more.acl": "reject(list:more.txt, tcp/443)\n",
+		"sub/deeper/more.txt": "more.example.com\n",
+	})
+
+	rules, err := ParseFile(filepath.Join(dir, "main.acl"))
+	require.NoError(t, err)
+	outbounds := map[string]string{"direct": "DIRECT", "reject": "REJECT", "proxy": "PROXY"}
+	rs, err := Compile(rules, outbounds, 100, &NilGeoLoader{}, WithBaseDir(t.TempDir()))
+	require.NoError(t, err)
+	for name, want := range map[string]string{
+		"ads.example.com":   "REJECT",
+		"wrong.example.com": "PROXY",
+		"more.example.com":  "REJECT",
+		"top.example.com":   "REJECT",
+		"ok.example.com":    "DIRECT",
+	} {
+		got, _ := rs.Match(HostInfo{Name: name}, ProtocolTCP, 443)
+		assert.Equal(t, want, got, name)
+	}
+}
+
+func TestCompile_DomainListIDN(t *testing.T) {
+	// Internationalized entries match the punycode host names of connections
+	dir := writeFiles(t, map[string]string{"idn.txt": "bücher.example\n*.例え.jp\n"})
+	rules, err := ParseTextRules("reject(list:idn.txt)\ndirect(all)")
+	require.NoError(t, err)
+	rs, err := Compile(rules, map[string]string{"direct": "DIRECT", "reject": "REJECT"}, 100, &NilGeoLoader{}, WithBaseDir(dir))
+	require.NoError(t, err)
+	for name, want := range map[string]string{
+		"xn--bcher-kva.example":     "REJECT",
+		"XN--BCHER-KVA.example":     "REJECT",
+		"www.xn--r8jz45g.jp":        "REJECT",
+		"xn--r8jz45g.jp":            "DIRECT",
+		"www.xn--bcher-kva.example": "DIRECT",
+	} {
+		got, _ := rs.Match(HostInfo{Name: name}, ProtocolTCP, 443)
+		assert.Equal(t, want, got, name)
+	}
+}
+
+func BenchmarkDomainList_Match(b *testing.B) {
+	var sb strings.Builder
+	for i := range 500000 {
+		sb.WriteString("0.0.0.0 host")
+		sb.WriteString(strings.Repeat("x", i%7))
+		sb.WriteString(".example")
+		sb.WriteString(string(rune('a' + i%26)))
+		sb.WriteString(".com\n")
+	}
+	list, err := ReadDomainList(strings.NewReader(sb.String()))
+	require.NoError(b, err)
+	m := newDomainListMatcher("bench", list)
+	host := Host{Name: "www.not-listed.example.com"}
+	b.ResetTimer()
+	for b.Loop() {
+		m.Match(host)
+	}
+}
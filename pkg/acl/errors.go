@@ -0,0 +1,142 @@
+package acl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrorList is a list of *InvalidSyntaxError, *IncludeError and *CompilationError
+// values. It is returned by ParseTextRules, ParseFile and Compile when WithAllErrors is used,
+// so that every problem in a rule file can be reported at once.
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d errors:", len(l))
+	for _, err := range l {
+		sb.WriteString("\n\t")
+		sb.WriteString(err.Error())
+	}
+	return sb.String()
+}
+
+// Unwrap returns the errors in the list, for use with errors.Is and errors.As.
+func (l ErrorList) Unwrap() []error {
+	return l
+}
+
+// Err returns nil if the list is empty, or the list itself otherwise.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// Sort sorts the list by line and column. Errors from different sources
+// are grouped by source, in order of first appearance.
+func (l ErrorList) Sort() {
+	order := make(map[string]int)
+	for _, err := range l {
+		source, _, _ := errorLocation(err)
+		if _, ok := order[source]; !ok {
+			order[source] = len(order)
+		}
+	}
+	sort.SliceStable(l, func(i, j int) bool {
+		si, li, ci := errorLocation(l[i])
+		sj, lj, cj := errorLocation(l[j])
+		if si != sj {
+			return order[si] < order[sj]
+		}
+		if li != lj {
+			return li < lj
+		}
+		return ci < cj
+	})
+}
+
+func errorLocation(err error) (source string, line, column int) {
+	switch e := err.(type) {
+	case *InvalidSyntaxError:
+		return e.Source, e.LineNum, e.Column
+	case *IncludeError:
+		return e.Source, e.LineNum, e.Column
+	case *CompilationError:
+		return e.Source, e.LineNum, e.Column
+	default:
+		return "", 0, 0
+	}
+}
+
+// formatLocation formats a location for error messages, e.g. "lan.acl line 3, column 7".
+func formatLocation(source string, line, column int) string {
+	loc := fmt.Sprintf("line %d", line)
+	if column > 0 {
+		loc += fmt.Sprintf(", column %d", column)
+	}
+	if source != "" {
+		loc = source + " " + loc
+	}
+	return loc
+}
+
+// suggest returns the candidate closest to name, for "did you mean" hints.
+// It returns an empty string if no candidate is close enough.
+func suggest(name string, candidates []string) string {
+	sorted := make([]string, len(candidates))
+	copy(sorted, candidates)
+	sort.Strings(sorted) // deterministic choice between equally close candidates
+
+	maxDist := len(name) / 3
+	if maxDist < 1 {
+		maxDist = 1
+	}
+	best, bestDist := "", maxDist+1
+	for _, c := range sorted {
+		if c == name {
+			continue
+		}
+		if d := editDistance(name, c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func mapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,59 @@
+package acl
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_suggest(t *testing.T) {
+	candidates := []string{"direct", "reject", "proxy", "default", "my-socks5"}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"prxy", "proxy"},
+		{"dirct", "direct"},
+		{"rejcet", "reject"},
+		{"my_socks5", "my-socks5"},
+		{"proxy", ""},
+		{"something", ""},
+		{"x", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, suggest(tt.name, candidates))
+		})
+	}
+}
+
+func Test_editDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("", ""))
+	assert.Equal(t, 3, editDistance("abc", ""))
+	assert.Equal(t, 1, editDistance("netflix", "netflx"))
+	assert.Equal(t, 2, editDistance("ab", "ba"))
+	assert.Equal(t, 3, editDistance("kitten", "sitting"))
+}
+
+func TestErrorList(t *testing.T) {
+	errs := ErrorList{
+		&CompilationError{Source: "b.acl", LineNum: 2, Message: "b2"},
+		&CompilationError{Source: "a.acl", LineNum: 9, Column: 1, Message: "a9"},
+		&InvalidSyntaxError{Source: "b.acl", LineNum: 1, Column: 3, Message: "b1", Line: "x"},
+		&CompilationError{Source: "a.acl", LineNum: 3, Column: 7, Message: "a3", Suggestion: "proxy"},
+	}
+	errs.Sort()
+	assert.Equal(t, "4 errors:\n"+
+		"\tinvalid syntax at b.acl line 1, column 3: b1: x\n"+
+		"\terror at b.acl line 2: b2\n"+
+		"\terror at a.acl line 3, column 7: a3 (did you mean \"proxy\"?)\n"+
+		"\terror at a.acl line 9, column 1: a9", errs.Error())
+
+	var syntaxErr *InvalidSyntaxError
+	assert.True(t, errors.As(error(errs), &syntaxErr))
+	assert.Equal(t, "b1", syntaxErr.Message)
+
+	assert.NoError(t, ErrorList(nil).Err())
+	assert.Equal(t, errs[0], ErrorList{errs[0]}.Err().(ErrorList)[0])
+}
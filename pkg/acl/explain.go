@@ -0,0 +1,166 @@
+package acl
+
+import (
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// MatchDetail is the result of CompiledRuleSet.Explain.
+type MatchDetail[O Outbound] struct {
+	Outbound      O
+	HijackAddress *HijackAddress
+
+	// RuleIndex is the index of the matching rule among the compiled rules
+	// (address set definitions do not count), or -1 if no rule matched.
+	RuleIndex int
+	// Rule is the matching rule. Rule.Source and Rule.LineNum locate it.
+	Rule TextRule
+	// Hit is the part of the address of the rule that matched, e.g. the
+	// GeoSite entry ("domain:google.com") or the GeoIP CIDR ("8.8.8.0/24").
+	Hit string
+	// Cached reports whether the result came from the cache.
+	Cached bool
+	// Trace lists the rules evaluated, in order, up to and including the
+	// matching rule. It is only set with WithTrace.
+	Trace []RuleEvaluation
+}
+
+// Matched reports whether a rule matched.
+func (d *MatchDetail[O]) Matched() bool {
+	return d.RuleIndex >= 0
+}
+
+// RuleEvaluation is the result of evaluating a single rule.
+type RuleEvaluation struct {
+	Index   int
+	Rule    TextRule
+	Matched bool
+	// Reason is why the rule did not match: "schedule inactive", "protocol/port"
+	// or "address". Empty if it matched.
+	Reason string
+}
+
+func (s *compiledRuleSetImpl[O]) Explain(hostInfo HostInfo, proto Protocol, port uint16, opts ...Option) MatchDetail[O] {
+	o := newOptions(opts)
+	host := hostInfo.Host()
+	result, now, cached := s.match(&host, proto, port)
+	d := MatchDetail[O]{
+		Outbound:      result.Outbound,
+		HijackAddress: result.HijackAddress,
+		RuleIndex:     result.Rule,
+		Cached:        cached,
+	}
+	last := len(s.Rules) - 1
+	if result.Rule >= 0 {
+		rule := &s.Rules[result.Rule]
+		d.Rule = rule.Text
+		d.Hit = explainHost(rule.HostMatcher, host)
+		last = result.Rule
+	}
+	if o.trace {
+		for i := 0; i <= last; i++ {
+			d.Trace = append(d.Trace, s.Rules[i].evaluate(i, host, proto, port, now))
+		}
+	}
+	return d
+}
+
+func (r *compiledRule[O]) evaluate(i int, host Host, proto Protocol, port uint16, now time.Time) RuleEvaluation {
+	e := RuleEvaluation{Index: i, Rule: r.Text}
+	switch {
+	case !r.Active(now):
+		e.Reason = "schedule inactive"
+	case !r.ProtoPort.Match(proto, port):
+		e.Reason = "protocol/port"
+	case !r.HostMatcher.Match(host):
+		e.Reason = "address"
+	default:
+		e.Matched = true
+	}
+	return e
+}
+
+// explainHost returns the part of m that matched host, e.g. the CIDR of a GeoIP
+// list that contains the IP of the host. m must match host.
+func explainHost(m hostMatcher, host Host) string {
+	switch m := m.(type) {
+	case *allMatcher:
+		return "all"
+	case *ipMatcher:
+		return m.IP.String()
+	case *cidrMatcher:
+		return m.Prefix.String()
+	case *domainMatcher:
+		if m.Mode == domainMatchSuffix {
+			return "suffix:" + m.Pattern
+		}
+		return m.Pattern
+	case *srcMatcher:
+		return "src:" + m.Prefix.String()
+	case *inboundMatcher:
+		return "inbound:" + m.Tag
+	case *userMatcher:
+		return "user:" + m.User
+	case *geoipMatcher:
+		if m.Inverse {
+			return describeHost(m)
+		}
+		for _, ip := range []netip.Addr{host.IPv4, host.IPv6} {
+			if p, ok := m.lookupIP(ip); ok {
+				return p.String()
+			}
+		}
+	case *geositeMatcher:
+		return m.explain(host)
+	case *domainListMatcher:
+		return m.explain(host)
+	case *inverseMatcher:
+		return "!" + describeHost(m.Matcher)
+	case *andMatcher:
+		hits := make([]string, len(m.Matchers))
+		for i, sub := range m.Matchers {
+			hits[i] = explainHost(sub, host)
+		}
+		return strings.Join(hits, " and ")
+	case *orMatcher:
+		for _, sub := range m.Matchers {
+			if sub.Match(host) {
+				return explainHost(sub, host)
+			}
+		}
+	}
+	return ""
+}
+
+// describeHost returns a short description of what m matches, for matchers
+// that did not match (as inside "!").
+func describeHost(m hostMatcher) string {
+	switch m := m.(type) {
+	case *geoipMatcher:
+		if m.Inverse {
+			return "!GeoIP list"
+		}
+		return "GeoIP list"
+	case *geositeMatcher:
+		return "GeoSite list"
+	case *domainListMatcher:
+		return "list:" + m.Path
+	case *inverseMatcher:
+		return "!" + describeHost(m.Matcher)
+	case *andMatcher:
+		return describeHosts("and", m.Matchers)
+	case *orMatcher:
+		return describeHosts("or", m.Matchers)
+	default:
+		return explainHost(m, Host{})
+	}
+}
+
+func describeHosts(op string, ms []hostMatcher) string {
+	descs := make([]string, len(ms))
+	for i, m := range ms {
+		descs[i] = describeHost(m)
+	}
+	return op + "(" + strings.Join(descs, ", ") + ")"
+}
@@ -0,0 +1,75 @@
+package acl
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompiledRuleSet_Explain(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT", "proxy": "PROXY", "reject": "REJECT"}
+	rules, err := ParseTextRules(`@lan = 10.0.0.0/8, 192.168.0.0/16
+reject(all, udp/443)
+direct(@lan)
+proxy(or(geosite:netflix, suffix:hulu.com))
+proxy(and(keyword:google, geoip:us))
+direct(!geoip:cn, tcp/22)
+direct(all, udp/53, 127.0.0.1)
+`, WithSource("test.acl"))
+	require.NoError(t, err)
+	rs, err := Compile[string](rules, outbounds, 16, newTestGeoLoader())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		host      HostInfo
+		proto     Protocol
+		port      uint16
+		want      string
+		wantIndex int
+		wantLine  int
+		wantHit   string
+	}{
+		{"all", HostInfo{Name: "example.com"}, ProtocolUDP, 443, "REJECT", 0, 2, "all"},
+		{"address set", HostInfo{IPv4: net.ParseIP("192.168.1.1")}, ProtocolTCP, 80, "DIRECT", 1, 3, "192.168.0.0/16"},
+		{"geosite", HostInfo{Name: "www.Netflix.com"}, ProtocolTCP, 443, "PROXY", 2, 4, "domain:netflix.com"},
+		{"or", HostInfo{Name: "hulu.com"}, ProtocolTCP, 443, "PROXY", 2, 4, "suffix:hulu.com"},
+		{"and", HostInfo{Name: "google.com", IPv4: net.ParseIP("8.8.8.8")}, ProtocolTCP, 443, "PROXY", 3, 5, "keyword:google and 8.8.8.0/24"},
+		{"inverse", HostInfo{IPv4: net.ParseIP("1.1.1.1")}, ProtocolTCP, 22, "DIRECT", 4, 6, "!GeoIP list"},
+		{"no match", HostInfo{Name: "example.com"}, ProtocolTCP, 443, "", -1, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := rs.Explain(tt.host, tt.proto, tt.port)
+			assert.Equal(t, tt.want, d.Outbound)
+			assert.Equal(t, tt.wantIndex, d.RuleIndex)
+			assert.Equal(t, tt.wantIndex >= 0, d.Matched())
+			assert.Equal(t, tt.wantLine, d.Rule.LineNum)
+			assert.Equal(t, tt.wantHit, d.Hit)
+			assert.False(t, d.Cached)
+			assert.Nil(t, d.Trace)
+
+			// The second time the result comes from the cache, attributed to the same rule
+			d = rs.Explain(tt.host, tt.proto, tt.port)
+			assert.True(t, d.Cached)
+			assert.Equal(t, tt.wantIndex, d.RuleIndex)
+			assert.Equal(t, tt.wantHit, d.Hit)
+		})
+	}
+
+	d := rs.Explain(HostInfo{Name: "example.com"}, ProtocolUDP, 53, WithTrace())
+	assert.Equal(t, "DIRECT", d.Outbound)
+	assert.Equal(t, &HijackAddress{IP: net.ParseIP("127.0.0.1")}, d.HijackAddress)
+	assert.Equal(t, "test.acl", d.Rule.Source)
+	want := []RuleEvaluation{
+		{0, rules[1], false, "protocol/port"},
+		{1, rules[2], false, "address"},
+		{2, rules[3], false, "address"},
+		{3, rules[4], false, "address"},
+		{4, rules[5], false, "protocol/port"},
+		{5, rules[6], true, ""},
+	}
+	assert.Equal(t, want, d.Trace)
+}
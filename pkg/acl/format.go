@@ -0,0 +1,245 @@
+package acl
+
+import (
+	"strings"
+)
+
+// caseSensitivePrefixes are the address prefixes whose values keep their case.
+var caseSensitivePrefixes = []string{"regexp:", "user:", "list:"}
+
+// Format writes rules as canonical ACL text, one rule per line:
+//
+//   - outbound names, operators, domains and protocols are lower case
+//     (except for case-sensitive values such as "regexp:", "user:" and "list:"),
+//   - arguments are separated by ", ", and only quoted where needed,
+//   - protocol/port lists are normalized (sorted, merged, "*" for all),
+//     and omitted if they match everything,
+//   - comments and empty lines recorded with WithComments are kept, with
+//     runs of empty lines reduced to one.
+//
+// Parsing the output gives back the same rules (apart from their positions),
+// if they were already canonical. Otherwise the parsed rules differ only in
+// ways that do not change their meaning, and formatting them again gives the
+// same text.
+func Format(rules []TextRule) string {
+	var b strings.Builder
+	blank := false // an empty line is pending
+	for _, r := range rules {
+		for _, c := range r.Comments {
+			if c == "" {
+				blank = b.Len() > 0
+				continue
+			}
+			writeLine(&b, c, &blank)
+		}
+		if r.isComment() {
+			continue
+		}
+		line := formatRule(&r)
+		if r.Comment != "" {
+			line += "  " + r.Comment
+		}
+		writeLine(&b, line, &blank)
+	}
+	return b.String()
+}
+
+func writeLine(b *strings.Builder, line string, blank *bool) {
+	if *blank {
+		b.WriteByte('\n')
+		*blank = false
+	}
+	b.WriteString(line)
+	b.WriteByte('\n')
+}
+
+// FormatText reformats ACL text with Format, keeping its comments and include
+// directives (see WithComments).
+func FormatText(text string, opts ...Option) (string, error) {
+	rules, err := ParseTextRules(text, append(opts, WithComments())...)
+	if err != nil {
+		return "", err
+	}
+	return Format(rules), nil
+}
+
+// formatRule returns the canonical form of a single rule, without comments.
+func formatRule(r *TextRule) string {
+	if r.SetName != "" {
+		return "@" + strings.ToLower(r.SetName) + " = " + formatList(r.Address)
+	}
+	if r.isInclude() {
+		return includeDirective + "(" + quoteArg(r.Address) + ")"
+	}
+	args := []string{quoteArg(formatAddress(r.Address))}
+	protoPort := formatProtoPort(r.ProtoPort)
+	hijack := strings.ToLower(strings.TrimSpace(r.HijackAddress))
+	if hijack != "" && protoPort == "" {
+		protoPort = "*"
+	}
+	if protoPort != "" {
+		args = append(args, quoteArg(protoPort))
+	}
+	if hijack != "" {
+		args = append(args, quoteArg(hijack))
+	}
+	if r.Schedule != "" {
+		args = append(args, "schedule="+quote(formatSchedule(r.Schedule)))
+	}
+	return strings.ToLower(r.Outbound) + "(" + strings.Join(args, ", ") + ")"
+}
+
+// formatList formats the address list of an address set definition.
+func formatList(list string) string {
+	args, err := newScanner(list).scanList()
+	if err != nil {
+		return list
+	}
+	items := make([]string, len(args))
+	for i, arg := range args {
+		items[i] = quoteArg(formatAddress(arg.Value))
+	}
+	return strings.Join(items, ", ")
+}
+
+// formatAddress returns the canonical form of an (unquoted) address.
+func formatAddress(addr string) string {
+	addr = strings.TrimSpace(addr)
+	lower := strings.ToLower(addr)
+	if op, argsOffset, ok := cutOperator(lower); ok {
+		s := newScanner(addr[argsOffset:])
+		args, err := s.scanArgs()
+		s.skipSpace()
+		if err != nil || !s.eof() {
+			return addr // invalid, leave it to the compiler to report
+		}
+		items := make([]string, len(args))
+		for i, arg := range args {
+			items[i] = quoteArg(formatAddress(arg.Value))
+		}
+		return op + "(" + strings.Join(items, ", ") + ")"
+	}
+	if rest, found := strings.CutPrefix(addr, "!"); found {
+		return "!" + formatAddress(rest)
+	}
+	for _, prefix := range caseSensitivePrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			return prefix + addr[len(prefix):]
+		}
+	}
+	return lower
+}
+
+// formatProtoPort returns the canonical form of a protocol/port list, or ""
+// if it matches all protocols and ports.
+func formatProtoPort(protoPort string) string {
+	f, err := parseProtoPort(protoPort)
+	if err != nil {
+		return strings.TrimSpace(protoPort) // invalid, leave it to the compiler to report
+	}
+	s := f.String()
+	if s == "*" {
+		return ""
+	}
+	return s
+}
+
+// formatSchedule normalizes the spacing and case of a schedule. Time zone
+// names keep their case.
+func formatSchedule(schedule string) string {
+	fields := strings.Fields(schedule)
+	for i, f := range fields {
+		if !strings.Contains(f, "/") {
+			fields[i] = strings.ToLower(f)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// quoteArg quotes an argument value if it cannot be written as-is.
+func quoteArg(value string) string {
+	if needsQuote(value) {
+		return quote(value)
+	}
+	return value
+}
+
+// quote returns value as a double-quoted string.
+func quote(value string) string {
+	keep := keepsEscapes(value)
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if keep && c == '\\' && i+1 < len(value) {
+			// Kept as written by the scanner (a '"' on its own is escaped
+			// below, and read back as `\"`, which a regexp reads as '"')
+			b.WriteString(value[i : i+2])
+			i++
+			continue
+		}
+		if c == '"' || c == '\\' && (i+1 == len(value) || isEscapable(value[i+1])) {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// needsQuote reports whether the scanner would read value differently
+// if it were written unquoted.
+func needsQuote(value string) bool {
+	if value == "" || value != strings.TrimSpace(value) {
+		return true
+	}
+	keep := keepsEscapes(value)
+	depth := 0
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '#', '\n':
+			return true
+		case '"':
+			// Copied as-is inside a composite, to be read when it is parsed
+			end := quotedEnd(value, i)
+			if depth == 0 || end < 0 {
+				return true
+			}
+			i = end
+		case ',':
+			if depth == 0 {
+				return true
+			}
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth < 0 {
+				return true
+			}
+		case '\\':
+			if i+1 == len(value) || depth == 0 && !keep && isEscapable(value[i+1]) {
+				return true
+			}
+			i++ // the next byte is taken as-is
+		}
+	}
+	return depth != 0
+}
+
+// quotedEnd returns the index of the quote closing the quoted string starting
+// at value[start], or -1 if it is not terminated on the same line.
+func quotedEnd(value string, start int) int {
+	for i := start + 1; i < len(value); i++ {
+		switch value[i] {
+		case '"':
+			return i
+		case '\\':
+			if i++; i < len(value) && value[i] == '\n' {
+				return -1
+			}
+		case '\n':
+			return -1
+		}
+	}
+	return -1
+}
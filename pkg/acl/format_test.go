@@ -0,0 +1,173 @@
+package acl
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clearPositions clears the positions of rules, which Format does not keep.
+func clearPositions(rules []TextRule) []TextRule {
+	for i := range rules {
+		r := &rules[i]
+		r.LineNum, r.Column = 0, 0
+		r.AddressPos, r.ProtoPortPos, r.HijackAddressPos, r.SchedulePos = Position{}, Position{}, Position{}, Position{}
+	}
+	return rules
+}
+
+func TestFormatText(t *testing.T) {
+	text := `
+
+# Private networks
+@LAN = 10.0.0.0/8 ,192.168.0.0/16   # RFC 1918
+Direct( @lan )
+
+
+# Streaming
+PROXY(AND( geosite:Netflix ,!GeoIP:CN ), TCP/443)
+proxy(regexp:^API\.Example\.(com|net)$)
+proxy(user:Alice, "udp/53, 443 tcp/443,53")
+reject("keyword:a,b", */*)
+reject(all, !TCP/22)
+direct(all, udp/53, 127.0.0.1)
+reject(geosite:category-games, schedule = "Mon-Fri  09:00-17:00 Europe/Berlin")
+include("rules.d/*.acl")  # more
+direct(all)
+# the end
+`
+	want := `# Private networks
+@lan = 10.0.0.0/8, 192.168.0.0/16  # RFC 1918
+direct(@lan)
+
+# Streaming
+proxy(and(geosite:netflix, !geoip:cn), tcp/443)
+proxy(regexp:^API\.Example\.(com|net)$)
+proxy(user:Alice, "*/53,443")
+reject("keyword:a,b")
+reject(all, !tcp/22)
+direct(all, udp/53, 127.0.0.1)
+reject(geosite:category-games, schedule="mon-fri 09:00-17:00 Europe/Berlin")
+include(rules.d/*.acl)  # more
+direct(all)
+# the end
+`
+	got, err := FormatText(text)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	// Formatting is idempotent
+	again, err := FormatText(got)
+	require.NoError(t, err)
+	assert.Equal(t, got, again)
+}
+
+func TestFormat_RoundTrip(t *testing.T) {
+	rules := []TextRule{
+		{Outbound: "direct", Address: "10.0.0.0/8", Comments: []string{"# LAN"}},
+		{Outbound: "proxy", Address: "or(suffix:a.com, \"keyword:x,y\")", ProtoPort: "tcp/80,443"},
+		{Outbound: "proxy", Address: `regexp:^a\.b(c|d)$`},
+		{Outbound: "proxy", Address: `regexp:\(x`},
+		{Outbound: "proxy", Address: `regexp:^a\(b\)\,c\\$`},
+		{Outbound: "proxy", Address: `!regexp:^a{1,3}#\"$`},
+		{Outbound: "proxy", Address: "keyword:#1", Comment: "# hash"},
+		{Outbound: "direct", Address: "all", ProtoPort: "*", HijackAddress: "127.0.0.1", Schedule: "weekends", Comments: []string{""}},
+		{SetName: "lan", Address: "10.0.0.0/8, \"a,b\""},
+		{Comments: []string{"# trailing"}},
+	}
+	text := Format(rules)
+	got, err := ParseTextRules(text, WithComments())
+	require.NoError(t, err, text)
+	assert.Equal(t, rules, clearPositions(got), text)
+	assert.Equal(t, text, Format(got))
+}
+
+func TestFormat_Compiles(t *testing.T) {
+	// Formatted rules must compile to the same matches
+	text := "direct(AND(Suffix:Example.com, !10.0.0.0/8), \"TCP/80, 443\")\n"
+	rules, err := ParseTextRules(text)
+	require.NoError(t, err)
+	formatted, err := ParseTextRules(Format(rules))
+	require.NoError(t, err)
+	assert.Equal(t, "direct(and(suffix:example.com, !10.0.0.0/8), \"tcp/80,443\")\n", Format(rules))
+	for _, rs := range [][]TextRule{rules, formatted} {
+		compiled, err := Compile[string](rs, map[string]string{"direct": "DIRECT"}, 16, &NilGeoLoader{})
+		require.NoError(t, err)
+		got, _ := compiled.Match(HostInfo{Name: "www.example.com"}, ProtocolTCP, 443)
+		assert.Equal(t, "DIRECT", got)
+	}
+}
+
+func TestFormat_RoundTripMatches(t *testing.T) {
+	// Non-canonical rules must match the same once formatted and parsed again
+	text := `
+@lan = 10.0.0.0/8, "regexp:^lan\d{1,2}$"
+r1(  Suffix:Example.COM ,  "TCP/80, 443" )
+r2(or(b.com, "regexp:^a{1,3}\.com$"), tcp/25,465,587)
+r3("regexp:^(x|y)\.net$", udp/53,853 5353)
+r4(regexp:^c\(d\)\.org$)
+r5(AND(keyword:shop, !suffix:shop.example.com), "*/8000-8010")
+r6(!OR(10.0.0.0/8, "regexp:^a,b\.com$"), udp)
+r7(or("keyword:a,b", Full:X.io, and(suffix:io, "regexp:^(v|w)\d+\.")))
+r8(@lan, TCP/22, 127.0.0.1:2222)
+r9(all, "tcp/1-1023")
+`
+	rules, err := ParseTextRules(text)
+	require.NoError(t, err)
+	formatted, err := ParseTextRules(Format(rules))
+	require.NoError(t, err, Format(rules))
+	assert.Equal(t, Format(rules), Format(formatted))
+
+	outbounds := make(map[string]string)
+	for i := 1; i <= 9; i++ {
+		outbounds[fmt.Sprintf("r%d", i)] = fmt.Sprintf("R%d", i)
+	}
+	compile := func(rules []TextRule) CompiledRuleSet[string] {
+		rs, err := Compile[string](rules, outbounds, 0, &NilGeoLoader{})
+		require.NoError(t, err)
+		return rs
+	}
+	want, got := compile(rules), compile(formatted)
+	hosts := []HostInfo{
+		{Name: "example.com"}, {Name: "www.example.com"}, {Name: "b.com"}, {Name: "aa.com"},
+		{Name: "aaaa.com"}, {Name: "y.net"}, {Name: "xy.net"}, {Name: "c(d).org"}, {Name: "cd.org"},
+		{Name: "shop.io"}, {Name: "shop.example.com"}, {Name: "a,b.com"}, {Name: "xa,b.org"},
+		{Name: "x.io"}, {Name: "v1.io"}, {Name: "lan7"}, {Name: "lan123"},
+		{IPv4: net.ParseIP("10.1.1.1")}, {IPv4: net.ParseIP("8.8.8.8")},
+	}
+	for _, host := range hosts {
+		for _, proto := range []Protocol{ProtocolTCP, ProtocolUDP} {
+			for _, port := range []uint16{22, 25, 53, 80, 443, 587, 853, 5353, 8005, 9000} {
+				wantOutbound, wantHijack := want.MatchAddress(host, proto, port)
+				gotOutbound, gotHijack := got.MatchAddress(host, proto, port)
+				assert.Equal(t, wantOutbound, gotOutbound, "%s %s/%d", host, proto, port)
+				assert.True(t, wantHijack.Equal(gotHijack), "%s %s/%d", host, proto, port)
+			}
+		}
+	}
+
+	// Spot checks that the rules match what they were meant to
+	for _, tt := range []struct {
+		host  string
+		proto Protocol
+		port  uint16
+		want  string
+	}{
+		{"www.example.com", ProtocolTCP, 443, "R1"},
+		{"aaa.com", ProtocolTCP, 465, "R2"},
+		{"x.net", ProtocolUDP, 5353, "R3"},
+		{"c(d).org", ProtocolTCP, 9000, "R4"},
+		{"shop.io", ProtocolTCP, 8005, "R5"},
+		{"a,b.com", ProtocolUDP, 9000, "R7"}, // not R6
+		{"b.org", ProtocolUDP, 9000, "R6"},
+		{"xa,b.org", ProtocolTCP, 9000, "R7"},
+		{"w2.io", ProtocolTCP, 9000, "R7"},
+		{"lan42", ProtocolTCP, 22, "R8"},
+	} {
+		outbound, _ := got.MatchAddress(HostInfo{Name: tt.host}, tt.proto, tt.port)
+		assert.Equal(t, tt.want, outbound, "%s %s/%d", tt.host, tt.proto, tt.port)
+	}
+}
@@ -74,6 +74,9 @@ func DefaultGeoSiteFilename(format GeoSiteFormat) string {
 	}
 }
 
+// DefaultASNFilename is the default filename of the ASN database.
+const DefaultASNFilename = "GeoLite2-ASN.mmdb"
+
 // MetaCubeX CDN URLs for various geo data formats.
 const (
 	MetaCubeXGeoIPDatURL    = "https://cdn.jsdelivr.net/gh/MetaCubeX/meta-rules-dat@release/geoip.dat"
@@ -81,4 +84,5 @@ const (
 	MetaCubeXGeoIPMetaDBURL = "https://cdn.jsdelivr.net/gh/MetaCubeX/meta-rules-dat@release/geoip.metadb"
 	MetaCubeXGeoSiteDatURL  = "https://cdn.jsdelivr.net/gh/MetaCubeX/meta-rules-dat@release/geosite.dat"
 	MetaCubeXGeoSiteDBURL   = "https://cdn.jsdelivr.net/gh/MetaCubeX/meta-rules-dat@release/geosite.db"
+	MetaCubeXASNURL         = "https://cdn.jsdelivr.net/gh/MetaCubeX/meta-rules-dat@release/GeoLite2-ASN.mmdb"
 )
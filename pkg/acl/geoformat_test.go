@@ -134,6 +134,7 @@ func TestMetaCubeXURLConstants(t *testing.T) {
 		"MetaCubeXGeoIPMetaDBURL": MetaCubeXGeoIPMetaDBURL,
 		"MetaCubeXGeoSiteDatURL":  MetaCubeXGeoSiteDatURL,
 		"MetaCubeXGeoSiteDBURL":   MetaCubeXGeoSiteDBURL,
+		"MetaCubeXASNURL":         MetaCubeXASNURL,
 	}
 
 	for name, url := range urls {
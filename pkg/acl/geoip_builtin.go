@@ -0,0 +1,68 @@
+package acl
+
+import (
+	"net"
+	"strings"
+
+	"github.com/xflash-panda/acl-engine/pkg/acl/geodat"
+)
+
+// builtinGeoIP are GeoIP codes for special-purpose address ranges that are
+// resolved without a GeoIP database. An entry with the same name in a loaded
+// database is used instead, as its list may be wider (e.g. "private").
+var builtinGeoIP = map[string][]string{
+	// Addresses that never leave the local network or the ISP:
+	// RFC 1918, CGNAT, loopback, link-local and unique local addresses
+	"private": {
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"::1/128",
+		"fc00::/7",
+		"fe80::/10",
+	},
+	"loopback":  {"127.0.0.0/8", "::1/128"},
+	"linklocal": {"169.254.0.0/16", "fe80::/10"},
+	"multicast": {"224.0.0.0/4", "ff00::/8"},
+	"cgnat":     {"100.64.0.0/10"},
+	// Other special-purpose ranges (RFC 6890): "this network", IETF protocol
+	// assignments, documentation, benchmarking, future use and broadcast
+	"reserved": {
+		"0.0.0.0/8",
+		"192.0.0.0/24",
+		"192.0.2.0/24",
+		"198.18.0.0/15",
+		"198.51.100.0/24",
+		"203.0.113.0/24",
+		"240.0.0.0/4",
+		"::/128",
+		"100::/64",
+		"2001:db8::/32",
+	},
+}
+
+// builtinGeoIPList returns the built-in GeoIP list for code, or nil if code
+// is not a built-in code.
+func builtinGeoIPList(code string) *geodat.GeoIP {
+	cidrs, ok := builtinGeoIP[code]
+	if !ok {
+		return nil
+	}
+	list := &geodat.GeoIP{CountryCode: strings.ToUpper(code)}
+	for _, s := range cidrs {
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			panic("invalid built-in CIDR " + s)
+		}
+		ones, _ := ipnet.Mask.Size()
+		ip := ipnet.IP
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		list.Cidr = append(list.Cidr, &geodat.CIDR{Ip: ip, Prefix: uint32(ones)}) // #nosec G115 -- ones is 0-128
+	}
+	return list
+}
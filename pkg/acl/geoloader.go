@@ -13,6 +13,7 @@ import (
 	"github.com/xflash-panda/acl-engine/pkg/acl/geodat"
 	"github.com/xflash-panda/acl-engine/pkg/acl/metadb"
 	"github.com/xflash-panda/acl-engine/pkg/acl/mmdb"
+	"github.com/xflash-panda/acl-engine/pkg/acl/ruleset"
 	"github.com/xflash-panda/acl-engine/pkg/acl/singsite"
 )
 
@@ -24,12 +25,16 @@ var (
 	ErrGeoIPFormatNotSet   = errors.New("GeoIPFormat not set and cannot be detected from file path")
 	ErrGeoSiteFormatNotSet = errors.New("GeoSiteFormat not set and cannot be detected from file path")
 	ErrUnsupportedFormat   = errors.New("unsupported geo format")
+	ErrASNPathNotSet       = errors.New("no ASN database configured")
 )
 
 // FileGeoLoader implements GeoLoader interface by loading geo data from files.
+// It also implements ASNLoader and RuleSetLoader.
 type FileGeoLoader struct {
 	GeoIPPath     string
 	GeoSitePath   string
+	ASNPath       string        // Optional, ASN database in MMDB format (GeoLite2-ASN or ipinfo)
+	RuleSetDir    string        // Optional, directory of sing-box rule-sets (name.srs or name.json)
 	GeoIPFormat   GeoIPFormat   // Optional, auto-detected from path if not set
 	GeoSiteFormat GeoSiteFormat // Optional, auto-detected from path if not set
 
@@ -39,6 +44,10 @@ type FileGeoLoader struct {
 	geoSiteOnce sync.Once
 	geoSiteMap  map[string]*geodat.GeoSite
 	geoSiteErr  error
+	asnOnce     sync.Once
+	asnMap      map[string]*geodat.GeoIP
+	asnErr      error
+	ruleSets    ruleSetFiles
 }
 
 // NewFileGeoLoader creates a new FileGeoLoader with the given file paths.
@@ -98,6 +107,28 @@ func (l *FileGeoLoader) LoadGeoSite() (map[string]*geodat.GeoSite, error) {
 	return l.geoSiteMap, l.geoSiteErr
 }
 
+// LoadASN loads the ASN database from the configured file path, or returns
+// ErrASNPathNotSet if there is none. The result is cached after the first call.
+func (l *FileGeoLoader) LoadASN() (map[string]*geodat.GeoIP, error) {
+	l.asnOnce.Do(func() {
+		if l.ASNPath == "" {
+			l.asnErr = ErrASNPathNotSet
+			return
+		}
+		l.asnMap, l.asnErr = mmdb.LoadASN(l.ASNPath)
+	})
+	return l.asnMap, l.asnErr
+}
+
+// LoadRuleSet loads the rule-set name from RuleSetDir.
+// The result is cached after the first call.
+func (l *FileGeoLoader) LoadRuleSet(name string) (*ruleset.RuleSet, error) {
+	if l.RuleSetDir == "" {
+		return nil, nil
+	}
+	return l.ruleSets.load(l.RuleSetDir, name)
+}
+
 // NilGeoLoader is a GeoLoader that always returns nil (no geo data).
 // Useful when you don't need GeoIP/GeoSite matching.
 type NilGeoLoader struct{}
@@ -110,8 +141,17 @@ func (l *NilGeoLoader) LoadGeoSite() (map[string]*geodat.GeoSite, error) {
 	return nil, nil
 }
 
+func (l *NilGeoLoader) LoadASN() (map[string]*geodat.GeoIP, error) {
+	return nil, nil
+}
+
+func (l *NilGeoLoader) LoadRuleSet(name string) (*ruleset.RuleSet, error) {
+	return nil, nil
+}
+
 // AutoGeoLoader implements GeoLoader with automatic download support.
 // It downloads geo data files from CDN if they don't exist or are outdated.
+// It also implements ASNLoader and RuleSetLoader (rule-sets are not downloaded).
 type AutoGeoLoader struct {
 	// GeoIPPath is the full path to the geoip file.
 	// If empty, uses DataDir + default filename based on GeoIPFormat.
@@ -119,9 +159,15 @@ type AutoGeoLoader struct {
 	// GeoSitePath is the full path to the geosite file.
 	// If empty, uses DataDir + default filename based on GeoSiteFormat.
 	GeoSitePath string
+	// ASNPath is the full path to the ASN database (MMDB format).
+	// If empty, uses DataDir + DefaultASNFilename.
+	ASNPath string
 	// DataDir is the directory to store downloaded files.
-	// Required when GeoIPPath/GeoSitePath is not set.
+	// Required when GeoIPPath/GeoSitePath/ASNPath is not set.
 	DataDir string
+	// RuleSetDir is the directory of sing-box rule-sets (name.srs or name.json)
+	// for "ruleset:" rules. If empty, uses DataDir.
+	RuleSetDir string
 	// GeoIPFormat specifies the GeoIP file format.
 	// If empty, auto-detected from GeoIPPath extension.
 	GeoIPFormat GeoIPFormat
@@ -134,6 +180,9 @@ type AutoGeoLoader struct {
 	// GeoSiteURL is the download URL for the geosite file.
 	// Required when auto-downloading is needed.
 	GeoSiteURL string
+	// ASNURL is the download URL for the ASN database.
+	// Required when auto-downloading is needed.
+	ASNURL string
 	// UpdateInterval is the interval to check for updates.
 	// If zero, uses DefaultUpdateInterval (7 days).
 	UpdateInterval time.Duration
@@ -142,6 +191,8 @@ type AutoGeoLoader struct {
 
 	geoIPMap   map[string]*geodat.GeoIP
 	geoSiteMap map[string]*geodat.GeoSite
+	asnMap     map[string]*geodat.GeoIP
+	ruleSets   ruleSetFiles
 	mu         sync.Mutex
 }
 
@@ -195,6 +246,16 @@ func (l *AutoGeoLoader) getGeoSitePath() string {
 	return filename
 }
 
+func (l *AutoGeoLoader) getASNPath() string {
+	if l.ASNPath != "" {
+		return l.ASNPath
+	}
+	if l.DataDir != "" {
+		return filepath.Join(l.DataDir, DefaultASNFilename)
+	}
+	return DefaultASNFilename
+}
+
 func (l *AutoGeoLoader) getUpdateInterval() time.Duration {
 	if l.UpdateInterval > 0 {
 		return l.UpdateInterval
@@ -348,6 +409,85 @@ func (l *AutoGeoLoader) LoadGeoSite() (map[string]*geodat.GeoSite, error) {
 	return m, nil
 }
 
+// LoadASN loads the ASN database, downloading if necessary.
+func (l *AutoGeoLoader) LoadASN() (map[string]*geodat.GeoIP, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.asnMap != nil {
+		return l.asnMap, nil
+	}
+
+	filename := l.getASNPath()
+
+	// Try to download if needed
+	if l.shouldDownload(filename) {
+		err := l.download(filename, l.ASNURL, func(f string) error {
+			_, err := mmdb.LoadASN(f)
+			return err
+		})
+		if err != nil {
+			// If download fails but file exists, try to use it
+			if _, serr := os.Stat(filename); os.IsNotExist(serr) {
+				return nil, err
+			}
+		}
+	}
+
+	m, err := mmdb.LoadASN(filename)
+	if err != nil {
+		return nil, err
+	}
+	l.asnMap = m
+	return m, nil
+}
+
+// LoadRuleSet loads the rule-set name from RuleSetDir (or DataDir).
+// The result is cached after the first call.
+func (l *AutoGeoLoader) LoadRuleSet(name string) (*ruleset.RuleSet, error) {
+	dir := l.RuleSetDir
+	if dir == "" {
+		dir = l.DataDir
+	}
+	return l.ruleSets.load(dir, name)
+}
+
+// ruleSetFiles loads rule-sets by name from a directory, and caches them.
+type ruleSetFiles struct {
+	mu    sync.Mutex
+	cache map[string]*ruleset.RuleSet
+}
+
+// ruleSetExtensions are the file extensions of rule-sets, in order of preference.
+var ruleSetExtensions = []string{".srs", ".json"}
+
+// load returns the rule-set name from dir, or nil if there is none.
+func (f *ruleSetFiles) load(dir, name string) (*ruleset.RuleSet, error) {
+	if err := checkRuleSetName(name); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if rs, ok := f.cache[name]; ok {
+		return rs, nil
+	}
+	for _, ext := range ruleSetExtensions {
+		rs, err := ruleset.Load(filepath.Join(dir, name+ext))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if f.cache == nil {
+			f.cache = make(map[string]*ruleset.RuleSet)
+		}
+		f.cache[name] = rs
+		return rs, nil
+	}
+	return nil, nil
+}
+
 // loadGeoIP loads GeoIP data from a file based on the specified format.
 func loadGeoIP(filename string, format GeoIPFormat) (map[string]*geodat.GeoIP, error) {
 	switch format {
@@ -20,6 +20,14 @@ func TestNilGeoLoader(t *testing.T) {
 	geoSite, err := loader.LoadGeoSite()
 	assert.NoError(t, err)
 	assert.Nil(t, geoSite)
+
+	asn, err := loader.LoadASN()
+	assert.NoError(t, err)
+	assert.Nil(t, asn)
+
+	rs, err := loader.LoadRuleSet("test")
+	assert.NoError(t, err)
+	assert.Nil(t, rs)
 }
 
 func TestFileGeoLoader_EmptyPath(t *testing.T) {
@@ -32,12 +40,22 @@ func TestFileGeoLoader_EmptyPath(t *testing.T) {
 	geoSite, err := loader.LoadGeoSite()
 	assert.NoError(t, err)
 	assert.Nil(t, geoSite)
+
+	// asn: rules cannot be used without an ASN database
+	asn, err := loader.LoadASN()
+	assert.ErrorIs(t, err, ErrASNPathNotSet)
+	assert.Nil(t, asn)
+	rules, err := ParseTextRules("direct(asn:13335)")
+	require.NoError(t, err)
+	_, err = Compile(rules, map[string]string{"direct": "DIRECT"}, 0, loader)
+	assert.ErrorContains(t, err, "no ASN database configured")
 }
 
 func TestFileGeoLoader_NonExistentFile(t *testing.T) {
 	loader := &FileGeoLoader{
 		GeoIPPath:   "/nonexistent/path/geoip.dat",
 		GeoSitePath: "/nonexistent/path/geosite.dat",
+		ASNPath:     "/nonexistent/path/GeoLite2-ASN.mmdb",
 	}
 
 	_, err := loader.LoadGeoIP()
@@ -45,6 +63,9 @@ func TestFileGeoLoader_NonExistentFile(t *testing.T) {
 
 	_, err = loader.LoadGeoSite()
 	assert.Error(t, err)
+
+	_, err = loader.LoadASN()
+	assert.Error(t, err)
 }
 
 func TestFileGeoLoader_FormatDetection(t *testing.T) {
@@ -304,6 +325,11 @@ func TestAutoGeoLoader_DownloadNoURL(t *testing.T) {
 	_, err = loader.LoadGeoSite()
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "download URL not configured")
+
+	_, err = loader.LoadASN()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "download URL not configured")
+	assert.Contains(t, err.Error(), filepath.Join(tmpDir, DefaultASNFilename))
 }
 
 func TestLoadGeoIPFunctions(t *testing.T) {
@@ -0,0 +1,79 @@
+package acl
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// HijackAddress is the address that a rule redirects the connections it
+// matches to (its third argument), such as
+//
+//	direct(all, udp/53, 127.0.0.1)
+//	direct(all, udp/53, 127.0.0.1:5353)
+//	direct(geosite:google, tcp/443, [2001:db8::1]:8443)
+//	direct(suffix:example.com, *, mirror.example.org)
+//
+// Exactly one of IP and Domain is set. A domain is resolved by the router
+// after matching, and the rules are not matched again.
+type HijackAddress struct {
+	IP     net.IP
+	Domain string // lower case
+	Port   uint16 // 0 keeps the port of the connection
+}
+
+// String returns the address in the form it is written in rules.
+func (a *HijackAddress) String() string {
+	host := a.Domain
+	if a.IP != nil {
+		host = a.IP.String()
+	}
+	if a.Port == 0 {
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(a.Port)))
+}
+
+// Equal reports whether a and b are the same address. Either may be nil.
+func (a *HijackAddress) Equal(b *HijackAddress) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.IP.Equal(b.IP) && a.Domain == b.Domain && a.Port == b.Port
+}
+
+// parseHijackAddress parses the hijack address of a rule: an IP address or a
+// domain, optionally followed by a port.
+func parseHijackAddress(s string) (*HijackAddress, error) {
+	s = strings.TrimSpace(s)
+	if ip := net.ParseIP(s); ip != nil {
+		return &HijackAddress{IP: ip}, nil
+	}
+	a := &HijackAddress{}
+	host := s
+	if h, portStr, err := net.SplitHostPort(s); err == nil {
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("invalid port %s", portStr)
+		}
+		host, a.Port = h, uint16(port)
+	} else if inner, ok := strings.CutPrefix(s, "["); ok {
+		host = strings.TrimSuffix(inner, "]") // "[2001:db8::1]" without a port
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		a.IP = ip
+		return a, nil
+	}
+	if host != "" && !strings.ContainsFunc(host, unicode.IsLetter) {
+		// Likely a stray part of an unquoted port list, e.g. "853:5353"
+		return nil, fmt.Errorf("hijack address %s is neither an IP address nor a domain, "+
+			"quote the port list if it belongs to it (e.g. \"udp/53,853\")", host)
+	}
+	a.Domain = strings.ToLower(host)
+	if err := checkDomainName(a.Domain); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
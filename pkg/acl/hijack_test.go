@@ -0,0 +1,100 @@
+package acl
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseHijackAddress(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    *HijackAddress
+		wantErr string
+	}{
+		{"127.0.0.1", &HijackAddress{IP: net.ParseIP("127.0.0.1")}, ""},
+		{"2001:db8::1", &HijackAddress{IP: net.ParseIP("2001:db8::1")}, ""},
+		{"[2001:db8::1]", &HijackAddress{IP: net.ParseIP("2001:db8::1")}, ""},
+		{"127.0.0.1:5353", &HijackAddress{IP: net.ParseIP("127.0.0.1"), Port: 5353}, ""},
+		{"[2001:db8::1]:8443", &HijackAddress{IP: net.ParseIP("2001:db8::1"), Port: 8443}, ""},
+		{"Mirror.Example.org", &HijackAddress{Domain: "mirror.example.org"}, ""},
+		{"dns.example.com:53", &HijackAddress{Domain: "dns.example.com", Port: 53}, ""},
+		{"127.0.0.1:0", nil, "invalid port 0"},
+		{"dns.example.com:http", nil, "invalid port http"},
+		{"a..com", nil, "invalid domain a..com"},
+		{"a b.com", nil, "invalid domain a b.com"},
+		{"", nil, "invalid domain"},
+		{"853", nil, `quote the port list if it belongs to it (e.g. "udp/53,853")`},
+		{"853:5353", nil, "hijack address 853 is neither an IP address nor a domain"},
+		{"1.2.3", nil, "hijack address 1.2.3 is neither"},
+	}
+	for _, tt := range tests {
+		got, err := parseHijackAddress(tt.s)
+		if tt.wantErr != "" {
+			assert.ErrorContains(t, err, tt.wantErr, tt.s)
+			continue
+		}
+		require.NoError(t, err, tt.s)
+		assert.Equal(t, tt.want, got, tt.s)
+		// String gives back an equivalent address
+		again, err := parseHijackAddress(got.String())
+		require.NoError(t, err, tt.s)
+		assert.True(t, got.Equal(again), tt.s)
+	}
+}
+
+func TestHijackAddress_Equal(t *testing.T) {
+	a := &HijackAddress{IP: net.ParseIP("127.0.0.1"), Port: 53}
+	assert.True(t, a.Equal(&HijackAddress{IP: net.IPv4(127, 0, 0, 1).To4(), Port: 53}))
+	assert.False(t, a.Equal(&HijackAddress{IP: net.ParseIP("127.0.0.1")}))
+	assert.False(t, a.Equal(nil))
+	assert.True(t, (*HijackAddress)(nil).Equal(nil))
+}
+
+func TestCompile_HijackAddress(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT"}
+	rules, err := ParseTextRules(`
+direct(suffix:local.test, udp/53, 127.0.0.1:5353)
+direct(suffix:example.com, *, mirror.example.org)
+direct(suffix:example.net, tcp/443, "[2001:db8::1]:8443")
+direct(all)
+`)
+	require.NoError(t, err)
+	rs, err := Compile(rules, outbounds, 16, &NilGeoLoader{})
+	require.NoError(t, err)
+	tests := []struct {
+		host  string
+		proto Protocol
+		port  uint16
+		want  string
+	}{
+		{"a.local.test", ProtocolUDP, 53, "127.0.0.1:5353"},
+		{"www.example.com", ProtocolTCP, 80, "mirror.example.org"},
+		{"www.example.net", ProtocolTCP, 443, "[2001:db8::1]:8443"},
+		{"www.example.org", ProtocolTCP, 443, ""},
+	}
+	for _, tt := range tests {
+		_, hijack := rs.MatchAddress(HostInfo{Name: tt.host}, tt.proto, tt.port)
+		if tt.want == "" {
+			assert.Nil(t, hijack, tt.host)
+			continue
+		}
+		require.NotNil(t, hijack, tt.host)
+		assert.Equal(t, tt.want, hijack.String(), tt.host)
+	}
+
+	// Match only returns IP addresses, without their port
+	_, ip := rs.Match(HostInfo{Name: "a.local.test"}, ProtocolUDP, 53)
+	assert.Equal(t, net.ParseIP("127.0.0.1"), ip)
+	_, ip = rs.Match(HostInfo{Name: "www.example.com"}, ProtocolTCP, 80)
+	assert.Nil(t, ip)
+
+	// Hijack addresses are kept by Format
+	assert.Equal(t, `direct(suffix:local.test, udp/53, 127.0.0.1:5353)
+direct(suffix:example.com, *, mirror.example.org)
+direct(suffix:example.net, tcp/443, [2001:db8::1]:8443)
+direct(all)
+`, Format(rules))
+}
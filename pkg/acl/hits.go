@@ -0,0 +1,57 @@
+package acl
+
+import "sync/atomic"
+
+// RuleHits is the number of connections matched by a rule.
+type RuleHits struct {
+	Index int // index of the rule among the compiled rules
+	Rule  TextRule
+	Hits  uint64
+}
+
+// HitStats is a snapshot of the hit counters of a CompiledRuleSet.
+// It is empty if the rule set was compiled without WithHitCounters.
+type HitStats struct {
+	Rules   []RuleHits // in rule order
+	NoMatch uint64     // connections no rule matched
+}
+
+// NeverMatched returns the rules that did not match any connection
+// (since the counters were last reset).
+func (s HitStats) NeverMatched() []RuleHits {
+	var rules []RuleHits
+	for _, r := range s.Rules {
+		if r.Hits == 0 {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+func (s *compiledRuleSetImpl[O]) HitStats() HitStats {
+	return s.hitStats(false)
+}
+
+func (s *compiledRuleSetImpl[O]) ResetHitStats() HitStats {
+	return s.hitStats(true)
+}
+
+func (s *compiledRuleSetImpl[O]) hitStats(reset bool) HitStats {
+	if s.hits == nil {
+		return HitStats{}
+	}
+	load := func(c *atomic.Uint64) uint64 {
+		if reset {
+			return c.Swap(0)
+		}
+		return c.Load()
+	}
+	stats := HitStats{
+		Rules:   make([]RuleHits, len(s.Rules)),
+		NoMatch: load(&s.noMatch),
+	}
+	for i := range s.Rules {
+		stats.Rules[i] = RuleHits{Index: i, Rule: s.Rules[i].Text, Hits: load(&s.hits[i])}
+	}
+	return stats
+}
@@ -0,0 +1,43 @@
+package acl
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompiledRuleSet_HitStats(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT", "proxy": "PROXY"}
+	rules, err := ParseTextRules("@lan = 10.0.0.0/8\ndirect(@lan)\nproxy(suffix:example.com)\nproxy(all, udp)")
+	require.NoError(t, err)
+	rs, err := Compile[string](rules, outbounds, 16, newTestGeoLoader(), WithHitCounters())
+	require.NoError(t, err)
+
+	lan := HostInfo{IPv4: net.ParseIP("10.1.1.1")}
+	for range 3 {
+		rs.Match(lan, ProtocolTCP, 80) // cached after the first time
+	}
+	rs.Match(HostInfo{Name: "other.org"}, ProtocolTCP, 80)
+	rs.Explain(HostInfo{Name: "www.example.com"}, ProtocolTCP, 80) // not counted
+
+	stats := rs.HitStats()
+	assert.Equal(t, []uint64{3, 0, 0}, []uint64{stats.Rules[0].Hits, stats.Rules[1].Hits, stats.Rules[2].Hits})
+	assert.Equal(t, uint64(1), stats.NoMatch)
+	assert.Equal(t, 2, stats.Rules[0].Rule.LineNum)
+
+	never := stats.NeverMatched()
+	require.Len(t, never, 2)
+	assert.Equal(t, 1, never[0].Index)
+	assert.Equal(t, 2, never[1].Index)
+
+	assert.Equal(t, stats, rs.ResetHitStats())
+	assert.Len(t, rs.HitStats().NeverMatched(), 3)
+
+	// Without WithHitCounters there are no stats
+	rs, err = Compile[string](rules, outbounds, 16, newTestGeoLoader())
+	require.NoError(t, err)
+	rs.Match(lan, ProtocolTCP, 80)
+	assert.Equal(t, HitStats{}, rs.HitStats())
+}
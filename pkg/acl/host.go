@@ -0,0 +1,111 @@
+package acl
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"golang.org/x/net/idna"
+
+	"github.com/xflash-panda/acl-engine/pkg/acl/iptree"
+)
+
+// Host is like HostInfo, with netip addresses instead of net.IP. Rules are
+// matched against a Host, so CompiledRuleSet.MatchHost is the fastest way to
+// match a connection: a cached result is returned without allocating.
+// Invalid (zero) addresses are unknown.
+type Host struct {
+	Name string
+	IPv4 netip.Addr
+	IPv6 netip.Addr
+
+	// Optional metadata of the session the connection belongs to,
+	// for src:, inbound: and user: conditions.
+	SrcIP   netip.Addr
+	SrcPort uint16
+	Inbound string // tag of the inbound (listener) the connection came from
+	User    string // authenticated user
+
+	unicodeName string // Name with punycode labels decoded, set by normalize
+}
+
+// Host converts h to a Host. It does not allocate.
+func (h HostInfo) Host() Host {
+	return Host{
+		Name:    h.Name,
+		IPv4:    iptree.AddrFromIP(h.IPv4),
+		IPv6:    iptree.AddrFromIP(h.IPv6),
+		SrcIP:   iptree.AddrFromIP(h.SrcIP),
+		SrcPort: h.SrcPort,
+		Inbound: h.Inbound,
+		User:    h.User,
+	}
+}
+
+// HostInfo converts h to a HostInfo.
+func (h Host) HostInfo() HostInfo {
+	return HostInfo{
+		Name:    h.Name,
+		IPv4:    ipFromAddr(h.IPv4),
+		IPv6:    ipFromAddr(h.IPv6),
+		SrcIP:   ipFromAddr(h.SrcIP),
+		SrcPort: h.SrcPort,
+		Inbound: h.Inbound,
+		User:    h.User,
+	}
+}
+
+// String returns the destination part of the Host, like HostInfo.String.
+func (h Host) String() string {
+	return fmt.Sprintf("%s|%s|%s", h.Name, addrString(h.IPv4), addrString(h.IPv6))
+}
+
+// normalize converts the host name to lower case and IPv4-mapped IPv6
+// addresses to IPv4, as the matchers expect, and decodes the name for domain
+// rules once for all of them.
+func (h *Host) normalize() {
+	h.Name = strings.ToLower(h.Name)
+	h.unicodeName = toUnicode(h.Name)
+	h.IPv4 = h.IPv4.Unmap()
+	h.IPv6 = h.IPv6.Unmap()
+	h.SrcIP = h.SrcIP.Unmap()
+}
+
+// domainName returns the host name that domain rules match: Name with its
+// punycode labels decoded, since the rules are written in Unicode.
+func (h Host) domainName() string {
+	if h.unicodeName == "" {
+		// Not normalized
+		return toUnicode(h.Name)
+	}
+	return h.unicodeName
+}
+
+// toUnicode decodes the punycode ("xn--") labels of name. Names without any
+// are returned as is, without going through idna.
+func toUnicode(name string) string {
+	if !strings.Contains(name, "xn--") {
+		return name
+	}
+	u, err := idna.ToUnicode(name)
+	if err != nil {
+		return name
+	}
+	return u
+}
+
+func ipFromAddr(addr netip.Addr) net.IP {
+	if !addr.IsValid() {
+		return nil
+	}
+	return addr.AsSlice()
+}
+
+// addrString formats addr like net.IP.String: "<nil>" if it is unknown.
+func addrString(addr netip.Addr) string {
+	if !addr.IsValid() {
+		return "<nil>"
+	}
+	return addr.String()
+}
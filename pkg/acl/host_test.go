@@ -0,0 +1,121 @@
+package acl
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostInfo_Host(t *testing.T) {
+	info := HostInfo{
+		Name:    "example.com",
+		IPv4:    net.ParseIP("1.2.3.4"), // 16-byte form
+		IPv6:    net.ParseIP("2001:db8::1"),
+		SrcIP:   net.IPv4(10, 1, 2, 3).To4(),
+		SrcPort: 40000,
+		Inbound: "office",
+		User:    "alice",
+	}
+	host := info.Host()
+	assert.Equal(t, Host{
+		Name:    "example.com",
+		IPv4:    netip.MustParseAddr("1.2.3.4"),
+		IPv6:    netip.MustParseAddr("2001:db8::1"),
+		SrcIP:   netip.MustParseAddr("10.1.2.3"),
+		SrcPort: 40000,
+		Inbound: "office",
+		User:    "alice",
+	}, host)
+	assert.Equal(t, info.String(), host.String())
+
+	back := host.HostInfo()
+	assert.True(t, back.IPv4.Equal(info.IPv4))
+	assert.True(t, back.IPv6.Equal(info.IPv6))
+	assert.True(t, back.SrcIP.Equal(info.SrcIP))
+	assert.Equal(t, info.Inbound, back.Inbound)
+
+	// Unknown addresses
+	assert.Equal(t, Host{Name: "example.com"}, HostInfo{Name: "example.com"}.Host())
+	assert.Equal(t, HostInfo{Name: "example.com"}, Host{Name: "example.com"}.HostInfo())
+	assert.Equal(t, "example.com|<nil>|<nil>", Host{Name: "example.com"}.String())
+}
+
+func TestHost_domainName(t *testing.T) {
+	host := Host{Name: "WWW.XN--Fiqs8s"}
+	host.normalize()
+	assert.Equal(t, "www.xn--fiqs8s", host.Name)
+	assert.Equal(t, "www.中国", host.domainName())
+
+	// Without normalize
+	assert.Equal(t, "www.中国", Host{Name: "www.xn--fiqs8s"}.domainName())
+	assert.Equal(t, "www.example.com", Host{Name: "www.example.com"}.domainName())
+	assert.Empty(t, Host{}.domainName())
+}
+
+func TestCompiledRuleSet_MatchHost(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT", "proxy": "PROXY", "reject": "REJECT"}
+	rules, err := ParseTextRules(`
+reject(src:10.9.0.0/16)
+direct(10.0.0.0/8)
+proxy(1.2.3.4)
+proxy(suffix:example.com)
+reject(all)
+`)
+	require.NoError(t, err)
+	rs, err := Compile(rules, outbounds, 100, &NilGeoLoader{})
+	require.NoError(t, err)
+
+	tests := []struct {
+		host Host
+		want string
+	}{
+		{Host{IPv4: netip.MustParseAddr("10.1.2.3")}, "DIRECT"},
+		{Host{IPv6: netip.MustParseAddr("::ffff:10.1.2.3")}, "DIRECT"},
+		{Host{IPv4: netip.MustParseAddr("1.2.3.4")}, "PROXY"},
+		{Host{Name: "WWW.Example.com"}, "PROXY"},
+		{Host{Name: "www.example.com", SrcIP: netip.MustParseAddr("10.9.1.1")}, "REJECT"},
+		{Host{}, "REJECT"},
+	}
+	for _, tt := range tests {
+		got, _ := rs.MatchHost(tt.host, ProtocolTCP, 443)
+		assert.Equal(t, tt.want, got, tt.host.String())
+		// Same result for the HostInfo
+		got, _ = rs.Match(tt.host.HostInfo(), ProtocolTCP, 443)
+		assert.Equal(t, tt.want, got, tt.host.String())
+	}
+}
+
+func TestCompiledRuleSet_MatchCachedAllocs(t *testing.T) {
+	rules, err := ParseTextRules(`
+direct(10.0.0.0/8)
+proxy(suffix:example.com, tcp/443)
+reject(src:192.168.0.0/16)
+reject(inbound:guest)
+direct(all)
+`)
+	require.NoError(t, err)
+	rs, err := Compile(rules, map[string]string{"direct": "DIRECT", "proxy": "PROXY", "reject": "REJECT"}, 100, &NilGeoLoader{})
+	require.NoError(t, err)
+
+	host := Host{
+		Name:    "www.example.com",
+		IPv4:    netip.MustParseAddr("93.184.216.34"),
+		IPv6:    netip.MustParseAddr("2606:2800:220:1::1"),
+		SrcIP:   netip.MustParseAddr("10.1.2.3"),
+		Inbound: "office",
+	}
+	rs.MatchHost(host, ProtocolTCP, 443)
+	allocs := testing.AllocsPerRun(100, func() {
+		rs.MatchHost(host, ProtocolTCP, 443)
+	})
+	assert.Zero(t, allocs, "MatchHost")
+
+	info := host.HostInfo()
+	allocs = testing.AllocsPerRun(100, func() {
+		rs.Match(info, ProtocolTCP, 443)
+	})
+	assert.Zero(t, allocs, "Match")
+}
@@ -0,0 +1,132 @@
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// includeDirective is the name of the directive that includes other rule files:
+//
+//	include(lan.acl)
+//	include("rules.d/*.acl")
+//
+// It is reserved and cannot be used as an outbound name.
+const includeDirective = "include"
+
+// ErrIncludeCycle is wrapped by the IncludeError returned when a file includes
+// itself, directly or indirectly.
+var ErrIncludeCycle = errors.New("include cycle")
+
+// IncludeError is returned when the files of an include directive cannot be read.
+// Source, LineNum and Column locate the include directive.
+type IncludeError struct {
+	Source  string
+	LineNum int
+	Column  int
+	Path    string // path as written in the include directive
+	Err     error
+}
+
+func (e *IncludeError) Error() string {
+	return fmt.Sprintf("error at %s: include(%s): %v", formatLocation(e.Source, e.LineNum, e.Column), e.Path, e.Err)
+}
+
+func (e *IncludeError) Unwrap() error {
+	return e.Err
+}
+
+func (r *TextRule) isInclude() bool {
+	return r.SetName == "" && strings.EqualFold(r.Outbound, includeDirective)
+}
+
+// checkInclude checks the arguments of an include directive.
+func checkInclude(s *scanner, rule *TextRule) *InvalidSyntaxError {
+	if rule.ProtoPortPos.IsValid() {
+		return s.errorf(rule.ProtoPortPos, "include() takes exactly one path")
+	}
+	if rule.SchedulePos.IsValid() {
+		return s.errorf(rule.SchedulePos, "include() cannot have a schedule")
+	}
+	return nil
+}
+
+// ParseFile parses ACL rules from a file, including the files it includes.
+// The file name is used as the source of the rules (see WithSource), unless
+// overridden, and relative include paths are resolved against the directory
+// of the file.
+func ParseFile(filename string, opts ...Option) ([]TextRule, error) {
+	o := newOptions(append([]Option{WithSource(filename)}, opts...))
+	bs, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{allErrors: o.allErrors, comments: o.comments}
+	if abs, err := filepath.Abs(filename); err == nil {
+		p.files = append(p.files, abs)
+	}
+	rules, err := p.parse(string(bs), o.source, filepath.Dir(filename))
+	if err != nil {
+		return nil, err
+	}
+	return rules, p.errs.Err()
+}
+
+// include parses the files matched by an include directive, in lexical order.
+// A pattern without glob metacharacters must match an existing file, while a
+// glob matching nothing includes nothing.
+func (p *parser) include(rule *TextRule, dir string) ([]TextRule, error) {
+	fail := func(err error) ([]TextRule, error) {
+		return nil, p.fail(&IncludeError{
+			Source:  rule.Source,
+			LineNum: rule.LineNum,
+			Column:  rule.Column,
+			Path:    rule.Address,
+			Err:     err,
+		})
+	}
+
+	pattern := rule.Address
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return fail(fmt.Errorf("invalid pattern: %w", err))
+	}
+	if len(matches) == 0 && !strings.ContainsAny(rule.Address, "*?[") {
+		// Not a glob, report why the file cannot be read
+		matches = []string{pattern}
+	}
+
+	var rules []TextRule
+	for _, filename := range matches {
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			return fail(err)
+		}
+		if i := slices.Index(p.files, abs); i >= 0 {
+			var chain []string
+			for _, f := range p.files[i:] {
+				chain = append(chain, filepath.Base(f))
+			}
+			chain = append(chain, filepath.Base(abs))
+			return fail(fmt.Errorf("%w: %s", ErrIncludeCycle, strings.Join(chain, " -> ")))
+		}
+		bs, err := os.ReadFile(filename)
+		if err != nil {
+			return fail(err)
+		}
+		p.files = append(p.files, abs)
+		included, err := p.parse(string(bs), filename, filepath.Dir(filename))
+		p.files = p.files[:len(p.files)-1]
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, included...)
+	}
+	return rules, nil
+}
@@ -0,0 +1,131 @@
+package acl
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFiles creates files (relative path -> content) in a temporary directory
+// and returns the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	return dir
+}
+
+func TestParseFile_Include(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.acl":            "include(lan.acl)\ninclude(\"rules.d/*.acl\")\nproxy(all)\n",
+		"lan.acl":             "@lan = 10.0.0.0/8\ndirect(@lan)\n",
+		"rules.d/a.acl":       "reject(suffix:a.com)\ninclude(../nested/b.acl)\n",
+		"rules.d/c.acl":       "reject(suffix:c.com)\n",
+		"rules.d/ignored.txt": "not a rule\n",
+		"nested/b.acl":        "reject(suffix:b.com)\n",
+	})
+	rules, err := ParseFile(filepath.Join(dir, "main.acl"))
+	require.NoError(t, err)
+
+	type ruleRef struct {
+		Source  string
+		LineNum int
+		Address string
+	}
+	var got []ruleRef
+	for _, r := range rules {
+		rel, err := filepath.Rel(dir, r.Source)
+		require.NoError(t, err)
+		got = append(got, ruleRef{filepath.ToSlash(rel), r.LineNum, r.Address})
+	}
+	assert.Equal(t, []ruleRef{
+		{"lan.acl", 1, "10.0.0.0/8"},
+		{"lan.acl", 2, "@lan"},
+		{"rules.d/a.acl", 1, "suffix:a.com"},
+		{"nested/b.acl", 1, "suffix:b.com"},
+		{"rules.d/c.acl", 1, "suffix:c.com"},
+		{"main.acl", 3, "all"},
+	}, got)
+}
+
+func TestCompile_IncludeReserved(t *testing.T) {
+	// Include directives kept by WithComments are rejected, even if an
+	// outbound could be named "include"
+	rules, err := ParseTextRules("include(lan.acl)\ndirect(all)\n", WithComments())
+	require.NoError(t, err)
+	_, err = Compile(rules, map[string]string{"direct": "DIRECT"}, 0, &NilGeoLoader{})
+	var ce *CompilationError
+	require.ErrorAs(t, err, &ce)
+	assert.Equal(t, 1, ce.LineNum)
+	assert.Contains(t, ce.Message, "include directives cannot be compiled")
+
+	_, err = Compile(rules, map[string]string{"direct": "DIRECT", "include": "INCLUDE"}, 0, &NilGeoLoader{})
+	assert.ErrorContains(t, err, "invalid outbound name include: reserved for include directives")
+}
+
+func TestParseTextRules_IncludeBaseDir(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"lan.acl": "direct(10.0.0.0/8)\n"})
+	rules, err := ParseTextRules("include(lan.acl)\ninclude(missing/*.acl)", WithBaseDir(dir))
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, filepath.Join(dir, "lan.acl"), rules[0].Source)
+}
+
+func TestParseFile_IncludeErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"missing.acl":   "direct(all)\ninclude(nope.acl)\n",
+		"cycle.acl":     "include(cycle2.acl)\n",
+		"cycle2.acl":    "direct(all)\n  include(cycle.acl)\n",
+		"self/self.acl": "include(*.acl)\n",
+		"args.acl":      "include(a.acl, tcp)\n",
+		"bad.acl":       "include(bad2.acl)\n",
+		"bad2.acl":      "direct(all)\ndirect all\n",
+	})
+	tests := []struct {
+		name       string
+		file       string
+		wantSource string
+		wantLine   int
+		wantColumn int
+		wantErr    error
+		wantMsg    string
+	}{
+		{"missing file", "missing.acl", "missing.acl", 2, 1, fs.ErrNotExist, "include(nope.acl)"},
+		{"cycle", "cycle.acl", "cycle2.acl", 2, 3, ErrIncludeCycle, "cycle.acl -> cycle2.acl -> cycle.acl"},
+		{"self glob", "self/self.acl", "self/self.acl", 1, 1, ErrIncludeCycle, "self.acl -> self.acl"},
+		{"too many arguments", "args.acl", "args.acl", 1, 16, nil, "include() takes exactly one path"},
+		{"syntax error in included file", "bad.acl", "bad2.acl", 2, 8, nil, "expected '('"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFile(filepath.Join(dir, tt.file))
+			require.Error(t, err)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+			source, line, column := errorLocation(err)
+			assert.Equal(t, filepath.Join(dir, tt.wantSource), source, err.Error())
+			assert.Equal(t, tt.wantLine, line, err.Error())
+			assert.Equal(t, tt.wantColumn, column, err.Error())
+			assert.Contains(t, err.Error(), tt.wantMsg)
+		})
+	}
+
+	t.Run("all errors", func(t *testing.T) {
+		_, err := ParseFile(filepath.Join(dir, "missing.acl"), WithAllErrors())
+		var errs ErrorList
+		require.True(t, errors.As(err, &errs), err)
+		require.Len(t, errs, 1)
+		var includeErr *IncludeError
+		assert.ErrorAs(t, errs[0], &includeErr)
+	})
+}
@@ -0,0 +1,174 @@
+package acl
+
+import (
+	"iter"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/xflash-panda/acl-engine/pkg/acl/domain"
+	"github.com/xflash-panda/acl-engine/pkg/acl/iptree"
+)
+
+// ruleIndex indexes the rules of a rule set that match a single kind of
+// address, so that finding the ones that match a host does not depend on how
+// many there are:
+//
+//   - domain rules (exact domains, "suffix:" addresses and "*.example.com"
+//     wildcards) in a succinct trie,
+//   - other wildcard domain rules in a single automaton,
+//   - IP, CIDR and GeoIP rules in a prefix tree.
+type ruleIndex struct {
+	domains   *domain.Index       // IDs are rule indexes, nil if there are no domain rules
+	wildcards *domain.WildcardSet // IDs are rule indexes, nil if there are no other wildcard rules
+	ips       *iptree.Tree        // IDs are rule indexes, nil if there are no IP rules
+	rules     int                 // number of rules
+	// others are the indexes of the rules that are not in the index, in
+	// ascending order. They are always evaluated.
+	others []int
+}
+
+// newRuleIndex returns the index of rules, or nil if none of the rules can be
+// indexed.
+func newRuleIndex[O Outbound](rules []compiledRule[O]) *ruleIndex {
+	var entries []domain.IndexEntry
+	var wildcards []domain.WildcardEntry
+	x := &ruleIndex{rules: len(rules)}
+	for i, r := range rules {
+		switch m := r.HostMatcher.(type) {
+		case *domainMatcher:
+			if e, ok := m.indexEntry(); ok {
+				e.ID = i
+				entries = append(entries, e)
+				continue
+			}
+			if m.Mode == domainMatchWildcard {
+				wildcards = append(wildcards, domain.WildcardEntry{Pattern: m.Pattern, ID: i})
+				continue
+			}
+		case *ipMatcher:
+			x.addPrefix(netip.PrefixFrom(m.IP, m.IP.BitLen()), i)
+			continue
+		case *cidrMatcher:
+			x.addPrefix(m.Prefix, i)
+			continue
+		case *geoipMatcher:
+			if !m.Inverse {
+				for p := range m.Nets.All() {
+					x.addPrefix(p, i)
+				}
+				continue
+			}
+		}
+		x.others = append(x.others, i)
+	}
+	if len(entries) > 0 {
+		x.domains = domain.NewIndex(entries)
+	}
+	if len(wildcards) > 0 {
+		x.wildcards = domain.NewWildcardSet(wildcards)
+	}
+	if x.domains == nil && x.wildcards == nil && x.ips == nil {
+		return nil
+	}
+	return x
+}
+
+// addPrefix adds the prefix of an IP rule to the index.
+func (x *ruleIndex) addPrefix(p netip.Prefix, rule int) {
+	if x.ips == nil {
+		x.ips = &iptree.Tree{}
+	}
+	x.ips.Insert(p, rule)
+}
+
+// indexEntry returns the index entry with the same meaning as m, if there is
+// one. The wildcard "*.example.com" is the same as the suffix ".example.com";
+// other wildcards are not indexed.
+func (m *domainMatcher) indexEntry() (domain.IndexEntry, bool) {
+	switch m.Mode {
+	case domainMatchExact:
+		return domain.IndexEntry{Domain: m.Pattern}, true
+	case domainMatchSuffix:
+		if strings.HasPrefix(m.Pattern, ".") {
+			return domain.IndexEntry{}, false
+		}
+		return domain.IndexEntry{Domain: m.Pattern, Suffix: true}, true
+	case domainMatchWildcard:
+		rest, ok := strings.CutPrefix(m.Pattern, "*.")
+		if !ok || rest == "" || strings.HasPrefix(rest, ".") || strings.Contains(rest, "*") {
+			return domain.IndexEntry{}, false
+		}
+		return domain.IndexEntry{Domain: "." + rest, Suffix: true}, true
+	}
+	return domain.IndexEntry{}, false
+}
+
+// candidates returns the indexes of the rules that may match host, in
+// ascending order: the rules that are not in the index, merged with the
+// indexed rules whose domain or IP matches.
+func (x *ruleIndex) candidates(host Host) iter.Seq[int] {
+	var matches []int
+	runs := 0 // sorted runs of rules in matches
+	name := host.domainName()
+	if x.domains != nil && name != "" {
+		if strings.HasPrefix(name, ".") {
+			// "*.example.com" matches ".example.com", but its index entry does
+			// not. Such names are not valid anyway, so do not bother with the index.
+			return allRules(x.rules)
+		}
+		matches = x.domains.AppendMatches(matches, name)
+		if len(matches) > 0 {
+			runs++
+		}
+	}
+	if x.wildcards != nil {
+		// Even without a name, which "*" alone matches
+		n := len(matches)
+		matches = x.wildcards.AppendMatches(matches, name)
+		if len(matches) > n {
+			runs++
+		}
+	}
+	if x.ips != nil {
+		for _, ip := range []netip.Addr{host.IPv4, host.IPv6} {
+			n := len(matches)
+			matches = x.ips.AppendMatches(matches, ip)
+			if len(matches) > n {
+				runs++
+			}
+		}
+	}
+	if runs > 1 {
+		// Merge the rules matching the domain and the IPs
+		slices.Sort(matches)
+		matches = slices.Compact(matches)
+	}
+	return func(yield func(int) bool) {
+		i, j := 0, 0
+		for i < len(x.others) || j < len(matches) {
+			var next int
+			if j == len(matches) || (i < len(x.others) && x.others[i] < matches[j]) {
+				next = x.others[i]
+				i++
+			} else {
+				next = matches[j]
+				j++
+			}
+			if !yield(next) {
+				return
+			}
+		}
+	}
+}
+
+// allRules returns the indexes of n rules, in ascending order.
+func allRules(n int) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		for i := range n {
+			if !yield(i) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package acl
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompile_DomainIndex(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT", "proxy": "PROXY", "reject": "REJECT"}
+	rules, err := ParseTextRules(`
+reject(www.example.com, tcp/80)
+direct(10.0.0.0/8)
+proxy(suffix:example.com, udp)
+reject(*.ads.example.com)
+direct(*ample.org)
+proxy(example.com)
+direct(suffix:example.com)
+proxy(suffix:中国)
+reject(all)
+`)
+	require.NoError(t, err)
+	rs, err := Compile(rules, outbounds, 100, &NilGeoLoader{})
+	require.NoError(t, err)
+	crs := rs.(*compiledRuleSetImpl[string])
+	require.NotNil(t, crs.index)
+	require.NotNil(t, crs.index.wildcards) // *ample.org
+	assert.Equal(t, []int{8}, crs.index.others)
+
+	tests := []struct {
+		host  HostInfo
+		proto Protocol
+		port  uint16
+		want  string
+	}{
+		{HostInfo{Name: "www.example.com"}, ProtocolTCP, 80, "REJECT"},
+		{HostInfo{Name: "WWW.Example.com"}, ProtocolTCP, 443, "DIRECT"},
+		{HostInfo{Name: "www.example.com"}, ProtocolUDP, 53, "PROXY"},
+		{HostInfo{Name: "x.ads.example.com"}, ProtocolTCP, 443, "REJECT"},
+		{HostInfo{Name: "ads.example.com"}, ProtocolTCP, 443, "DIRECT"},
+		{HostInfo{Name: ".ads.example.com"}, ProtocolTCP, 443, "REJECT"},
+		{HostInfo{Name: "example.com"}, ProtocolTCP, 443, "PROXY"},
+		{HostInfo{Name: "example.com", IPv4: net.ParseIP("10.1.2.3")}, ProtocolTCP, 443, "DIRECT"},
+		{HostInfo{Name: "sample.org"}, ProtocolTCP, 443, "DIRECT"},
+		{HostInfo{Name: "www.xn--fiqs8s"}, ProtocolTCP, 443, "PROXY"},
+		{HostInfo{Name: "example.net"}, ProtocolTCP, 443, "REJECT"},
+		{HostInfo{IPv4: net.ParseIP("1.1.1.1")}, ProtocolTCP, 443, "REJECT"},
+	}
+	for _, tt := range tests {
+		got, _ := rs.Match(tt.host, tt.proto, tt.port)
+		assert.Equal(t, tt.want, got, tt.host.String())
+
+		// Same result as evaluating the rules one by one
+		host := tt.host.Host()
+		host.normalize()
+		for _, rule := range crs.Rules {
+			if rule.Match(host, tt.proto, tt.port) {
+				assert.Equal(t, rule.Outbound, got, tt.host.String())
+				break
+			}
+		}
+	}
+
+	// No index without domain or IP rules
+	rules, err = ParseTextRules("direct(geosite:google)\nreject(all)")
+	require.NoError(t, err)
+	rs, err = Compile(rules, outbounds, 100, newTestGeoLoader())
+	require.NoError(t, err)
+	assert.Nil(t, rs.(*compiledRuleSetImpl[string]).index)
+}
+
+func TestCompile_IPIndex(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT", "proxy": "PROXY", "reject": "REJECT"}
+	rules, err := ParseTextRules(`
+reject(10.1.2.3, tcp/22)
+proxy(10.1.0.0/16, udp)
+direct(suffix:example.com)
+reject(geoip:cn)
+proxy(!geoip:cn, tcp/443)
+direct(10.0.0.0/8)
+proxy(2001:db8::/32)
+reject(2001:db8::1)
+direct(geoip:private)
+reject(all)
+`)
+	require.NoError(t, err)
+	rs, err := Compile(rules, outbounds, 100, newTestGeoLoader())
+	require.NoError(t, err)
+	crs := rs.(*compiledRuleSetImpl[string])
+	require.NotNil(t, crs.index)
+	assert.Equal(t, []int{4, 9}, crs.index.others)
+
+	tests := []struct {
+		host  HostInfo
+		proto Protocol
+		port  uint16
+		want  string
+	}{
+		{HostInfo{IPv4: net.ParseIP("10.1.2.3")}, ProtocolTCP, 22, "REJECT"},
+		{HostInfo{IPv4: net.ParseIP("10.1.2.3")}, ProtocolUDP, 22, "PROXY"},
+		{HostInfo{IPv4: net.ParseIP("10.1.2.3")}, ProtocolTCP, 80, "DIRECT"},
+		{HostInfo{IPv4: net.ParseIP("10.1.2.3")}, ProtocolTCP, 443, "PROXY"},
+		{HostInfo{Name: "www.example.com", IPv4: net.ParseIP("1.0.1.1")}, ProtocolTCP, 443, "DIRECT"},
+		{HostInfo{Name: "www.example.net", IPv4: net.ParseIP("1.0.1.1")}, ProtocolTCP, 443, "REJECT"},
+		{HostInfo{IPv4: net.ParseIP("192.168.1.1")}, ProtocolTCP, 80, "DIRECT"},
+		{HostInfo{IPv4: net.ParseIP("8.8.8.8")}, ProtocolTCP, 80, "REJECT"},
+		{HostInfo{IPv6: net.ParseIP("2001:db8::1")}, ProtocolTCP, 80, "PROXY"},
+		{HostInfo{IPv6: net.ParseIP("::ffff:10.2.0.1")}, ProtocolTCP, 80, "DIRECT"},
+		// The first rule matching either address wins
+		{HostInfo{IPv4: net.ParseIP("192.168.1.1"), IPv6: net.ParseIP("2001:db8::2")}, ProtocolTCP, 80, "PROXY"},
+		{HostInfo{IPv4: net.ParseIP("1.0.1.1"), IPv6: net.ParseIP("2001:db8::2")}, ProtocolTCP, 80, "REJECT"},
+	}
+	for _, tt := range tests {
+		got, _ := rs.Match(tt.host, tt.proto, tt.port)
+		assert.Equal(t, tt.want, got, tt.host.String())
+
+		// Same result as evaluating the rules one by one
+		host := tt.host.Host()
+		host.normalize()
+		for _, rule := range crs.Rules {
+			if rule.Match(host, tt.proto, tt.port) {
+				assert.Equal(t, rule.Outbound, got, tt.host.String())
+				break
+			}
+		}
+	}
+}
+
+func TestCompile_WildcardIndex(t *testing.T) {
+	outbounds := map[string]string{"direct": "DIRECT", "proxy": "PROXY", "reject": "REJECT"}
+	rules, err := ParseTextRules(`
+reject(api*.example.*, tcp/443)
+direct(*.example.com)
+proxy(www.*.org)
+reject(*a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*a*b)
+proxy(*.中*)
+direct(10.0.0.0/8)
+reject(all)
+`)
+	require.NoError(t, err)
+	rs, err := Compile(rules, outbounds, 100, &NilGeoLoader{})
+	require.NoError(t, err)
+	crs := rs.(*compiledRuleSetImpl[string])
+	require.NotNil(t, crs.index)
+	require.NotNil(t, crs.index.wildcards)
+	assert.Equal(t, []int{6}, crs.index.others)
+
+	tests := []struct {
+		host  HostInfo
+		proto Protocol
+		port  uint16
+		want  string
+	}{
+		{HostInfo{Name: "api2.example.com"}, ProtocolTCP, 443, "REJECT"},
+		{HostInfo{Name: "api2.example.com"}, ProtocolTCP, 80, "DIRECT"},
+		{HostInfo{Name: "API.Example.net"}, ProtocolTCP, 443, "REJECT"},
+		{HostInfo{Name: "www.wikipedia.org"}, ProtocolTCP, 443, "PROXY"},
+		{HostInfo{Name: strings.Repeat("a", 200) + "b"}, ProtocolTCP, 443, "REJECT"},
+		{HostInfo{Name: strings.Repeat("a", 200)}, ProtocolTCP, 443, "REJECT"},
+		{HostInfo{Name: "www.xn--fiqs8s"}, ProtocolTCP, 443, "PROXY"},
+		{HostInfo{Name: "www.xn--fiqs8s", IPv4: net.ParseIP("10.1.2.3")}, ProtocolTCP, 443, "PROXY"},
+		{HostInfo{Name: "example.net", IPv4: net.ParseIP("10.1.2.3")}, ProtocolTCP, 443, "DIRECT"},
+		{HostInfo{IPv4: net.ParseIP("1.1.1.1")}, ProtocolTCP, 443, "REJECT"},
+	}
+	for _, tt := range tests {
+		got, _ := rs.Match(tt.host, tt.proto, tt.port)
+		assert.Equal(t, tt.want, got, tt.host.String())
+
+		// Same result as evaluating the rules one by one
+		host := tt.host.Host()
+		host.normalize()
+		for _, rule := range crs.Rules {
+			if rule.Match(host, tt.proto, tt.port) {
+				assert.Equal(t, rule.Outbound, got, tt.host.String())
+				break
+			}
+		}
+	}
+}
+
+func TestDomainMatcher_indexEntry(t *testing.T) {
+	tests := []struct {
+		m      domainMatcher
+		want   string
+		suffix bool
+		ok     bool
+	}{
+		{domainMatcher{"example.com", domainMatchExact}, "example.com", false, true},
+		{domainMatcher{"example.com", domainMatchSuffix}, "example.com", true, true},
+		{domainMatcher{".example.com", domainMatchSuffix}, "", false, false},
+		{domainMatcher{"*.example.com", domainMatchWildcard}, ".example.com", true, true},
+		{domainMatcher{"*example.com", domainMatchWildcard}, "", false, false},
+		{domainMatcher{"*.*.example.com", domainMatchWildcard}, "", false, false},
+		{domainMatcher{"www.*.com", domainMatchWildcard}, "", false, false},
+	}
+	for _, tt := range tests {
+		e, ok := tt.m.indexEntry()
+		assert.Equal(t, tt.ok, ok, tt.m.Pattern)
+		assert.Equal(t, tt.want, e.Domain, tt.m.Pattern)
+		assert.Equal(t, tt.suffix, e.Suffix, tt.m.Pattern)
+	}
+}
+
+func BenchmarkCompiledRuleSet_DomainRules(b *testing.B) {
+	var sb strings.Builder
+	for i := range 20000 {
+		fmt.Fprintf(&sb, "proxy(suffix:host%d.example%c.com)\n", i, 'a'+i%26)
+		if i%1000 == 0 {
+			fmt.Fprintf(&sb, "direct(10.%d.0.0/16)\n", i/1000)
+		}
+	}
+	sb.WriteString("direct(all)\n")
+	rules, err := ParseTextRules(sb.String())
+	require.NoError(b, err)
+	rs, err := Compile(rules, map[string]string{"direct": "DIRECT", "proxy": "PROXY"}, 1, &NilGeoLoader{})
+	require.NoError(b, err)
+	hosts := make([]HostInfo, 1024)
+	for i := range hosts {
+		hosts[i] = HostInfo{Name: fmt.Sprintf("www%d.not-listed.example.com", i)}
+	}
+	b.ResetTimer()
+	i := 0
+	for b.Loop() {
+		rs.Match(hosts[i%len(hosts)], ProtocolTCP, 443)
+		i++
+	}
+}
+
+func BenchmarkCompiledRuleSet_WildcardRules(b *testing.B) {
+	var sb strings.Builder
+	for i := range 1000 {
+		fmt.Fprintf(&sb, "proxy(*host%d*.example%c.com)\n", i, 'a'+i%26)
+	}
+	sb.WriteString("direct(all)\n")
+	rules, err := ParseTextRules(sb.String())
+	require.NoError(b, err)
+	rs, err := Compile(rules, map[string]string{"direct": "DIRECT", "proxy": "PROXY"}, 1, &NilGeoLoader{})
+	require.NoError(b, err)
+	hosts := make([]HostInfo, 1024)
+	for i := range hosts {
+		hosts[i] = HostInfo{Name: fmt.Sprintf("www%d.not-listed.example.com", i)}
+	}
+	b.ResetTimer()
+	i := 0
+	for b.Loop() {
+		rs.Match(hosts[i%len(hosts)], ProtocolTCP, 443)
+		i++
+	}
+}
+
+func BenchmarkCompiledRuleSet_IPRules(b *testing.B) {
+	var sb strings.Builder
+	for i := range 20000 {
+		fmt.Fprintf(&sb, "proxy(%d.%d.%d.0/24)\n", 11+i%200, i/256%256, i%256)
+	}
+	sb.WriteString("direct(all)\n")
+	rules, err := ParseTextRules(sb.String())
+	require.NoError(b, err)
+	rs, err := Compile(rules, map[string]string{"direct": "DIRECT", "proxy": "PROXY"}, 1, &NilGeoLoader{})
+	require.NoError(b, err)
+	hosts := make([]HostInfo, 1024)
+	for i := range hosts {
+		hosts[i] = HostInfo{IPv4: net.IPv4(100, 64, byte(i/256), byte(i))}
+	}
+	b.ResetTimer()
+	i := 0
+	for b.Loop() {
+		rs.Match(hosts[i%len(hosts)], ProtocolTCP, 443)
+		i++
+	}
+}
@@ -0,0 +1,230 @@
+// Package iptree implements a compressed binary prefix tree (a Patricia tree)
+// of IPv4 and IPv6 prefixes, to find the prefixes containing an address in
+// time proportional to the prefix length instead of the number of prefixes.
+package iptree
+
+import (
+	"iter"
+	"net"
+	"net/netip"
+	"slices"
+)
+
+// Tree is a prefix tree of IP prefixes, each with one or more IDs, such as the
+// indexes of the rules they belong to. The zero value is an empty tree.
+//
+// IPv4-mapped IPv6 addresses and prefixes are treated as IPv4.
+type Tree struct {
+	root4, root6 *node
+	len          int
+}
+
+// node is a prefix of the tree. Nodes without IDs only join the subtrees of
+// their children.
+type node struct {
+	prefix   netip.Prefix // masked
+	ids      []int        // ascending
+	children [2]*node     // by the bit after the prefix
+}
+
+// Insert adds prefix to the tree with the given ID. The host bits of prefix
+// are ignored. Invalid prefixes are ignored.
+func (t *Tree) Insert(prefix netip.Prefix, id int) {
+	prefix = Normalize(prefix)
+	if !prefix.IsValid() {
+		return
+	}
+	n := &t.root6
+	if prefix.Addr().Is4() {
+		n = &t.root4
+	}
+	for {
+		cur := *n
+		if cur == nil {
+			*n = &node{prefix: prefix, ids: []int{id}}
+			t.len++
+			return
+		}
+		common := commonBits(cur.prefix, prefix)
+		switch {
+		case common == cur.prefix.Bits() && common == prefix.Bits():
+			// Same prefix
+			if i, found := slices.BinarySearch(cur.ids, id); !found {
+				if len(cur.ids) == 0 {
+					t.len++
+				}
+				cur.ids = slices.Insert(cur.ids, i, id)
+			}
+			return
+		case common == cur.prefix.Bits():
+			// cur contains prefix
+			n = &cur.children[bit(prefix.Addr(), common)]
+		case common == prefix.Bits():
+			// prefix contains cur
+			parent := &node{prefix: prefix, ids: []int{id}}
+			parent.children[bit(cur.prefix.Addr(), common)] = cur
+			*n = parent
+			t.len++
+			return
+		default:
+			// They differ after common bits
+			fork := &node{prefix: netip.PrefixFrom(prefix.Addr(), common).Masked()}
+			fork.children[bit(prefix.Addr(), common)] = &node{prefix: prefix, ids: []int{id}}
+			fork.children[bit(cur.prefix.Addr(), common)] = cur
+			*n = fork
+			t.len++
+			return
+		}
+	}
+}
+
+// InsertIPNet is like Insert, for a net.IPNet.
+func (t *Tree) InsertIPNet(n *net.IPNet, id int) {
+	t.Insert(PrefixFromIPNet(n), id)
+}
+
+// Len returns the number of distinct prefixes in the tree.
+func (t *Tree) Len() int {
+	return t.len
+}
+
+// Contains reports whether addr is in any prefix of the tree.
+func (t *Tree) Contains(addr netip.Addr) bool {
+	_, ok := t.Lookup(addr)
+	return ok
+}
+
+// Lookup returns the longest prefix of the tree containing addr.
+func (t *Tree) Lookup(addr netip.Addr) (netip.Prefix, bool) {
+	var found netip.Prefix
+	ok := false
+	t.walk(addr, func(n *node) {
+		found, ok = n.prefix, true
+	})
+	return found, ok
+}
+
+// AppendMatches appends the IDs of all the prefixes containing addr to dst,
+// in ascending order and without duplicates, and returns the extended slice.
+func (t *Tree) AppendMatches(dst []int, addr netip.Addr) []int {
+	start, nodes := len(dst), 0
+	t.walk(addr, func(n *node) {
+		dst = append(dst, n.ids...)
+		nodes++
+	})
+	if nodes > 1 {
+		slices.Sort(dst[start:])
+		dst = append(dst[:start], slices.Compact(dst[start:])...)
+	}
+	return dst
+}
+
+// All returns the prefixes of the tree and their IDs, IPv4 prefixes first,
+// each in address order with shorter prefixes first.
+func (t *Tree) All() iter.Seq2[netip.Prefix, []int] {
+	return func(yield func(netip.Prefix, []int) bool) {
+		_ = all(t.root4, yield) && all(t.root6, yield)
+	}
+}
+
+func all(n *node, yield func(netip.Prefix, []int) bool) bool {
+	if n == nil {
+		return true
+	}
+	if len(n.ids) > 0 && !yield(n.prefix, n.ids) {
+		return false
+	}
+	return all(n.children[0], yield) && all(n.children[1], yield)
+}
+
+// walk calls fn for each prefix containing addr, from the shortest to the
+// longest.
+func (t *Tree) walk(addr netip.Addr, fn func(*node)) {
+	if !addr.IsValid() {
+		return
+	}
+	addr = addr.Unmap()
+	n := t.root6
+	if addr.Is4() {
+		n = t.root4
+	}
+	for n != nil && n.prefix.Contains(addr) {
+		if len(n.ids) > 0 {
+			fn(n)
+		}
+		if n.prefix.Bits() == addr.BitLen() {
+			return
+		}
+		n = n.children[bit(addr, n.prefix.Bits())]
+	}
+}
+
+// PrefixFromIPNet converts n to a netip.Prefix. It returns an invalid prefix
+// if n is nil or invalid.
+func PrefixFromIPNet(n *net.IPNet) netip.Prefix {
+	if n == nil {
+		return netip.Prefix{}
+	}
+	addr, ok := netip.AddrFromSlice(n.IP)
+	if !ok {
+		return netip.Prefix{}
+	}
+	ones, bits := n.Mask.Size()
+	if bits == 0 {
+		return netip.Prefix{}
+	}
+	if addr.Is4() && bits == 128 {
+		ones -= 96
+	} else if !addr.Is4() && bits == 32 {
+		ones += 96
+	}
+	return netip.PrefixFrom(addr, ones)
+}
+
+// AddrFromIP converts ip to a netip.Addr, with IPv4-mapped IPv6 addresses
+// converted to IPv4. It returns an invalid address if ip is nil or invalid.
+func AddrFromIP(ip net.IP) netip.Addr {
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr.Unmap()
+}
+
+// Normalize masks prefix, and converts an IPv4-mapped IPv6 prefix to IPv4 as
+// Tree does. It returns an invalid prefix if prefix is invalid.
+func Normalize(prefix netip.Prefix) netip.Prefix {
+	if !prefix.IsValid() {
+		return netip.Prefix{}
+	}
+	addr, bits := prefix.Addr(), prefix.Bits()
+	if addr.Is4In6() && bits >= 96 {
+		addr, bits = addr.Unmap(), bits-96
+	}
+	// A shorter IPv4-mapped prefix contains more than IPv4-mapped addresses
+	return netip.PrefixFrom(addr, bits).Masked()
+}
+
+// commonBits returns the number of leading bits that a and b have in common,
+// at most the length of the shorter prefix. Both have the same address family.
+func commonBits(a, b netip.Prefix) int {
+	n := min(a.Bits(), b.Bits())
+	x, y := a.Addr().As16(), b.Addr().As16()
+	offset := 0
+	if a.Addr().Is4() {
+		offset = 96
+	}
+	for i := 0; i < n; i++ {
+		j := offset + i
+		if (x[j/8]^y[j/8])>>(7-j%8)&1 != 0 {
+			return i
+		}
+	}
+	return n
+}
+
+// bit returns bit i of addr, counting from the most significant bit.
+func bit(addr netip.Addr, i int) int {
+	if addr.Is4() {
+		i += 96
+	}
+	b := addr.As16()
+	return int(b[i/8] >> (7 - i%8) & 1)
+}
@@ -0,0 +1,191 @@
+package iptree
+
+import (
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func newTestTree(prefixes ...string) *Tree {
+	t := &Tree{}
+	for i, p := range prefixes {
+		t.Insert(netip.MustParsePrefix(p), i)
+	}
+	return t
+}
+
+func TestTree_AppendMatches(t *testing.T) {
+	tree := newTestTree(
+		"10.0.0.0/8",      // 0
+		"10.1.0.0/16",     // 1
+		"10.1.2.0/24",     // 2
+		"10.1.2.3/32",     // 3
+		"10.128.0.0/9",    // 4
+		"0.0.0.0/0",       // 5
+		"2001:db8::/32",   // 6
+		"2001:db8:1::/48", // 7
+		"10.1.2.99/16",    // 8, host bits are ignored
+		"::ffff:1.2.3.0/120",
+	)
+	tree.Insert(netip.MustParsePrefix("10.1.0.0/16"), 1) // duplicate
+
+	tests := []struct {
+		addr string
+		want []int
+	}{
+		{"10.1.2.3", []int{0, 1, 2, 3, 5, 8}},
+		{"10.1.2.4", []int{0, 1, 2, 5, 8}},
+		{"10.1.3.1", []int{0, 1, 5, 8}},
+		{"10.200.0.1", []int{0, 4, 5}},
+		{"11.0.0.1", []int{5}},
+		{"::ffff:10.1.3.1", []int{0, 1, 5, 8}},
+		{"1.2.3.4", []int{5, 9}},
+		{"2001:db8:1::1", []int{6, 7}},
+		{"2001:db8:2::1", []int{6}},
+		{"2001:db9::1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			got := tree.AppendMatches(nil, netip.MustParseAddr(tt.addr))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("AppendMatches(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+	if got := tree.AppendMatches(nil, netip.Addr{}); got != nil {
+		t.Errorf("AppendMatches(invalid) = %v", got)
+	}
+	if got := tree.Len(); got != 9 {
+		t.Errorf("Len() = %d, want 9", got)
+	}
+}
+
+func TestTree_Lookup(t *testing.T) {
+	tree := newTestTree("10.0.0.0/8", "10.1.0.0/16", "2001:db8::/32")
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"10.1.2.3", "10.1.0.0/16"},
+		{"10.2.0.1", "10.0.0.0/8"},
+		{"2001:db8::1", "2001:db8::/32"},
+		{"192.168.1.1", ""},
+	}
+	for _, tt := range tests {
+		p, ok := tree.Lookup(netip.MustParseAddr(tt.addr))
+		if tt.want == "" {
+			if ok {
+				t.Errorf("Lookup(%s) = %s, want none", tt.addr, p)
+			}
+			continue
+		}
+		if !ok || p.String() != tt.want {
+			t.Errorf("Lookup(%s) = %s, %v, want %s", tt.addr, p, ok, tt.want)
+		}
+	}
+	if (&Tree{}).Contains(netip.MustParseAddr("10.0.0.1")) {
+		t.Error("empty tree contains 10.0.0.1")
+	}
+}
+
+func TestTree_All(t *testing.T) {
+	tree := newTestTree("2001:db8::/32", "10.1.0.0/16", "10.0.0.0/8", "10.1.0.0/16", "192.168.0.0/16")
+	var got []string
+	for p, ids := range tree.All() {
+		got = append(got, p.String())
+		if p.String() == "10.1.0.0/16" && !slices.Equal(ids, []int{1, 3}) {
+			t.Errorf("IDs of %s = %v", p, ids)
+		}
+	}
+	want := []string{"10.0.0.0/8", "10.1.0.0/16", "192.168.0.0/16", "2001:db8::/32"}
+	if !slices.Equal(got, want) {
+		t.Errorf("All() = %v, want %v", got, want)
+	}
+}
+
+func TestTree_Overlapping(t *testing.T) {
+	// Compare with checking every prefix, for random overlapping prefixes
+	r := rand.New(rand.NewPCG(1, 2))
+	var prefixes []netip.Prefix
+	tree := &Tree{}
+	for i := range 2000 {
+		var b [4]byte
+		b[0] = byte(r.IntN(4))
+		b[1], b[2], b[3] = byte(r.Uint32()), byte(r.Uint32()), byte(r.Uint32())
+		p := netip.PrefixFrom(netip.AddrFrom4(b), 4+r.IntN(29)).Masked()
+		prefixes = append(prefixes, p)
+		tree.Insert(p, i)
+	}
+	for range 2000 {
+		var b [4]byte
+		b[0] = byte(r.IntN(4))
+		b[1], b[2], b[3] = byte(r.Uint32()), byte(r.Uint32()), byte(r.Uint32())
+		addr := netip.AddrFrom4(b)
+		var want []int
+		for i, p := range prefixes {
+			if p.Contains(addr) {
+				want = append(want, i)
+			}
+		}
+		if got := tree.AppendMatches(nil, addr); !slices.Equal(got, want) {
+			t.Fatalf("AppendMatches(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestPrefixFromIPNet(t *testing.T) {
+	tests := []struct {
+		n    *net.IPNet
+		want string
+	}{
+		{&net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)}, "10.0.0.0/8"},
+		{&net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(104, 128)}, "10.0.0.0/8"},
+		{&net.IPNet{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(8, 32)}, "::ffff:10.0.0.0/104"},
+		{&net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(32, 128)}, "2001:db8::/32"},
+		{&net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.IPMask{255, 0, 255, 0}}, "invalid Prefix"},
+		{nil, "invalid Prefix"},
+	}
+	for _, tt := range tests {
+		if got := PrefixFromIPNet(tt.n).String(); got != tt.want {
+			t.Errorf("PrefixFromIPNet(%v) = %s, want %s", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"10.1.2.3/8", "10.0.0.0/8"},
+		{"::ffff:10.1.2.3/104", "10.0.0.0/8"},
+		{"::ffff:0:0/80", "::/80"},
+		{"2001:db8::1/32", "2001:db8::/32"},
+	}
+	for _, tt := range tests {
+		if got := Normalize(netip.MustParsePrefix(tt.prefix)).String(); got != tt.want {
+			t.Errorf("Normalize(%s) = %s, want %s", tt.prefix, got, tt.want)
+		}
+	}
+	if Normalize(netip.Prefix{}).IsValid() {
+		t.Error("Normalize(invalid) is valid")
+	}
+}
+
+func BenchmarkTree_AppendMatches(b *testing.B) {
+	r := rand.New(rand.NewPCG(1, 2))
+	tree := &Tree{}
+	for i := range 20000 {
+		p := netip.PrefixFrom(netip.AddrFrom4([4]byte{byte(r.Uint32()), byte(r.Uint32()), byte(r.Uint32()), 0}), 16+r.IntN(9))
+		tree.Insert(p, i)
+	}
+	addr := netip.MustParseAddr("100.64.1.1")
+	var dst []int
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dst = tree.AppendMatches(dst[:0], addr)
+	}
+}
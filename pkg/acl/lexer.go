@@ -0,0 +1,425 @@
+package acl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Position describes a location in ACL source text.
+// Both Line and Column are 1-based; Column counts bytes, like go/scanner.
+type Position struct {
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// IsValid reports whether the position refers to an actual location.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// offset returns the position n bytes further on the same line,
+// or the zero Position if p is not valid.
+func (p Position) offset(n int) Position {
+	if !p.IsValid() {
+		return Position{}
+	}
+	return Position{p.Line, p.Column + n}
+}
+
+// scanner is a small hand-written lexer for the ACL rule language.
+// It tracks the line and column of every byte it consumes, and understands
+// quoting, backslash escapes and backslash-newline line continuations.
+type scanner struct {
+	src       string
+	off       int
+	line      int
+	col       int
+	startLine int
+}
+
+func newScanner(src string) *scanner {
+	return &scanner{src: src, line: 1, col: 1, startLine: 1}
+}
+
+// newScannerAt creates a scanner for a fragment of a larger source text,
+// so that the positions it reports refer to the original text.
+func newScannerAt(src string, pos Position) *scanner {
+	if !pos.IsValid() {
+		pos = Position{1, 1}
+	}
+	return &scanner{src: src, line: pos.Line, col: pos.Column, startLine: pos.Line}
+}
+
+func (s *scanner) pos() Position {
+	return Position{s.line, s.col}
+}
+
+func (s *scanner) eof() bool {
+	return s.off >= len(s.src)
+}
+
+// peek returns the next byte without consuming it, or 0 at EOF.
+func (s *scanner) peek() byte {
+	if s.off >= len(s.src) {
+		return 0
+	}
+	return s.src[s.off]
+}
+
+// next consumes and returns the next byte, or 0 at EOF.
+func (s *scanner) next() byte {
+	if s.off >= len(s.src) {
+		return 0
+	}
+	c := s.src[s.off]
+	s.off++
+	if c == '\n' {
+		s.line++
+		s.col = 1
+	} else {
+		s.col++
+	}
+	return c
+}
+
+func (s *scanner) accept(c byte) bool {
+	if s.peek() == c {
+		s.next()
+		return true
+	}
+	return false
+}
+
+// continuation reports whether the scanner is positioned at a
+// backslash-newline sequence (optionally with a CR before the newline).
+func (s *scanner) continuation() bool {
+	rest := s.src[s.off:]
+	return strings.HasPrefix(rest, "\\\n") || strings.HasPrefix(rest, "\\\r\n")
+}
+
+// skipContinuation consumes a backslash-newline sequence if there is one.
+func (s *scanner) skipContinuation() bool {
+	if !s.continuation() {
+		return false
+	}
+	for s.next() != '\n' {
+	}
+	return true
+}
+
+// copyContinuation copies a backslash-newline sequence verbatim into b, if
+// there is one. It is used for text that is kept raw to be scanned again, so
+// that positions reported by the second scan stay accurate.
+func (s *scanner) copyContinuation(b *strings.Builder) bool {
+	if !s.continuation() {
+		return false
+	}
+	for {
+		c := s.next()
+		b.WriteByte(c)
+		if c == '\n' {
+			return true
+		}
+	}
+}
+
+// skipSpace skips spaces, tabs and line continuations, but not newlines.
+func (s *scanner) skipSpace() {
+	for {
+		switch c := s.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			s.next()
+		case c == '\\' && s.continuation():
+			s.skipContinuation()
+		default:
+			return
+		}
+	}
+}
+
+// skipComment skips a comment up to (but not including) the end of the line.
+func (s *scanner) skipComment() {
+	if s.peek() != '#' {
+		return
+	}
+	for !s.eof() && s.peek() != '\n' {
+		s.next()
+	}
+}
+
+// scanComment skips a comment like skipComment, and returns its text
+// (including '#', without trailing whitespace), or "" if there is none.
+func (s *scanner) scanComment() string {
+	start := s.off
+	s.skipComment()
+	return strings.TrimRight(s.src[start:s.off], " \t\r")
+}
+
+// scanBlank is like skipBlank, but returns the comments it skipped, with an
+// empty string for each empty line.
+func (s *scanner) scanBlank() []string {
+	var lines []string
+	empty := true // no comment on the current line so far
+	for {
+		s.skipSpace()
+		switch s.peek() {
+		case '\n':
+			if empty {
+				lines = append(lines, "")
+			}
+			empty = true
+			s.next()
+		case '#':
+			lines = append(lines, s.scanComment())
+			empty = false
+		default:
+			return lines
+		}
+	}
+}
+
+// skipBlank skips whitespace, newlines and comments between rules.
+func (s *scanner) skipBlank() {
+	for {
+		s.skipSpace()
+		switch s.peek() {
+		case '\n':
+			s.next()
+		case '#':
+			s.skipComment()
+		default:
+			return
+		}
+	}
+}
+
+// skipRule skips the rest of the current rule (including continued lines)
+// after a syntax error, so that parsing can resume at the next line.
+func (s *scanner) skipRule() {
+	for !s.eof() {
+		if s.skipContinuation() {
+			continue
+		}
+		if s.next() == '\n' {
+			return
+		}
+	}
+}
+
+// atLineEnd reports whether only an optional comment remains on the current line.
+func (s *scanner) atLineEnd() bool {
+	c := s.peek()
+	return c == 0 || c == '\n' || c == '#'
+}
+
+func isIdentByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '-' || c == '.'
+}
+
+// scanIdent scans an outbound name. Names consist of ASCII letters, digits,
+// underscores, hyphens and dots, and must not start with a hyphen or a dot.
+func (s *scanner) scanIdent() string {
+	start := s.off
+	if c := s.peek(); c == '-' || c == '.' {
+		return ""
+	}
+	for isIdentByte(s.peek()) {
+		s.next()
+	}
+	return s.src[start:s.off]
+}
+
+// isEscapable reports whether c is a character that a backslash escapes.
+// A backslash before any other character is kept, so that regular
+// expressions such as "regexp:^api\.example\.com$" can be written as-is.
+func isEscapable(c byte) bool {
+	switch c {
+	case '\\', '"', ',', '(', ')', '#':
+		return true
+	default:
+		return false
+	}
+}
+
+// keepsEscapes reports whether an argument starting with prefix keeps all of
+// its backslashes: a "regexp:" address, possibly negated. A backslash still
+// stops the next character from ending the argument or the quoted string, but
+// "regexp:^a\(b\)$" is passed to the regular expression as written, since an
+// escaped punctuation character means the character itself there too.
+func keepsEscapes(prefix string) bool {
+	const regexp = "regexp:"
+	prefix = strings.TrimLeft(prefix, "! \t")
+	return len(prefix) >= len(regexp) && strings.EqualFold(prefix[:len(regexp)], regexp)
+}
+
+// textArg is a single argument of a rule, with the position of its first character.
+type textArg struct {
+	Value string
+	Pos   Position
+}
+
+// scanArgs scans a comma-separated argument list up to and including the
+// closing parenthesis. The opening parenthesis must already be consumed.
+//
+// At the top level, quotes are removed and escapes are resolved. Inside nested
+// parentheses (composite expressions) the text is kept verbatim, including line
+// continuations, so that it can be scanned again later with the same rules.
+func (s *scanner) scanArgs() ([]textArg, *InvalidSyntaxError) {
+	var args []textArg
+	for {
+		arg, err := s.scanArg()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		switch s.peek() {
+		case ',':
+			s.next()
+		case ')':
+			s.next()
+			return args, nil
+		default:
+			return nil, s.errorf(s.pos(), "missing ')'")
+		}
+	}
+}
+
+// scanList scans a comma-separated list of arguments up to the end of the line,
+// as used by address set definitions. Arguments are scanned like in scanArgs.
+func (s *scanner) scanList() ([]textArg, *InvalidSyntaxError) {
+	var args []textArg
+	for {
+		arg, err := s.scanArg()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		switch s.peek() {
+		case ',':
+			s.next()
+		case ')':
+			return nil, s.errorf(s.pos(), "unexpected ')'")
+		default:
+			return args, nil
+		}
+	}
+}
+
+// scanArg scans a single argument, stopping before a top-level ',' or ')',
+// or at the end of the line. Unquoted leading and trailing whitespace is trimmed.
+func (s *scanner) scanArg() (textArg, *InvalidSyntaxError) {
+	s.skipSpace()
+	arg := textArg{Pos: s.pos()}
+	var b strings.Builder
+	end := 0 // length of b up to the last significant (non-trailing-space) byte
+	depth := 0
+	for {
+		c := s.peek()
+		switch {
+		case c == 0 || c == '\n' || c == '#':
+			if depth > 0 {
+				return arg, s.errorf(s.pos(), "missing ')'")
+			}
+			arg.Value = b.String()[:end]
+			return arg, nil
+		case depth == 0 && (c == ',' || c == ')'):
+			arg.Value = b.String()[:end]
+			return arg, nil
+		case c == '\\':
+			if depth > 0 && s.copyContinuation(&b) {
+				continue
+			}
+			if s.skipContinuation() {
+				continue
+			}
+			s.next()
+			if s.eof() {
+				return arg, s.errorf(s.pos(), "unexpected end of input after '\\'")
+			}
+			if depth > 0 || !isEscapable(s.peek()) || keepsEscapes(b.String()) {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(s.next())
+			end = b.Len()
+		case c == '"':
+			if err := s.scanQuoted(&b, depth > 0); err != nil {
+				return arg, err
+			}
+			end = b.Len()
+		case c == ' ' || c == '\t' || c == '\r':
+			b.WriteByte(s.next())
+		default:
+			if c == '(' {
+				depth++
+			} else if c == ')' {
+				depth--
+			}
+			b.WriteByte(s.next())
+			end = b.Len()
+		}
+	}
+}
+
+// scanQuoted scans a double-quoted string. If raw is true, the quotes and
+// escapes are copied verbatim, otherwise only the unescaped content is written
+// (with the backslashes of a "regexp:" address, see keepsEscapes).
+func (s *scanner) scanQuoted(b *strings.Builder, raw bool) *InvalidSyntaxError {
+	start := s.pos()
+	s.next() // opening quote
+	if raw {
+		b.WriteByte('"')
+	}
+	for {
+		switch c := s.peek(); c {
+		case 0, '\n':
+			return s.errorf(start, "unterminated quoted string")
+		case '"':
+			s.next()
+			if raw {
+				b.WriteByte('"')
+			}
+			return nil
+		case '\\':
+			if raw && s.copyContinuation(b) {
+				continue
+			}
+			if s.skipContinuation() {
+				continue
+			}
+			s.next()
+			if s.eof() {
+				return s.errorf(start, "unterminated quoted string")
+			}
+			if raw || !isEscapable(s.peek()) || keepsEscapes(b.String()) {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(s.next())
+		default:
+			b.WriteByte(s.next())
+		}
+	}
+}
+
+// errorf returns an InvalidSyntaxError for the given position.
+func (s *scanner) errorf(pos Position, format string, args ...any) *InvalidSyntaxError {
+	return &InvalidSyntaxError{
+		Line:    sourceLine(s.src, pos.Line-s.startLine),
+		LineNum: pos.Line,
+		Column:  pos.Column,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// sourceLine returns the trimmed text of the n-th (0-based) line of src.
+func sourceLine(src string, n int) string {
+	lines := strings.Split(src, "\n")
+	if n < 0 || n >= len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[n])
+}
@@ -1,10 +1,10 @@
 package acl
 
 import (
-	"net"
+	"net/netip"
 	"strings"
 
-	"golang.org/x/net/idna"
+	"github.com/xflash-panda/acl-engine/pkg/acl/domain"
 )
 
 const (
@@ -14,23 +14,23 @@ const (
 )
 
 type hostMatcher interface {
-	Match(HostInfo) bool
+	Match(Host) bool
 }
 
 type ipMatcher struct {
-	IP net.IP
+	IP netip.Addr // IPv4-mapped IPv6 addresses are converted to IPv4
 }
 
-func (m *ipMatcher) Match(host HostInfo) bool {
-	return m.IP.Equal(host.IPv4) || m.IP.Equal(host.IPv6)
+func (m *ipMatcher) Match(host Host) bool {
+	return host.IPv4 == m.IP || host.IPv6 == m.IP
 }
 
 type cidrMatcher struct {
-	IPNet *net.IPNet
+	Prefix netip.Prefix // masked, normalized with iptree.Normalize
 }
 
-func (m *cidrMatcher) Match(host HostInfo) bool {
-	return m.IPNet.Contains(host.IPv4) || m.IPNet.Contains(host.IPv6)
+func (m *cidrMatcher) Match(host Host) bool {
+	return m.Prefix.Contains(host.IPv4) || m.Prefix.Contains(host.IPv6)
 }
 
 type domainMatcher struct {
@@ -38,16 +38,13 @@ type domainMatcher struct {
 	Mode    uint8
 }
 
-func (m *domainMatcher) Match(host HostInfo) bool {
-	name, err := idna.ToUnicode(host.Name)
-	if err != nil {
-		name = host.Name
-	}
+func (m *domainMatcher) Match(host Host) bool {
+	name := host.domainName()
 	switch m.Mode {
 	case domainMatchExact:
 		return name == m.Pattern
 	case domainMatchWildcard:
-		return deepMatchRune([]rune(name), []rune(m.Pattern))
+		return domain.MatchWildcard(m.Pattern, name)
 	case domainMatchSuffix:
 		return name == m.Pattern || strings.HasSuffix(name, "."+m.Pattern)
 	default:
@@ -55,25 +52,72 @@ func (m *domainMatcher) Match(host HostInfo) bool {
 	}
 }
 
-func deepMatchRune(str, pattern []rune) bool {
-	for len(pattern) > 0 {
-		switch pattern[0] {
-		default:
-			if len(str) == 0 || str[0] != pattern[0] {
-				return false
-			}
-		case '*':
-			return deepMatchRune(str, pattern[1:]) ||
-				(len(str) > 0 && deepMatchRune(str[1:], pattern))
+type allMatcher struct{}
+
+func (m *allMatcher) Match(host Host) bool {
+	return true
+}
+
+// inverseMatcher matches any host that its inner matcher does not match.
+type inverseMatcher struct {
+	Matcher hostMatcher
+}
+
+func (m *inverseMatcher) Match(host Host) bool {
+	return !m.Matcher.Match(host)
+}
+
+// andMatcher matches a host if all of its matchers match.
+type andMatcher struct {
+	Matchers []hostMatcher
+}
+
+func (m *andMatcher) Match(host Host) bool {
+	for _, matcher := range m.Matchers {
+		if !matcher.Match(host) {
+			return false
 		}
-		str = str[1:]
-		pattern = pattern[1:]
 	}
-	return len(str) == 0 && len(pattern) == 0
+	return true
 }
 
-type allMatcher struct{}
+// orMatcher matches a host if any of its matchers matches.
+type orMatcher struct {
+	Matchers []hostMatcher
+}
 
-func (m *allMatcher) Match(host HostInfo) bool {
-	return true
+func (m *orMatcher) Match(host Host) bool {
+	for _, matcher := range m.Matchers {
+		if matcher.Match(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// srcMatcher matches the source address of the session ("src:10.1.0.0/16").
+type srcMatcher struct {
+	Prefix netip.Prefix
+}
+
+func (m *srcMatcher) Match(host Host) bool {
+	return m.Prefix.Contains(host.SrcIP)
+}
+
+// inboundMatcher matches the inbound tag of the session, case-insensitively ("inbound:office").
+type inboundMatcher struct {
+	Tag string
+}
+
+func (m *inboundMatcher) Match(host Host) bool {
+	return strings.EqualFold(host.Inbound, m.Tag)
+}
+
+// userMatcher matches the authenticated user of the session ("user:alice").
+type userMatcher struct {
+	User string
+}
+
+func (m *userMatcher) Match(host Host) bool {
+	return host.User == m.User
 }
@@ -1,95 +1,60 @@
 package acl
 
 import (
-	"bytes"
 	"errors"
-	"net"
+	"net/netip"
 	"regexp"
-	"sort"
 	"strings"
 
 	"github.com/xflash-panda/acl-engine/pkg/acl/domain"
 	"github.com/xflash-panda/acl-engine/pkg/acl/geodat"
+	"github.com/xflash-panda/acl-engine/pkg/acl/iptree"
 )
 
 var _ hostMatcher = (*geoipMatcher)(nil)
 
 type geoipMatcher struct {
-	N4      []*net.IPNet // sorted
-	N6      []*net.IPNet // sorted
+	// Nets is a prefix tree of the CIDRs of the list, which may overlap
+	// (all with ID 0).
+	Nets    *iptree.Tree
 	Inverse bool
 }
 
 // matchIP tries to match the given IP address with the corresponding IPNets.
 // Note that this function does NOT handle the Inverse flag.
-func (m *geoipMatcher) matchIP(ip net.IP) bool {
-	var n []*net.IPNet
-	if ip4 := ip.To4(); ip4 != nil {
-		// N4 stores IPv4 addresses in 4-byte form.
-		// Make sure we use it here too, otherwise bytes.Compare will fail.
-		ip = ip4
-		n = m.N4
-	} else {
-		n = m.N6
-	}
-	left, right := 0, len(n)-1
-	for left <= right {
-		mid := (left + right) / 2
-		if n[mid].Contains(ip) {
-			return true
-		} else if bytes.Compare(n[mid].IP, ip) < 0 {
-			left = mid + 1
-		} else {
-			right = mid - 1
-		}
-	}
-	return false
+func (m *geoipMatcher) matchIP(ip netip.Addr) bool {
+	return m.Nets.Contains(ip)
 }
 
-func (m *geoipMatcher) Match(host HostInfo) bool {
-	if host.IPv4 != nil {
-		if m.matchIP(host.IPv4) {
-			return !m.Inverse
-		}
-	}
-	if host.IPv6 != nil {
-		if m.matchIP(host.IPv6) {
-			return !m.Inverse
-		}
+// lookupIP returns the most specific CIDR containing the given IP address.
+// Note that this function does NOT handle the Inverse flag.
+func (m *geoipMatcher) lookupIP(ip netip.Addr) (netip.Prefix, bool) {
+	return m.Nets.Lookup(ip)
+}
+
+func (m *geoipMatcher) Match(host Host) bool {
+	if m.matchIP(host.IPv4) || m.matchIP(host.IPv6) {
+		return !m.Inverse
 	}
 	return m.Inverse
 }
 
 func newGeoIPMatcher(list *geodat.GeoIP) (*geoipMatcher, error) {
-	n4 := make([]*net.IPNet, 0)
-	n6 := make([]*net.IPNet, 0)
+	nets := &iptree.Tree{}
 	for _, cidr := range list.Cidr {
-		if len(cidr.Ip) == 4 {
-			// IPv4
-			n4 = append(n4, &net.IPNet{
-				IP:   cidr.Ip,
-				Mask: net.CIDRMask(int(cidr.Prefix), 32),
-			})
-		} else if len(cidr.Ip) == 16 {
-			// IPv6
-			n6 = append(n6, &net.IPNet{
-				IP:   cidr.Ip,
-				Mask: net.CIDRMask(int(cidr.Prefix), 128),
-			})
-		} else {
+		var addr netip.Addr
+		switch len(cidr.Ip) {
+		case 4:
+			addr = netip.AddrFrom4([4]byte(cidr.Ip))
+		case 16:
+			addr = netip.AddrFrom16([16]byte(cidr.Ip))
+		default:
 			return nil, errors.New("invalid IP length")
 		}
+		nets.Insert(netip.PrefixFrom(addr, int(cidr.Prefix)), 0)
 	}
-	// Sort the IPNets, so we can do binary search later.
-	sort.Slice(n4, func(i, j int) bool {
-		return bytes.Compare(n4[i].IP, n4[j].IP) < 0
-	})
-	sort.Slice(n6, func(i, j int) bool {
-		return bytes.Compare(n6[i].IP, n6[j].IP) < 0
-	})
 	return &geoipMatcher{
-		N4:      n4,
-		N6:      n6,
+		Nets:    nets,
 		Inverse: list.InverseMatch,
 	}, nil
 }
@@ -125,7 +90,7 @@ type geositeMatcher struct {
 	Attrs []string
 }
 
-func (m *geositeMatcher) matchDomainWithAttrs(d geositeDomain, host HostInfo) bool {
+func (m *geositeMatcher) matchDomainWithAttrs(d geositeDomain, host Host) bool {
 	// Match attributes first
 	if len(m.Attrs) > 0 {
 		if len(d.Attrs) == 0 {
@@ -158,7 +123,7 @@ func (m *geositeMatcher) matchDomainWithAttrs(d geositeDomain, host HostInfo) bo
 	return false
 }
 
-func (m *geositeMatcher) Match(host HostInfo) bool {
+func (m *geositeMatcher) Match(host Host) bool {
 	// Fast path: use succinct trie for Full/Root domains without special attribute requirements
 	if m.domainMatcher != nil && m.domainMatcher.Match(host.Name) {
 		return true
@@ -188,6 +153,37 @@ func (m *geositeMatcher) Match(host HostInfo) bool {
 	return false
 }
 
+// explain returns the domain entry matching host, in domain rule syntax
+// (e.g. "domain:google.com"), or "" if none does.
+func (m *geositeMatcher) explain(host Host) string {
+	if m.domainMatcher != nil {
+		if rule, suffix, ok := m.domainMatcher.Lookup(host.Name); ok {
+			if suffix {
+				return "domain:" + rule
+			}
+			return "full:" + rule
+		}
+	}
+	for _, list := range [][]geositeDomain{m.plainDomains, m.regexDomains, m.attrDomains} {
+		for _, d := range list {
+			if !m.matchDomainWithAttrs(d, host) {
+				continue
+			}
+			switch d.Type {
+			case geositeDomainPlain:
+				return "keyword:" + d.Value
+			case geositeDomainRegex:
+				return "regexp:" + d.Regex.String()
+			case geositeDomainFull:
+				return "full:" + d.Value
+			default:
+				return "domain:" + d.Value
+			}
+		}
+	}
+	return ""
+}
+
 func newGeositeMatcher(list *geodat.GeoSite, attrs []string) (*geositeMatcher, error) {
 	// Separate domains by type and attribute requirements
 	var fullDomains []string // For exact matches
@@ -21,7 +21,7 @@ func BenchmarkGeositeMatcher_SmallList(b *testing.B) {
 		b.Fatal(err)
 	}
 
-	host := HostInfo{Name: "sub.examplee.com"} // Middle of list
+	host := Host{Name: "sub.examplee.com"} // Middle of list
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -44,7 +44,7 @@ func BenchmarkGeositeMatcher_MediumList(b *testing.B) {
 		b.Fatal(err)
 	}
 
-	host := HostInfo{Name: "sub.examplema.com"} // Middle of list
+	host := Host{Name: "sub.examplema.com"} // Middle of list
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -70,7 +70,7 @@ func BenchmarkGeositeMatcher_LargeList(b *testing.B) {
 		b.Fatal(err)
 	}
 
-	host := HostInfo{Name: "sub.examplemam.com"} // Middle of list
+	host := Host{Name: "sub.examplemam.com"} // Middle of list
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -96,7 +96,7 @@ func BenchmarkGeositeMatcher_LargeList_Hit(b *testing.B) {
 		b.Fatal(err)
 	}
 
-	host := HostInfo{Name: "sub.exampleaaa.com"} // First in list
+	host := Host{Name: "sub.exampleaaa.com"} // First in list
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -122,7 +122,7 @@ func BenchmarkGeositeMatcher_LargeList_Miss(b *testing.B) {
 		b.Fatal(err)
 	}
 
-	host := HostInfo{Name: "notinlist.com"}
+	host := Host{Name: "notinlist.com"}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -163,7 +163,7 @@ func BenchmarkGeositeMatcher_MixedTypes(b *testing.B) {
 		b.Fatal(err)
 	}
 
-	host := HostInfo{Name: "sub.rootm.com"} // Should hit fast path
+	host := Host{Name: "sub.rootm.com"} // Should hit fast path
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -230,7 +230,7 @@ func BenchmarkGeositeMatcher_MiningPools(b *testing.B) {
 
 	// Test various scenarios
 	b.Run("Hit_Early", func(b *testing.B) {
-		host := HostInfo{Name: "sub.poolaaa.com"}
+		host := Host{Name: "sub.poolaaa.com"}
 		b.ResetTimer()
 		for i := 0; i < b.N; i++ {
 			_ = matcher.Match(host)
@@ -238,7 +238,7 @@ func BenchmarkGeositeMatcher_MiningPools(b *testing.B) {
 	})
 
 	b.Run("Hit_Middle", func(b *testing.B) {
-		host := HostInfo{Name: "sub.poolmam.com"}
+		host := Host{Name: "sub.poolmam.com"}
 		b.ResetTimer()
 		for i := 0; i < b.N; i++ {
 			_ = matcher.Match(host)
@@ -246,7 +246,7 @@ func BenchmarkGeositeMatcher_MiningPools(b *testing.B) {
 	})
 
 	b.Run("Miss", func(b *testing.B) {
-		host := HostInfo{Name: "google.com"}
+		host := Host{Name: "google.com"}
 		b.ResetTimer()
 		for i := 0; i < b.N; i++ {
 			_ = matcher.Match(host)
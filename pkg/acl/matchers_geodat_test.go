@@ -1,6 +1,8 @@
 package acl
 
 import (
+	"net"
+	"net/netip"
 	"testing"
 
 	"github.com/xflash-panda/acl-engine/pkg/acl/geodat"
@@ -117,7 +119,7 @@ func Test_geositeMatcher_matchDomain_RootDomain(t *testing.T) {
 			if err != nil {
 				t.Fatalf("newGeositeMatcher() error = %v", err)
 			}
-			host := HostInfo{Name: tt.host}
+			host := Host{Name: tt.host}
 			if got := m.Match(host); got != tt.want {
 				t.Errorf("Match() = %v, want %v (domainValue=%q, host=%q)",
 					got, tt.want, tt.domainValue, tt.host)
@@ -166,7 +168,7 @@ func Test_geositeMatcher_matchDomain_Full(t *testing.T) {
 			if err != nil {
 				t.Fatalf("newGeositeMatcher() error = %v", err)
 			}
-			host := HostInfo{Name: tt.host}
+			host := Host{Name: tt.host}
 			if got := m.Match(host); got != tt.want {
 				t.Errorf("Match() = %v, want %v", got, tt.want)
 			}
@@ -220,7 +222,7 @@ func Test_geositeMatcher_matchDomain_Plain(t *testing.T) {
 			if err != nil {
 				t.Fatalf("newGeositeMatcher() error = %v", err)
 			}
-			host := HostInfo{Name: tt.host}
+			host := Host{Name: tt.host}
 			if got := m.Match(host); got != tt.want {
 				t.Errorf("Match() = %v, want %v", got, tt.want)
 			}
@@ -269,7 +271,7 @@ func Test_geositeMatcher_matchDomain_Regex(t *testing.T) {
 				t.Fatalf("newGeositeMatcher() error = %v", err)
 			}
 
-			host := HostInfo{Name: tt.host}
+			host := Host{Name: tt.host}
 			if got := matcher.Match(host); got != tt.want {
 				t.Errorf("Match() = %v, want %v", got, tt.want)
 			}
@@ -353,7 +355,7 @@ func Test_geositeMatcher_attributes(t *testing.T) {
 				t.Fatalf("newGeositeMatcher() error = %v", err)
 			}
 
-			host := HostInfo{Name: tt.host}
+			host := Host{Name: tt.host}
 			if got := matcher.Match(host); got != tt.want {
 				t.Errorf("Match() = %v, want %v", got, tt.want)
 			}
@@ -405,7 +407,7 @@ func Test_geositeMatcher_multipleDomains(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.host, func(t *testing.T) {
-			host := HostInfo{Name: tt.host}
+			host := Host{Name: tt.host}
 			if got := matcher.Match(host); got != tt.want {
 				t.Errorf("Match(%q) = %v, want %v", tt.host, got, tt.want)
 			}
@@ -462,7 +464,7 @@ func Test_geositeMatcher_leadingDotBugFix(t *testing.T) {
 			if err != nil {
 				t.Fatalf("newGeositeMatcher() error = %v", err)
 			}
-			host := HostInfo{Name: tt.host}
+			host := Host{Name: tt.host}
 			if got := m.Match(host); got != tt.want {
 				t.Errorf("Match() = %v, want %v (this may indicate the leading dot bug)",
 					got, tt.want)
@@ -470,3 +472,43 @@ func Test_geositeMatcher_leadingDotBugFix(t *testing.T) {
 		})
 	}
 }
+
+func Test_geoipMatcher_overlappingCIDRs(t *testing.T) {
+	// 10.0.0.0/8 sorts before 10.1.0.0/16 and 10.2.0.0/16, which a binary
+	// search by network address would land on for 10.3.0.1
+	m, err := newGeoIPMatcher(&geodat.GeoIP{
+		Cidr: []*geodat.CIDR{
+			{Ip: []byte{10, 1, 0, 0}, Prefix: 16},
+			{Ip: []byte{10, 0, 0, 0}, Prefix: 8},
+			{Ip: []byte{10, 2, 0, 0}, Prefix: 16},
+			{Ip: []byte{10, 4, 0, 0}, Prefix: 16},
+			{Ip: net.ParseIP("2001:db8::"), Prefix: 32},
+			{Ip: net.ParseIP("2001:db8:1::"), Prefix: 48},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.3.0.1", true},
+		{"10.255.0.1", true},
+		{"10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"2001:db8:2::1", true},
+		{"2001:db9::1", false},
+	}
+	for _, tt := range tests {
+		if got := m.matchIP(netip.MustParseAddr(tt.ip)); got != tt.want {
+			t.Errorf("matchIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+	if p, ok := m.lookupIP(netip.MustParseAddr("10.1.2.3")); !ok || p.String() != "10.1.0.0/16" {
+		t.Errorf("lookupIP(10.1.2.3) = %s, %v", p, ok)
+	}
+	if _, err := newGeoIPMatcher(&geodat.GeoIP{Cidr: []*geodat.CIDR{{Ip: []byte{1, 2, 3}}}}); err == nil {
+		t.Error("newGeoIPMatcher() should return error for invalid IP length")
+	}
+}
@@ -2,7 +2,10 @@ package acl
 
 import (
 	"net"
+	"net/netip"
 	"testing"
+
+	"github.com/xflash-panda/acl-engine/pkg/acl/iptree"
 )
 
 func Test_ipMatcher_Match(t *testing.T) {
@@ -52,9 +55,9 @@ func Test_ipMatcher_Match(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			m := &ipMatcher{
-				IP: tt.IP,
+				IP: iptree.AddrFromIP(tt.IP),
 			}
-			if got := m.Match(tt.host); got != tt.want {
+			if got := m.Match(tt.host.Host()); got != tt.want {
 				t.Errorf("Match() = %v, want %v", got, tt.want)
 			}
 		})
@@ -131,9 +134,9 @@ func Test_cidrMatcher_Match(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			m := &cidrMatcher{
-				IPNet: tt.IPNet,
+				Prefix: iptree.Normalize(iptree.PrefixFromIPNet(tt.IPNet)),
 			}
-			if got := m.Match(tt.host); got != tt.want {
+			if got := m.Match(tt.host.Host()); got != tt.want {
 				t.Errorf("Match() = %v, want %v", got, tt.want)
 			}
 		})
@@ -343,7 +346,71 @@ func Test_domainMatcher_Match(t *testing.T) {
 				Pattern: tt.fields.Pattern,
 				Mode:    tt.fields.Mode,
 			}
-			if got := m.Match(tt.host); got != tt.want {
+			if got := m.Match(tt.host.Host()); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_inverseMatcher_Match(t *testing.T) {
+	m := &inverseMatcher{&domainMatcher{Pattern: "example.com", Mode: domainMatchSuffix}}
+	if m.Match(Host{Name: "www.example.com"}) {
+		t.Error("Match() = true for a host matched by the inner matcher")
+	}
+	if !m.Match(Host{Name: "example.org"}) {
+		t.Error("Match() = false for a host not matched by the inner matcher")
+	}
+	if !m.Match(Host{IPv4: netip.MustParseAddr("1.1.1.1")}) {
+		t.Error("Match() = false for a host without a name")
+	}
+}
+
+func Test_compositeMatchers_Match(t *testing.T) {
+	a := &domainMatcher{Pattern: "a.com", Mode: domainMatchSuffix}
+	b := &domainMatcher{Pattern: "*.com", Mode: domainMatchWildcard}
+	tests := []struct {
+		name    string
+		matcher hostMatcher
+		host    string
+		want    bool
+	}{
+		{"and both", &andMatcher{[]hostMatcher{a, b}}, "www.a.com", true},
+		{"and one", &andMatcher{[]hostMatcher{a, b}}, "b.com", false},
+		{"and empty", &andMatcher{}, "b.com", true},
+		{"or one", &orMatcher{[]hostMatcher{a, b}}, "b.com", true},
+		{"or none", &orMatcher{[]hostMatcher{a, b}}, "b.org", false},
+		{"or empty", &orMatcher{}, "b.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.matcher.Match(Host{Name: tt.host}); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_sessionMatchers_Match(t *testing.T) {
+	lan := netip.MustParsePrefix("10.1.0.0/16")
+	tests := []struct {
+		name    string
+		matcher hostMatcher
+		host    HostInfo
+		want    bool
+	}{
+		{"src in range", &srcMatcher{lan}, HostInfo{SrcIP: net.ParseIP("10.1.2.3")}, true},
+		{"src out of range", &srcMatcher{lan}, HostInfo{SrcIP: net.ParseIP("10.2.0.1")}, false},
+		{"src unknown", &srcMatcher{lan}, HostInfo{IPv4: net.ParseIP("10.1.2.3")}, false},
+		{"inbound", &inboundMatcher{"office"}, HostInfo{Inbound: "Office"}, true},
+		{"inbound other", &inboundMatcher{"office"}, HostInfo{Inbound: "home"}, false},
+		{"inbound unknown", &inboundMatcher{"office"}, HostInfo{}, false},
+		{"user", &userMatcher{"alice"}, HostInfo{User: "alice"}, true},
+		{"user is case-sensitive", &userMatcher{"alice"}, HostInfo{User: "Alice"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.matcher.Match(tt.host.Host()); got != tt.want {
 				t.Errorf("Match() = %v, want %v", got, tt.want)
 			}
 		})
@@ -0,0 +1,144 @@
+package mmdb
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/xflash-panda/acl-engine/pkg/acl/geodat"
+)
+
+// asnRecord represents a record in an ASN MMDB file.
+// Both the MaxMind GeoLite2-ASN and the ipinfo ASN layouts are supported.
+type asnRecord struct {
+	// GeoLite2-ASN
+	Number       uint32 `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+	// ipinfo (asn.mmdb, country_asn.mmdb)
+	ASN    string `maxminddb:"asn"`
+	Name   string `maxminddb:"name"`
+	ASName string `maxminddb:"as_name"`
+}
+
+// number returns the AS number of the record, or 0 if it has none.
+func (r *asnRecord) number() uint32 {
+	if r.Number != 0 {
+		return r.Number
+	}
+	n, err := ParseASN(r.ASN)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// organization returns the name of the organization owning the AS.
+func (r *asnRecord) organization() string {
+	switch {
+	case r.Organization != "":
+		return r.Organization
+	case r.Name != "":
+		return r.Name
+	default:
+		return r.ASName
+	}
+}
+
+// ParseASN parses an AS number, with or without the "AS" prefix
+// (e.g. "13335" or "AS13335", case-insensitive).
+func ParseASN(s string) (uint32, error) {
+	digits := s
+	if len(s) > 2 && strings.EqualFold(s[:2], "as") {
+		digits = s[2:]
+	}
+	n, err := strconv.ParseUint(digits, 10, 32)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid AS number %q", s)
+	}
+	return uint32(n), nil
+}
+
+// LoadASN loads an ASN MMDB file (GeoLite2-ASN or ipinfo ASN) and converts it
+// to the geodat format. The keys of the map are AS numbers in decimal ("13335"),
+// and the CountryCode of each entry is the AS number with the "AS" prefix.
+func LoadASN(filename string) (map[string]*geodat.GeoIP, error) {
+	db, err := maxminddb.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = db.Close() }()
+
+	// Map to collect CIDRs by AS number
+	asnNetworks := make(map[uint32][]*geodat.CIDR)
+
+	networks := db.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		var record asnRecord
+		subnet, err := networks.Network(&record)
+		if err != nil {
+			return nil, err
+		}
+
+		asn := record.number()
+		if asn == 0 {
+			continue
+		}
+
+		ones, _ := subnet.Mask.Size()
+		ip := subnet.IP
+
+		// Normalize IP to 4-byte for IPv4 or 16-byte for IPv6
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		} else {
+			ip = ip.To16()
+		}
+
+		cidr := &geodat.CIDR{
+			Ip:     ip,
+			Prefix: uint32(ones), // #nosec G115 -- ones is 0-128 for CIDR prefix
+		}
+
+		asnNetworks[asn] = append(asnNetworks[asn], cidr)
+	}
+
+	if err := networks.Err(); err != nil {
+		return nil, err
+	}
+
+	// Convert to map[string]*geodat.GeoIP
+	result := make(map[string]*geodat.GeoIP)
+	for asn, cidrs := range asnNetworks {
+		key := strconv.FormatUint(uint64(asn), 10)
+		result[key] = &geodat.GeoIP{
+			CountryCode: "AS" + key,
+			Cidr:        cidrs,
+		}
+	}
+
+	return result, nil
+}
+
+// LookupASN looks up the AS number and organization for an IP address.
+// Returns 0 and an empty string if not found.
+func LookupASN(filename string, ip net.IP) (uint32, string, error) {
+	db, err := maxminddb.Open(filename)
+	if err != nil {
+		return 0, "", err
+	}
+	defer func() { _ = db.Close() }()
+
+	var record asnRecord
+	err = db.Lookup(ip, &record)
+	if err != nil {
+		return 0, "", err
+	}
+
+	asn := record.number()
+	if asn == 0 {
+		return 0, "", nil
+	}
+	return asn, record.organization(), nil
+}
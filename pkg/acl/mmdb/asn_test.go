@@ -0,0 +1,55 @@
+package mmdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseASN(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint32
+		wantErr bool
+	}{
+		{"13335", 13335, false},
+		{"AS4134", 4134, false},
+		{"as4134", 4134, false},
+		{"4294967295", 4294967295, false},
+		{"", 0, true},
+		{"AS", 0, true},
+		{"0", 0, true},
+		{"AS-1", 0, true},
+		{"4294967296", 0, true},
+		{"cloudflare", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseASN(tt.in)
+		if tt.wantErr {
+			assert.Error(t, err, tt.in)
+			continue
+		}
+		assert.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+}
+
+func Test_asnRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  asnRecord
+		wantASN uint32
+		wantOrg string
+	}{
+		{"geolite2", asnRecord{Number: 13335, Organization: "CLOUDFLARENET"}, 13335, "CLOUDFLARENET"},
+		{"ipinfo asn", asnRecord{ASN: "AS13335", Name: "Cloudflare, Inc."}, 13335, "Cloudflare, Inc."},
+		{"ipinfo country_asn", asnRecord{ASN: "AS4134", ASName: "Chinanet"}, 4134, "Chinanet"},
+		{"empty", asnRecord{}, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantASN, tt.record.number())
+			assert.Equal(t, tt.wantOrg, tt.record.organization())
+		})
+	}
+}
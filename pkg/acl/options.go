@@ -0,0 +1,102 @@
+package acl
+
+import "time"
+
+// Option configures ParseTextRules, Compile and CompiledRuleSet.Explain.
+// Options that do not apply to a function are ignored by it.
+type Option func(*options)
+
+type options struct {
+	source      string
+	allErrors   bool
+	baseDir     string
+	comments    bool
+	trace       bool
+	hitCounters bool
+	cacheShards int
+	cacheTTL    time.Duration
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithSource sets the name of the rule source (usually a file name).
+// ParseTextRules records it in TextRule.Source, and it is included in error messages.
+func WithSource(name string) Option {
+	return func(o *options) {
+		o.source = name
+	}
+}
+
+// WithAllErrors makes ParseTextRules and Compile keep going after an error and
+// report every error they find as an ErrorList, instead of stopping at the first one.
+// ParseTextRules then also returns the rules that could be parsed, so that they
+// can still be checked by Compile.
+func WithAllErrors() Option {
+	return func(o *options) {
+		o.allErrors = true
+	}
+}
+
+// WithBaseDir sets the directory that ParseTextRules resolves relative include
+// paths against, and Compile relative "list:" paths. ParseFile uses the
+// directory of the file instead, and the rules of an included file resolve
+// "list:" paths against its directory, as its includes.
+func WithBaseDir(dir string) Option {
+	return func(o *options) {
+		o.baseDir = dir
+	}
+}
+
+// WithComments makes ParseTextRules and ParseFile record comments and empty
+// lines in TextRule.Comments and TextRule.Comment, and keep include directives
+// as TextRules instead of replacing them with the included rules, so that the
+// rules can be written back with Format. Compile skips comment-only rules but
+// rejects include directives.
+func WithComments() Option {
+	return func(o *options) {
+		o.comments = true
+	}
+}
+
+// WithTrace makes CompiledRuleSet.Explain report every rule it evaluated in
+// MatchDetail.Trace. The rules are evaluated even if the result is cached.
+func WithTrace() Option {
+	return func(o *options) {
+		o.trace = true
+	}
+}
+
+// WithHitCounters makes Compile count how many connections each rule matched,
+// including results served from the cache (see CompiledRuleSet.HitStats).
+// Calls to Explain are not counted.
+func WithHitCounters() Option {
+	return func(o *options) {
+		o.hitCounters = true
+	}
+}
+
+// WithCacheShards sets the number of shards of the match cache of Compile,
+// rounded up to a power of two. Each shard has its own lock and an equal part
+// of the cache size, and evicts its own least recently used results. By
+// default it depends on GOMAXPROCS and the cache size: small caches have a
+// single shard, so that they are exact LRU caches.
+func WithCacheShards(n int) Option {
+	return func(o *options) {
+		o.cacheShards = n
+	}
+}
+
+// WithCacheTTL makes Compile discard cached match results once they are older
+// than ttl, for example to pick up changes in how host names resolve. By default
+// results are kept until they are evicted or a rule schedule changes.
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.cacheTTL = ttl
+	}
+}
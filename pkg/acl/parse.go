@@ -2,19 +2,26 @@ package acl
 
 import (
 	"fmt"
-	"regexp"
 	"strings"
 )
 
-var linePattern = regexp.MustCompile(`^(\w+)\s*\(([^,]+)(?:,([^,]+))?(?:,([^,]+))?\)$`)
+// maxRuleArgs is the maximum number of arguments a rule can have.
+const maxRuleArgs = 3
 
 type InvalidSyntaxError struct {
+	Source  string
 	Line    string
 	LineNum int
+	Column  int
+	Message string
 }
 
 func (e *InvalidSyntaxError) Error() string {
-	return fmt.Sprintf("invalid syntax at line %d: %s", e.LineNum, e.Line)
+	loc := formatLocation(e.Source, e.LineNum, e.Column)
+	if e.Message == "" {
+		return fmt.Sprintf("invalid syntax at %s: %s", loc, e.Line)
+	}
+	return fmt.Sprintf("invalid syntax at %s: %s: %s", loc, e.Message, e.Line)
 }
 
 // TextRule is the struct representation of a (non-comment) line parsed from an ACL file.
@@ -24,6 +31,29 @@ func (e *InvalidSyntaxError) Error() string {
 //	outbound(address,protoPort)
 //	outbound(address,protoPort,hijackAddress)
 //
+// followed by optional named arguments, currently only a schedule:
+//
+//	outbound(address, schedule="mon-fri 09:00-17:00")
+//
+// Outbound names may contain letters, digits, underscores, hyphens and dots.
+// Arguments can be double-quoted to include commas, parentheses or '#', and a
+// backslash escapes any of '\\', '"', ',', '(', ')' and '#' (before other
+// characters it is kept as-is). In "regexp:" addresses the backslash is kept
+// before those characters too, so "regexp:^a\(b\)$" matches "a(b)". A
+// backslash at the end of a line joins it with the next one.
+//
+// The commas of a port list need not be quoted: in "tcp/25,465,587", the
+// arguments after the protocol/port that are only ports are joined back into it.
+//
+// A line can also define a named address set, which rules can reference in
+// place of an address (e.g. "direct(@lan)"):
+//
+//	@name = address1, address2, ...
+//
+// and include other rule files in its place (see ParseFile):
+//
+//	include(path)
+//
 // It does not check whether any of the fields is valid - it's up to the compiler to do so.
 type TextRule struct {
 	Outbound      string
@@ -31,40 +61,340 @@ type TextRule struct {
 	ProtoPort     string
 	HijackAddress string
 	LineNum       int
+	Source        string // name of the source the rule was parsed from, see WithSource
+
+	// Schedule restricts the rule to certain weekdays and times of day,
+	// e.g. "mon-fri 09:00-17:00 Europe/Berlin". Empty means always.
+	Schedule string
+
+	// Comments are the comment lines (including '#') and empty lines ("")
+	// before the rule, and Comment the comment at the end of its line.
+	// They are only recorded with WithComments, to write the rules back with
+	// Format. A TextRule with only Comments holds the comments at the end of
+	// the file.
+	Comments []string
+	Comment  string
+
+	// SetName is the name (without '@') of the address set defined by this
+	// line, or empty if the line is a rule. For definitions, Address holds the
+	// list of addresses as written in the source (quotes and escapes included),
+	// and Outbound, ProtoPort and HijackAddress are empty.
+	SetName string
+
+	// Column is the column of the outbound name on line LineNum.
+	Column int
+	// Positions of the individual arguments. They may be on a later line
+	// than LineNum if the rule uses line continuations.
+	// The position of an omitted argument is the zero Position.
+	AddressPos       Position
+	ProtoPortPos     Position
+	HijackAddressPos Position
+	SchedulePos      Position
+
+	// dir is the directory that relative include paths were resolved against
+	// when the rule was parsed, i.e. that of the file containing it, which
+	// relative "list:" paths are resolved against too.
+	dir string
+}
+
+// parseRule parses a single rule starting at the current position of s.
+func parseRule(s *scanner) (*TextRule, *InvalidSyntaxError) {
+	start := s.pos()
+	name := s.scanIdent()
+	if name == "" {
+		return nil, s.errorf(start, "expected outbound name")
+	}
+	s.skipSpace()
+	if !s.accept('(') {
+		return nil, s.errorf(s.pos(), "expected '(' after outbound name")
+	}
+	args, err := s.scanArgs()
+	if err != nil {
+		return nil, err
+	}
+	s.skipSpace()
+	if !s.atLineEnd() {
+		return nil, s.errorf(s.pos(), "unexpected %q after ')'", s.peek())
+	}
+	rule := &TextRule{
+		Outbound: name,
+		Comment:  s.scanComment(),
+		LineNum:  start.Line,
+		Column:   start.Column,
+	}
+	args, err = parseNamedArgs(s, rule, args)
+	if err != nil {
+		return nil, err
+	}
+	if args[0].Value == "" {
+		return nil, s.errorf(args[0].Pos, "empty address")
+	}
+	for _, arg := range args[1:] {
+		if arg.Value == "" {
+			// Omitted arguments are left out, not left empty
+			return nil, s.errorf(arg.Pos, "empty argument")
+		}
+	}
+	args = mergePortArgs(args)
+	if len(args) > maxRuleArgs {
+		return nil, s.errorf(args[maxRuleArgs].Pos, "too many arguments (at most %d)", maxRuleArgs)
+	}
+	rule.Address = args[0].Value
+	rule.AddressPos = args[0].Pos
+	if len(args) > 1 {
+		rule.ProtoPort = args[1].Value
+		rule.ProtoPortPos = args[1].Pos
+	}
+	if len(args) > 2 {
+		rule.HijackAddress = args[2].Value
+		rule.HijackAddressPos = args[2].Pos
+	}
+	return rule, nil
+}
+
+// mergePortArgs joins the arguments after the protocol/port argument that
+// continue its list back into it, since the scanner splits an unquoted list
+// such as "tcp/25,465,587" at its commas. Such arguments are never valid
+// hijack addresses.
+func mergePortArgs(args []textArg) []textArg {
+	if len(args) < 3 || !strings.Contains(args[1].Value, "/") {
+		return args
+	}
+	n := 2
+	for n < len(args) && isPortListArg(args[n].Value) {
+		n++
+	}
+	if n == 2 {
+		return args
+	}
+	protoPort := args[1]
+	for _, arg := range args[2:n] {
+		protoPort.Value += "," + arg.Value
+	}
+	return append([]textArg{args[0], protoPort}, args[n:]...)
+}
+
+// isPortListArg reports whether arg continues a protocol/port list: it
+// consists of ports, port ranges and protocol/port specs such as "udp/53".
+func isPortListArg(arg string) bool {
+	fields := strings.Fields(arg)
+	for _, f := range fields {
+		proto, ports, hasProto := strings.Cut(f, "/")
+		if hasProto {
+			if _, ok := parseProtocol(strings.ToLower(proto)); !ok {
+				return false
+			}
+			if ports == "*" {
+				continue
+			}
+		} else {
+			ports = f
+		}
+		start, end, isRange := strings.Cut(ports, "-")
+		if !isDigits(start) || isRange && !isDigits(end) {
+			return false
+		}
+	}
+	return len(fields) > 0
 }
 
-func parseLine(line string, num int) *TextRule {
-	matches := linePattern.FindStringSubmatch(line)
-	if matches == nil {
-		return nil
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// isComment reports whether the rule only holds comments (see WithComments).
+func (r *TextRule) isComment() bool {
+	return r.Outbound == "" && r.SetName == ""
+}
+
+// namedArgs are the names of the named arguments a rule can have.
+var namedArgs = []string{"schedule"}
+
+// parseNamedArgs stores the named arguments ("name=value") of a rule in rule,
+// and returns the remaining positional arguments. Named arguments must follow
+// all positional arguments.
+func parseNamedArgs(s *scanner, rule *TextRule, args []textArg) ([]textArg, *InvalidSyntaxError) {
+	n := len(args)
+	for n > 1 {
+		if _, _, ok := cutNamedArg(args[n-1].Value); !ok {
+			break
+		}
+		n--
+	}
+	for _, arg := range args[n:] {
+		name, value, _ := cutNamedArg(arg.Value)
+		switch strings.ToLower(name) {
+		case "schedule":
+			if rule.SchedulePos.IsValid() {
+				return nil, s.errorf(arg.Pos, "duplicate argument %s", name)
+			}
+			if value == "" {
+				return nil, s.errorf(arg.Pos, "empty schedule")
+			}
+			rule.Schedule, rule.SchedulePos = value, arg.Pos
+		default:
+			msg := fmt.Sprintf("unknown argument %s", name)
+			if sug := suggest(strings.ToLower(name), namedArgs); sug != "" {
+				msg += fmt.Sprintf(" (did you mean %q?)", sug)
+			}
+			return nil, s.errorf(arg.Pos, "%s", msg)
+		}
+	}
+	for _, arg := range args[:n] {
+		if name, _, ok := cutNamedArg(arg.Value); ok {
+			return nil, s.errorf(arg.Pos, "argument %s must follow all positional arguments", name)
+		}
+	}
+	return args[:n], nil
+}
+
+// cutNamedArg splits a named argument "name=value" into name and value.
+// name must consist of letters only.
+func cutNamedArg(arg string) (name, value string, ok bool) {
+	name, value, ok = strings.Cut(arg, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return "", "", false
+	}
+	for i := 0; i < len(name); i++ {
+		if c := name[i] | 0x20; c < 'a' || c > 'z' {
+			return "", "", false
+		}
+	}
+	return name, strings.TrimSpace(value), true
+}
+
+// parseDefinition parses an address set definition starting at the current
+// position of s, which must be at the '@'.
+func parseDefinition(s *scanner) (*TextRule, *InvalidSyntaxError) {
+	start := s.pos()
+	s.next() // '@'
+	name := s.scanIdent()
+	if name == "" {
+		return nil, s.errorf(s.pos(), "expected address set name after '@'")
+	}
+	s.skipSpace()
+	if !s.accept('=') {
+		return nil, s.errorf(s.pos(), "expected '=' after address set name")
+	}
+	s.skipSpace()
+	listPos, listStart := s.pos(), s.off
+	args, err := s.scanList()
+	if err != nil {
+		return nil, err
+	}
+	list := strings.TrimRight(s.src[listStart:s.off], " \t\r")
+	comment := s.scanComment()
+	for _, arg := range args {
+		if arg.Value == "" {
+			return nil, s.errorf(arg.Pos, "empty address")
+		}
 	}
 	return &TextRule{
-		Outbound:      matches[1],
-		Address:       strings.TrimSpace(matches[2]),
-		ProtoPort:     strings.TrimSpace(matches[3]),
-		HijackAddress: strings.TrimSpace(matches[4]),
-		LineNum:       num,
+		SetName:    name,
+		Address:    list,
+		Comment:    comment,
+		AddressPos: listPos,
+		LineNum:    start.Line,
+		Column:     start.Column,
+	}, nil
+}
+
+// ParseTextRules parses ACL rules from text.
+// By default it stops at the first syntax error. With WithAllErrors, it skips
+// invalid rules and returns the valid ones together with an ErrorList.
+// Relative paths in include directives are resolved against the directory set
+// with WithBaseDir, or the current working directory.
+func ParseTextRules(text string, opts ...Option) ([]TextRule, error) {
+	o := newOptions(opts)
+	p := &parser{allErrors: o.allErrors, comments: o.comments}
+	rules, err := p.parse(text, o.source, o.baseDir)
+	if err != nil {
+		return nil, err
 	}
+	return rules, p.errs.Err()
+}
+
+// parser parses a rule file and the files it includes.
+type parser struct {
+	allErrors bool
+	comments  bool      // see WithComments
+	errs      ErrorList // errors collected in allErrors mode
+	// files is the chain of files currently being parsed (absolute paths),
+	// used to detect include cycles.
+	files []string
 }
 
-func ParseTextRules(text string) ([]TextRule, error) {
+// fail records an error. In allErrors mode it returns nil so that parsing
+// continues, otherwise it returns err to stop parsing.
+func (p *parser) fail(err error) error {
+	if !p.allErrors {
+		return err
+	}
+	p.errs = append(p.errs, err)
+	return nil
+}
+
+// parse parses text from source, resolving includes relative to dir.
+func (p *parser) parse(text, source, dir string) ([]TextRule, error) {
 	rules := make([]TextRule, 0)
-	lineNum := 0
-	for _, line := range strings.Split(text, "\n") {
-		lineNum++
-		// Remove comments
-		if i := strings.Index(line, "#"); i >= 0 {
-			line = line[:i]
-		}
-		line = strings.TrimSpace(line)
-		// Skip empty lines
-		if len(line) == 0 {
+	s := newScanner(text)
+	for {
+		// Skip empty lines and comments
+		var comments []string
+		if p.comments {
+			comments = s.scanBlank()
+		} else {
+			s.skipBlank()
+		}
+		if s.eof() {
+			if len(comments) > 0 {
+				rules = append(rules, TextRule{Comments: comments, Source: source})
+			}
+			break
+		}
+		var rule *TextRule
+		var err *InvalidSyntaxError
+		if s.peek() == '@' {
+			rule, err = parseDefinition(s)
+		} else {
+			rule, err = parseRule(s)
+		}
+		if err == nil && rule.isInclude() {
+			err = checkInclude(s, rule)
+		}
+		if err != nil {
+			err.Source = source
+			if err := p.fail(err); err != nil {
+				return nil, err
+			}
+			s.skipRule()
 			continue
 		}
-		// Parse line
-		rule := parseLine(line, lineNum)
-		if rule == nil {
-			return nil, &InvalidSyntaxError{line, lineNum}
+		rule.Source, rule.dir = source, dir
+		if p.comments {
+			rule.Comments = comments
+			// The end of the rule's line is not an empty line
+			s.skipSpace()
+			s.accept('\n')
+		} else {
+			rule.Comment = ""
+		}
+		if rule.isInclude() && !p.comments {
+			included, err := p.include(rule, dir)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, included...)
+			continue
 		}
 		rules = append(rules, *rule)
 	}
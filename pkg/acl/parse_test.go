@@ -1,7 +1,9 @@
 package acl
 
 import (
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -32,13 +34,85 @@ my_custom_outbound1(9.9.9.9,*,   8.8.8.8) # bebop
 my_custom_outbound2(all)
 `,
 			want: []TextRule{
-				{Outbound: "direct", Address: "1.1.1.1", LineNum: 4},
-				{Outbound: "direct", Address: "8.8.8.0/24", LineNum: 5},
-				{Outbound: "reject", Address: "all", ProtoPort: "udp/443", LineNum: 6},
-				{Outbound: "reject", Address: "geoip:cn", LineNum: 7},
-				{Outbound: "reject", Address: "*.v2ex.com", LineNum: 8},
-				{Outbound: "my_custom_outbound1", Address: "9.9.9.9", ProtoPort: "*", HijackAddress: "8.8.8.8", LineNum: 9},
-				{Outbound: "my_custom_outbound2", Address: "all", LineNum: 10},
+				{Outbound: "direct", Address: "1.1.1.1", LineNum: 4, Column: 1, AddressPos: Position{4, 8}},
+				{Outbound: "direct", Address: "8.8.8.0/24", LineNum: 5, Column: 1, AddressPos: Position{5, 8}},
+				{Outbound: "reject", Address: "all", ProtoPort: "udp/443", LineNum: 6, Column: 1, AddressPos: Position{6, 8}, ProtoPortPos: Position{6, 13}},
+				{Outbound: "reject", Address: "geoip:cn", LineNum: 7, Column: 2, AddressPos: Position{7, 9}},
+				{Outbound: "reject", Address: "*.v2ex.com", LineNum: 8, Column: 3, AddressPos: Position{8, 10}},
+				{Outbound: "my_custom_outbound1", Address: "9.9.9.9", ProtoPort: "*", HijackAddress: "8.8.8.8", LineNum: 9, Column: 1, AddressPos: Position{9, 21}, ProtoPortPos: Position{9, 29}, HijackAddressPos: Position{9, 34}},
+				{Outbound: "my_custom_outbound2", Address: "all", LineNum: 10, Column: 1, AddressPos: Position{10, 21}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "hyphens and dots in outbound names",
+			text: "my-socks5(all, tcp/22)\nproxy.us-west(suffix:example.com)",
+			want: []TextRule{
+				{Outbound: "my-socks5", Address: "all", ProtoPort: "tcp/22", LineNum: 1, Column: 1, AddressPos: Position{1, 11}, ProtoPortPos: Position{1, 16}},
+				{Outbound: "proxy.us-west", Address: "suffix:example.com", LineNum: 2, Column: 1, AddressPos: Position{2, 15}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "quoted arguments",
+			text: `direct("a,b#c", " tcp/443 ") # comment`,
+			want: []TextRule{
+				{Outbound: "direct", Address: "a,b#c", ProtoPort: " tcp/443 ", LineNum: 1, Column: 1, AddressPos: Position{1, 8}, ProtoPortPos: Position{1, 17}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "escapes",
+			text: `direct(a\,b\#c, "x\"y")`,
+			want: []TextRule{
+				{Outbound: "direct", Address: "a,b#c", ProtoPort: `x"y`, LineNum: 1, Column: 1, AddressPos: Position{1, 8}, ProtoPortPos: Position{1, 17}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "backslash before other characters is kept",
+			text: `proxy(regexp:^api\.example\.(com|net)$, "a\b")`,
+			want: []TextRule{
+				{Outbound: "proxy", Address: `regexp:^api\.example\.(com|net)$`, ProtoPort: `a\b`, LineNum: 1, Column: 1, AddressPos: Position{1, 7}, ProtoPortPos: Position{1, 41}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "backslashes are kept in regular expressions",
+			text: `proxy(regexp:^a\(b\)\,c$, "tcp/443") # comment
+reject(!regexp:^x\#y$)
+direct("regexp:^a{1,3}\"q\\$")`,
+			want: []TextRule{
+				{Outbound: "proxy", Address: `regexp:^a\(b\)\,c$`, ProtoPort: "tcp/443", LineNum: 1, Column: 1, AddressPos: Position{1, 7}, ProtoPortPos: Position{1, 27}},
+				{Outbound: "reject", Address: `!regexp:^x\#y$`, LineNum: 2, Column: 1, AddressPos: Position{2, 8}},
+				{Outbound: "direct", Address: `regexp:^a{1,3}\"q\\$`, LineNum: 3, Column: 1, AddressPos: Position{3, 8}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "line continuation",
+			text: "direct(all, \\\n    udp/53, \\\r\n  127.0.0.1)\nreject(all)",
+			want: []TextRule{
+				{Outbound: "direct", Address: "all", ProtoPort: "udp/53", HijackAddress: "127.0.0.1", LineNum: 1, Column: 1, AddressPos: Position{1, 8}, ProtoPortPos: Position{2, 5}, HijackAddressPos: Position{3, 3}},
+				{Outbound: "reject", Address: "all", LineNum: 4, Column: 1, AddressPos: Position{4, 8}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "nested parentheses are kept verbatim",
+			text: `proxy(f(a, "b,c"), tcp)`,
+			want: []TextRule{
+				{Outbound: "proxy", Address: `f(a, "b,c")`, ProtoPort: "tcp", LineNum: 1, Column: 1, AddressPos: Position{1, 7}, ProtoPortPos: Position{1, 20}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "schedule",
+			text: `reject(geosite:category-games, schedule="mon-fri 09:00-17:00 +08:00")
+direct(all, tcp/443, Schedule = weekends)`,
+			want: []TextRule{
+				{Outbound: "reject", Address: "geosite:category-games", Schedule: "mon-fri 09:00-17:00 +08:00", LineNum: 1, Column: 1, AddressPos: Position{1, 8}, SchedulePos: Position{1, 32}},
+				{Outbound: "direct", Address: "all", ProtoPort: "tcp/443", Schedule: "weekends", LineNum: 2, Column: 1, AddressPos: Position{2, 8}, ProtoPortPos: Position{2, 13}, SchedulePos: Position{2, 22}},
 			},
 			wantErr: false,
 		},
@@ -68,3 +142,138 @@ my_custom_outbound2(all)
 		})
 	}
 }
+
+func TestParseTextRules_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		wantLine   int
+		wantColumn int
+	}{
+		{"missing name", "\n  (all)", 2, 3},
+		{"missing paren", "direct all", 1, 8},
+		{"empty address", "direct( )", 1, 9},
+		{"unclosed", "direct(all, tcp", 1, 16},
+		{"unclosed nested", "direct(f(a, b)", 1, 15},
+		{"comment before close", "direct(all # oops)", 1, 12},
+		{"trailing garbage", "direct(all) x", 1, 13},
+		{"too many args", "direct(a, b, c, d)", 1, 17},
+		{"unterminated quote", `direct("all)`, 1, 8},
+		{"continued error", "direct(all, \\\n tcp x", 2, 7},
+		{"unknown named arg", "direct(all, schedul=weekends)", 1, 13},
+		{"duplicate named arg", "direct(all, schedule=sat, schedule=sun)", 1, 27},
+		{"empty schedule", "direct(all, schedule=)", 1, 13},
+		{"named arg before positional", "direct(all, schedule=sat, tcp)", 1, 13},
+		{"too many args before named", "direct(a, b, c, d, schedule=sat)", 1, 17},
+		{"empty arguments", "direct(all,,)", 1, 12},
+		{"blank argument", "direct(all, , 1.2.3.4)", 1, 13},
+		{"trailing comma", "direct(all, tcp/80, )", 1, 21},
+		{"empty quoted argument", `direct(all, "", 1.2.3.4)`, 1, 13},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTextRules(tt.text)
+			var syntaxErr *InvalidSyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("ParseTextRules() error = %v, want InvalidSyntaxError", err)
+			}
+			if syntaxErr.LineNum != tt.wantLine || syntaxErr.Column != tt.wantColumn {
+				t.Errorf("ParseTextRules() error at %d:%d, want %d:%d (%v)",
+					syntaxErr.LineNum, syntaxErr.Column, tt.wantLine, tt.wantColumn, err)
+			}
+		})
+	}
+	// Omitted arguments must be left out, not left empty
+	for _, text := range []string{"direct(all,,)", "direct(all, , 1.2.3.4)"} {
+		if _, err := ParseTextRules(text); err == nil || !strings.Contains(err.Error(), "empty argument") {
+			t.Errorf("ParseTextRules(%q) error = %v, want empty argument", text, err)
+		}
+	}
+}
+
+func TestParseTextRules_AllErrors(t *testing.T) {
+	text := `direct(all, tcp
+proxy(suffix:example.com)
+reject all
+direct(a, \
+  b, c, d)
+reject(1.1.1.1)
+`
+	rules, err := ParseTextRules(text, WithSource("test.acl"), WithAllErrors())
+	var errs ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("ParseTextRules() error = %v, want ErrorList", err)
+	}
+	want := []Position{{1, 16}, {3, 8}, {5, 9}}
+	if len(errs) != len(want) {
+		t.Fatalf("ParseTextRules() got %d errors, want %d: %v", len(errs), len(want), err)
+	}
+	for i, e := range errs {
+		syntaxErr := e.(*InvalidSyntaxError)
+		if syntaxErr.Source != "test.acl" || syntaxErr.LineNum != want[i].Line || syntaxErr.Column != want[i].Column {
+			t.Errorf("error %d = %v, want test.acl at %v", i, e, want[i])
+		}
+	}
+	if len(rules) != 2 || rules[0].Outbound != "proxy" || rules[1].LineNum != 6 {
+		t.Errorf("ParseTextRules() rules = %v", rules)
+	}
+	for _, rule := range rules {
+		if rule.Source != "test.acl" {
+			t.Errorf("rule.Source = %q, want %q", rule.Source, "test.acl")
+		}
+	}
+}
+
+func TestParseTextRules_AddressSets(t *testing.T) {
+	text := `@lan = 10.0.0.0/8, 192.168.0.0/16, \
+  "fd00::/8" # private
+direct(@lan)
+`
+	got, err := ParseTextRules(text)
+	if err != nil {
+		t.Fatalf("ParseTextRules() error = %v", err)
+	}
+	want := []TextRule{
+		{
+			SetName:    "lan",
+			Address:    "10.0.0.0/8, 192.168.0.0/16, \\\n  \"fd00::/8\"",
+			LineNum:    1,
+			Column:     1,
+			AddressPos: Position{1, 8},
+		},
+		{
+			Outbound:   "direct",
+			Address:    "@lan",
+			LineNum:    3,
+			Column:     1,
+			AddressPos: Position{3, 8},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseTextRules() got = %#v, want %#v", got, want)
+	}
+
+	errTests := []struct {
+		name       string
+		text       string
+		wantColumn int
+	}{
+		{"missing name", "@ = a", 2},
+		{"missing equals", "@lan a, b", 6},
+		{"empty address", "@lan = a, , b", 11},
+		{"stray paren", "@lan = a)", 9},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTextRules(tt.text)
+			var syntaxErr *InvalidSyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("ParseTextRules() error = %v, want InvalidSyntaxError", err)
+			}
+			if syntaxErr.LineNum != 1 || syntaxErr.Column != tt.wantColumn {
+				t.Errorf("ParseTextRules() error at %d:%d, want 1:%d (%v)",
+					syntaxErr.LineNum, syntaxErr.Column, tt.wantColumn, err)
+			}
+		})
+	}
+}
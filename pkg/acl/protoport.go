@@ -0,0 +1,297 @@
+package acl
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// portRange is an inclusive range of ports.
+type portRange struct {
+	Start uint16
+	End   uint16
+}
+
+// portSet is a set of ports, stored as sorted, non-overlapping, non-adjacent ranges.
+// An empty portSet contains no ports.
+type portSet []portRange
+
+// allPorts is the portSet containing every port.
+var allPorts = portSet{{0, 65535}}
+
+// newPortSet creates a portSet from arbitrary (possibly overlapping) ranges.
+func newPortSet(ranges []portRange) portSet {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sorted := make([]portRange, len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Start < sorted[j].Start
+	})
+	set := portSet{sorted[0]}
+	for _, r := range sorted[1:] {
+		last := &set[len(set)-1]
+		if uint32(r.Start) <= uint32(last.End)+1 {
+			// Overlapping or adjacent, merge
+			if r.End > last.End {
+				last.End = r.End
+			}
+			continue
+		}
+		set = append(set, r)
+	}
+	return set
+}
+
+// Contains reports whether port is in the set.
+func (s portSet) Contains(port uint16) bool {
+	if len(s) <= 4 {
+		// Linear scan is faster for the common case of a few ports
+		for _, r := range s {
+			if port >= r.Start && port <= r.End {
+				return true
+			}
+		}
+		return false
+	}
+	i := sort.Search(len(s), func(i int) bool {
+		return s[i].End >= port
+	})
+	return i < len(s) && port >= s[i].Start
+}
+
+// intersect returns the ports contained in both s and t.
+func (s portSet) intersect(t portSet) portSet {
+	var out portSet
+	for i, j := 0, 0; i < len(s) && j < len(t); {
+		start, end := max(s[i].Start, t[j].Start), min(s[i].End, t[j].End)
+		if start <= end {
+			out = append(out, portRange{start, end})
+		}
+		if s[i].End < t[j].End {
+			i++
+		} else {
+			j++
+		}
+	}
+	return out
+}
+
+// complement returns the ports not contained in s.
+func (s portSet) complement() portSet {
+	var out portSet
+	next := uint32(0) // first port not yet covered
+	for _, r := range s {
+		if uint32(r.Start) > next {
+			out = append(out, portRange{uint16(next), r.Start - 1})
+		}
+		next = uint32(r.End) + 1
+	}
+	if next <= 65535 {
+		out = append(out, portRange{uint16(next), 65535})
+	}
+	return out
+}
+
+// subsetOf reports whether every port in s is also in t.
+func (s portSet) subsetOf(t portSet) bool {
+	return slices.Equal(s.intersect(t), s)
+}
+
+// protoPortFilter is a compiled protocol/port condition of a rule.
+type protoPortFilter struct {
+	TCP     portSet // ports matched for TCP
+	UDP     portSet // ports matched for UDP
+	Inverse bool    // match everything except the ports above ("!tcp/22")
+}
+
+// anyProtoPort is the filter matching all protocols and ports.
+var anyProtoPort = protoPortFilter{TCP: allPorts, UDP: allPorts}
+
+// Match reports whether the protocol and port pass the filter.
+// ProtocolBoth only passes if the port is matched for both TCP and UDP.
+func (f *protoPortFilter) Match(proto Protocol, port uint16) bool {
+	var matched bool
+	switch proto {
+	case ProtocolTCP:
+		matched = f.TCP.Contains(port)
+	case ProtocolUDP:
+		matched = f.UDP.Contains(port)
+	case ProtocolBoth:
+		matched = f.TCP.Contains(port) && f.UDP.Contains(port)
+	}
+	return matched != f.Inverse
+}
+
+// String returns the canonical form of the filter, as accepted by parseProtoPort,
+// e.g. "tcp/80,443 udp/53" or "*" for all protocols and ports.
+func (f *protoPortFilter) String() string {
+	var specs []string
+	add := func(proto string, ports portSet) {
+		switch {
+		case len(ports) == 0:
+		case slices.Equal(ports, allPorts):
+			specs = append(specs, proto)
+		default:
+			specs = append(specs, proto+"/"+ports.String())
+		}
+	}
+	if slices.Equal(f.TCP, f.UDP) {
+		add("*", f.TCP)
+	} else {
+		add("tcp", f.TCP)
+		add("udp", f.UDP)
+	}
+	s := strings.Join(specs, " ")
+	if f.Inverse {
+		s = "!" + s
+	}
+	return s
+}
+
+// String returns the ports as a comma-separated list, e.g. "80,443,8000-9000".
+func (s portSet) String() string {
+	items := make([]string, len(s))
+	for i, r := range s {
+		items[i] = strconv.Itoa(int(r.Start))
+		if r.End != r.Start {
+			items[i] += "-" + strconv.Itoa(int(r.End))
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// portSets returns the ports that pass the filter for TCP, UDP and
+// ProtocolBoth, in that order, with Inverse applied.
+func (f *protoPortFilter) portSets() [3]portSet {
+	sets := [3]portSet{f.TCP, f.UDP, f.TCP.intersect(f.UDP)}
+	if f.Inverse {
+		for i := range sets {
+			sets[i] = sets[i].complement()
+		}
+	}
+	return sets
+}
+
+// parseProtoPort parses a protoPort string into a protoPortFilter.
+// protoPort is a list of one or more specs, separated by commas or whitespace:
+//
+//	proto/ports
+//	proto
+//	*
+//	[empty] (same as *)
+//
+// proto must be either "tcp", "udp" or "*" (both), case-insensitive.
+// ports is either "*" (all ports) or a port list such as "25,465,1000-2000".
+// Port numbers following a spec are added to the port list of that spec, so
+// "tcp/25,465,587,udp/53" matches TCP ports 25, 465 and 587, and UDP port 53.
+// Commas also separate rule arguments, but the parser joins the arguments
+// after the protocol/port that continue the list back into it (see
+// mergePortArgs), so both of these work:
+//
+//	reject(all, tcp/25,465,587,udp/53,853)
+//	reject(all, "tcp/25,465,587 udp/53,853")
+//
+// The whole list can be prefixed with "!" to match everything except the given
+// protocols and ports.
+func parseProtoPort(protoPort string) (protoPortFilter, error) {
+	protoPort = strings.ToLower(strings.TrimSpace(protoPort))
+	if rest, found := strings.CutPrefix(protoPort, "!"); found {
+		if strings.TrimSpace(rest) == "" {
+			return protoPortFilter{}, fmt.Errorf("missing protocol after '!'")
+		}
+		f, err := parseProtoPort(rest)
+		f.Inverse = !f.Inverse
+		return f, err
+	}
+	if protoPort == "" {
+		return anyProtoPort, nil
+	}
+
+	var tcp, udp []portRange
+	// cur is the protocol of the current spec, which bare port numbers are added to.
+	// curOpen is false after a spec that matches all ports, or before any spec.
+	var cur Protocol
+	curOpen := false
+	addPorts := func(proto Protocol, r portRange) {
+		if proto != ProtocolUDP {
+			tcp = append(tcp, r)
+		}
+		if proto != ProtocolTCP {
+			udp = append(udp, r)
+		}
+	}
+	items := strings.FieldsFunc(protoPort, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	for _, item := range items {
+		protoStr, portStr, hasPorts := strings.Cut(item, "/")
+		proto, isProto := parseProtocol(protoStr)
+		switch {
+		case isProto && (!hasPorts || portStr == "*"):
+			// Protocol with all ports
+			addPorts(proto, allPorts[0])
+			curOpen = false
+		case isProto:
+			// Protocol with a port list
+			r, err := parsePortRange(portStr)
+			if err != nil {
+				return protoPortFilter{}, err
+			}
+			addPorts(proto, r)
+			cur, curOpen = proto, true
+		case hasPorts:
+			return protoPortFilter{}, fmt.Errorf("invalid protocol %q", protoStr)
+		default:
+			// Port or port range continuing the list of the previous spec
+			if !curOpen {
+				if _, err := parsePortRange(item); err != nil {
+					return protoPortFilter{}, fmt.Errorf("invalid protocol %q", item)
+				}
+				return protoPortFilter{}, fmt.Errorf("port %s without protocol", item)
+			}
+			r, err := parsePortRange(item)
+			if err != nil {
+				return protoPortFilter{}, err
+			}
+			addPorts(cur, r)
+		}
+	}
+	return protoPortFilter{TCP: newPortSet(tcp), UDP: newPortSet(udp)}, nil
+}
+
+func parseProtocol(s string) (Protocol, bool) {
+	switch s {
+	case "tcp":
+		return ProtocolTCP, true
+	case "udp":
+		return ProtocolUDP, true
+	case "*":
+		return ProtocolBoth, true
+	default:
+		return ProtocolBoth, false
+	}
+}
+
+// parsePortRange parses a single port ("443") or a port range ("1000-2000").
+func parsePortRange(s string) (portRange, error) {
+	startStr, endStr, isRange := strings.Cut(s, "-")
+	start, err := strconv.ParseUint(startStr, 10, 16)
+	if err != nil {
+		return portRange{}, fmt.Errorf("invalid port %q", startStr)
+	}
+	if !isRange {
+		return portRange{uint16(start), uint16(start)}, nil
+	}
+	end, err := strconv.ParseUint(endStr, 10, 16)
+	if err != nil {
+		return portRange{}, fmt.Errorf("invalid port %q", endStr)
+	}
+	if start > end {
+		return portRange{}, fmt.Errorf("invalid port range %s", s)
+	}
+	return portRange{uint16(start), uint16(end)}, nil
+}
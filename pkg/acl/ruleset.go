@@ -0,0 +1,199 @@
+package acl
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/xflash-panda/acl-engine/pkg/acl/ruleset"
+)
+
+// RuleSetLoader is an optional extension of GeoLoader, required by rule-set
+// addresses such as "ruleset:geosite-cn". LoadRuleSet returns nil if there is
+// no rule-set with the given (lower case) name.
+type RuleSetLoader interface {
+	LoadRuleSet(name string) (*ruleset.RuleSet, error)
+}
+
+// compileRuleSet compiles a "ruleset:" address into a matcher for the domains
+// and IPs of the rule-set.
+func (c *compiler) compileRuleSet(name string) (hostMatcher, error) {
+	if err := checkRuleSetName(name); err != nil {
+		return nil, addressError("", "%v", err)
+	}
+	loader, ok := c.geoLoader.(RuleSetLoader)
+	if !ok {
+		return nil, addressError("", "rule-set rules are not supported by the GeoLoader")
+	}
+	rs, err := loader.LoadRuleSet(name)
+	if err != nil {
+		return nil, addressError("", "rule-set %s: %v", name, err)
+	}
+	if rs == nil {
+		return nil, addressError("", "rule-set %s not found", name)
+	}
+	site, ip, err := rs.GeoData(name)
+	if err != nil {
+		return nil, addressError("", "rule-set %s cannot be used as an address: %v", name, err)
+	}
+	var ms []hostMatcher
+	if len(site.Domain) > 0 {
+		m, err := newGeositeMatcher(site, nil)
+		if err != nil {
+			return nil, addressError("", "rule-set %s: %v", name, err)
+		}
+		ms = append(ms, m)
+	}
+	if len(ip.Cidr) > 0 {
+		m, err := newGeoIPMatcher(ip)
+		if err != nil {
+			return nil, addressError("", "rule-set %s: %v", name, err)
+		}
+		ms = append(ms, m)
+	}
+	if len(ms) == 1 {
+		return ms[0], nil
+	}
+	return &orMatcher{ms}, nil
+}
+
+// RuleSetTextRules converts the rules of a sing-box rule-set into rules that
+// send the connections they match to outbound. Unlike "ruleset:" addresses,
+// which can only use rule-sets of domains and IPs, it also converts port and
+// network conditions and logical and inverted rules, as long as each rule can
+// be written as an address and a protocol/port list.
+//
+// The rules record the source set with WithSource, and the number of the
+// rule-set rule (starting at 1) as their line number. By default it stops at
+// the first rule that cannot be converted. With WithAllErrors, it skips such
+// rules and returns the others together with an ErrorList.
+func RuleSetTextRules(rs *ruleset.RuleSet, outbound string, opts ...Option) ([]TextRule, error) {
+	o := newOptions(opts)
+	rules := make([]TextRule, 0, len(rs.Rules))
+	var errs ErrorList
+	for i := range rs.Rules {
+		cond, err := ruleSetCondition(&rs.Rules[i])
+		var rule TextRule
+		if err == nil {
+			rule, err = cond.textRule(outbound)
+		}
+		if err != nil {
+			err := &CompilationError{Source: o.source, LineNum: i + 1, Message: err.Error()}
+			if !o.allErrors {
+				return nil, err
+			}
+			errs = append(errs, err)
+			continue
+		}
+		rule.Source, rule.LineNum = o.source, i+1
+		rules = append(rules, rule)
+	}
+	return rules, errs.Err()
+}
+
+// ruleSetCondition converts a rule-set rule into a condition.
+func ruleSetCondition(r *ruleset.Rule) (condition, error) {
+	var cond condition
+	if r.Type == ruleset.RuleTypeLogical {
+		conds := make([]condition, len(r.Rules))
+		for i := range r.Rules {
+			var err error
+			if conds[i], err = ruleSetCondition(&r.Rules[i]); err != nil {
+				return condition{}, err
+			}
+		}
+		if r.Mode == ruleset.LogicalAnd {
+			cond = andConditions(conds)
+		} else {
+			var err error
+			if cond, err = orConditions(conds); err != nil {
+				return condition{}, err
+			}
+		}
+	} else {
+		var err error
+		if cond, err = defaultRuleSetCondition(r); err != nil {
+			return condition{}, err
+		}
+	}
+	if r.Invert {
+		return notCondition(cond)
+	}
+	return cond, nil
+}
+
+func defaultRuleSetCondition(r *ruleset.Rule) (condition, error) {
+	var addrs []string
+	for _, d := range r.Domain {
+		if err := checkDomain(d); err != nil {
+			return condition{}, err
+		}
+		if strings.Contains(d, "*") {
+			d = "full:" + d
+		}
+		addrs = append(addrs, d)
+	}
+	for _, d := range r.DomainSuffix {
+		if err := checkDomain(d); err != nil {
+			return condition{}, err
+		}
+		if strings.HasPrefix(d, ".") {
+			addrs = append(addrs, "*"+d) // subdomains only
+		} else {
+			addrs = append(addrs, "suffix:"+d)
+		}
+	}
+	for _, k := range r.DomainKeyword {
+		if err := checkDomain(k); err != nil {
+			return condition{}, err
+		}
+		addrs = append(addrs, "keyword:"+k)
+	}
+	for _, re := range r.DomainRegex {
+		addrs = append(addrs, "regexp:"+re)
+	}
+	for _, c := range r.IPCIDR {
+		if _, err := parseIPOrCIDR(c); err != nil {
+			return condition{}, fmt.Errorf("invalid IP CIDR %s", c)
+		}
+		addrs = append(addrs, c)
+	}
+
+	ports := allPorts
+	if len(r.Port) > 0 || len(r.PortRange) > 0 {
+		var ranges []portRange
+		for _, p := range r.Port {
+			ranges = append(ranges, portRange{p, p})
+		}
+		for _, pr := range r.PortRange {
+			start, end, err := ruleset.ParsePortRange(pr)
+			if err != nil {
+				return condition{}, err
+			}
+			ranges = append(ranges, portRange{start, end})
+		}
+		ports = newPortSet(ranges)
+	}
+	filter := protoPortFilter{TCP: ports, UDP: ports}
+	if len(r.Network) > 0 {
+		if !slices.Contains(r.Network, "tcp") {
+			filter.TCP = nil
+		}
+		if !slices.Contains(r.Network, "udp") {
+			filter.UDP = nil
+		}
+	}
+	return condition{Address: joinAddresses("or", addrs), Ports: filter}, nil
+}
+
+// checkRuleSetName checks the name of a rule-set in a "ruleset:" address,
+// which is also its file name without extension.
+func checkRuleSetName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty rule-set name")
+	}
+	if strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return fmt.Errorf("invalid rule-set name %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,453 @@
+package ruleset
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+	"unicode/utf8"
+)
+
+// magicBytes start every binary rule-set file.
+var magicBytes = [3]byte{'S', 'R', 'S'}
+
+// maxBinaryVersion is the latest binary format version that can be read.
+const maxBinaryVersion = 4
+
+// Item types of default rules in the binary format.
+const (
+	itemQueryType uint8 = iota
+	itemNetwork
+	itemDomain
+	itemDomainKeyword
+	itemDomainRegex
+	itemSourceIPCIDR
+	itemIPCIDR
+	itemSourcePort
+	itemSourcePortRange
+	itemPort
+	itemPortRange
+	itemProcessName
+	itemProcessPath
+	itemPackageName
+	itemWIFISSID
+	itemWIFIBSSID
+	itemAdGuardDomain
+	itemProcessPathRegex
+	itemNetworkType
+	itemNetworkIsExpensive
+	itemNetworkIsConstrained
+	itemFinal uint8 = 0xFF
+)
+
+// unsupportedItems names the item types that cannot be read, for errors.
+var unsupportedItems = map[uint8]string{
+	itemQueryType:            "query_type",
+	itemSourceIPCIDR:         "source_ip_cidr",
+	itemSourcePort:           "source_port",
+	itemSourcePortRange:      "source_port_range",
+	itemProcessName:          "process_name",
+	itemProcessPath:          "process_path",
+	itemPackageName:          "package_name",
+	itemWIFISSID:             "wifi_ssid",
+	itemWIFIBSSID:            "wifi_bssid",
+	itemAdGuardDomain:        "AdGuard domain rules",
+	itemProcessPathRegex:     "process_path_regex",
+	itemNetworkType:          "network_type",
+	itemNetworkIsExpensive:   "network_is_expensive",
+	itemNetworkIsConstrained: "network_is_constrained",
+}
+
+// Markers of domain suffixes in the keys of the domain trie. Keys are stored
+// reversed, so the marker is the first byte of a reversed key.
+const (
+	suffixLabel     = '\r' // followed by the suffix, e.g. "\r.example.com"
+	rootSuffixLabel = '\n' // followed by a root domain, e.g. "\nexample.com"
+)
+
+// ReadBinary reads a rule-set in the compiled binary format of sing-box (.srs):
+// the magic bytes "SRS", a version byte, and the zlib-compressed rules.
+func ReadBinary(r io.Reader) (*RuleSet, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	if [3]byte(header[:3]) != magicBytes {
+		return nil, errors.New("not a binary rule-set")
+	}
+	version := int(header[3])
+	if version < 1 || version > maxBinaryVersion {
+		return nil, fmt.Errorf("unsupported rule-set version %d", version)
+	}
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("read rules: %w", err)
+	}
+	defer func() { _ = zr.Close() }()
+	br := bufio.NewReader(zr)
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("read rule count: %w", err)
+	}
+	s := &RuleSet{Version: version}
+	for i := uint64(0); i < n; i++ {
+		rule, err := readRule(br)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		s.Rules = append(s.Rules, rule)
+	}
+	return s, nil
+}
+
+func readRule(r *bufio.Reader) (Rule, error) {
+	kind, err := r.ReadByte()
+	if err != nil {
+		return Rule{}, err
+	}
+	var rule Rule
+	switch kind {
+	case 0:
+		rule, err = readDefaultRule(r)
+	case 1:
+		rule, err = readLogicalRule(r)
+	default:
+		return Rule{}, fmt.Errorf("unknown rule type %d", kind)
+	}
+	if err != nil {
+		return Rule{}, err
+	}
+	return rule, rule.check()
+}
+
+func readDefaultRule(r *bufio.Reader) (Rule, error) {
+	rule := Rule{Type: RuleTypeDefault}
+	for {
+		item, err := r.ReadByte()
+		if err != nil {
+			return Rule{}, err
+		}
+		switch item {
+		case itemNetwork:
+			rule.Network, err = readStrings(r)
+		case itemDomain:
+			rule.Domain, rule.DomainSuffix, err = readDomains(r)
+		case itemDomainKeyword:
+			rule.DomainKeyword, err = readStrings(r)
+		case itemDomainRegex:
+			rule.DomainRegex, err = readStrings(r)
+		case itemIPCIDR:
+			rule.IPCIDR, err = readIPSet(r)
+		case itemPort:
+			rule.Port, err = readUint16s(r)
+		case itemPortRange:
+			rule.PortRange, err = readStrings(r)
+		case itemFinal:
+			rule.Invert, err = readBool(r)
+			return rule, err
+		default:
+			if name, ok := unsupportedItems[item]; ok {
+				return Rule{}, fmt.Errorf("unsupported field %s", name)
+			}
+			return Rule{}, fmt.Errorf("unknown item type %d", item)
+		}
+		if err != nil {
+			return Rule{}, err
+		}
+	}
+}
+
+func readLogicalRule(r *bufio.Reader) (Rule, error) {
+	rule := Rule{Type: RuleTypeLogical}
+	mode, err := r.ReadByte()
+	if err != nil {
+		return Rule{}, err
+	}
+	switch mode {
+	case 0:
+		rule.Mode = LogicalAnd
+	case 1:
+		rule.Mode = LogicalOr
+	default:
+		return Rule{}, fmt.Errorf("unknown logical mode %d", mode)
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return Rule{}, err
+	}
+	for i := uint64(0); i < n; i++ {
+		sub, err := readRule(r)
+		if err != nil {
+			return Rule{}, fmt.Errorf("sub-rule %d: %w", i+1, err)
+		}
+		rule.Rules = append(rule.Rules, sub)
+	}
+	rule.Invert, err = readBool(r)
+	return rule, err
+}
+
+func readBool(r *bufio.Reader) (bool, error) {
+	b, err := r.ReadByte()
+	return b != 0, err
+}
+
+// readLength reads the length of a list, which must fit in the remaining data.
+func readLength(r *bufio.Reader) (int, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > 1<<28 {
+		return 0, fmt.Errorf("invalid length %d", n)
+	}
+	return int(n), nil
+}
+
+func readBytes(r *bufio.Reader) ([]byte, error) {
+	n, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
+
+func readStrings(r *bufio.Reader) ([]string, error) {
+	n, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]string, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		b, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, string(b))
+	}
+	return list, nil
+}
+
+func readUint16s(r *bufio.Reader) ([]uint16, error) {
+	n, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]uint16, 0, min(n, 1024))
+	var b [2]byte
+	for i := 0; i < n; i++ {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		list = append(list, binary.BigEndian.Uint16(b[:]))
+	}
+	return list, nil
+}
+
+func readUint64s(r *bufio.Reader) ([]uint64, error) {
+	n, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]uint64, 0, min(n, 1024))
+	var b [8]byte
+	for i := 0; i < n; i++ {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		list = append(list, binary.BigEndian.Uint64(b[:]))
+	}
+	return list, nil
+}
+
+// readIPSet reads a set of IP ranges, and returns it as CIDRs.
+func readIPSet(r *bufio.Reader) ([]string, error) {
+	version, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if version != 1 {
+		return nil, fmt.Errorf("unsupported IP set version %d", version)
+	}
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint64(b[:])
+	var cidrs []string
+	for i := uint64(0); i < n; i++ {
+		from, err := readAddr(r)
+		if err != nil {
+			return nil, err
+		}
+		to, err := readAddr(r)
+		if err != nil {
+			return nil, err
+		}
+		if from.Is4() != to.Is4() || to.Less(from) {
+			return nil, fmt.Errorf("invalid IP range %s-%s", from, to)
+		}
+		for _, p := range rangePrefixes(from, to) {
+			cidrs = append(cidrs, p.String())
+		}
+	}
+	return cidrs, nil
+}
+
+func readAddr(r *bufio.Reader) (netip.Addr, error) {
+	b, err := readBytes(r)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	addr, ok := netip.AddrFromSlice(b)
+	if !ok {
+		return netip.Addr{}, fmt.Errorf("invalid IP address of %d bytes", len(b))
+	}
+	return addr, nil
+}
+
+// rangePrefixes returns the smallest list of prefixes covering the IP range
+// [from, to], which must be of the same family.
+func rangePrefixes(from, to netip.Addr) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for {
+		// The largest prefix starting at from that ends at or before to
+		bits := from.BitLen()
+		for bits > 0 {
+			p := netip.PrefixFrom(from, bits-1).Masked()
+			if p.Addr() != from || lastAddr(p).Compare(to) > 0 {
+				break
+			}
+			bits--
+		}
+		p := netip.PrefixFrom(from, bits)
+		prefixes = append(prefixes, p)
+		last := lastAddr(p)
+		if last.Compare(to) >= 0 {
+			return prefixes
+		}
+		from = last.Next()
+	}
+}
+
+// lastAddr returns the last address of prefix p.
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Masked().Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// readDomains reads the domain trie of a rule, and returns the domains and
+// domain suffixes it contains.
+func readDomains(r *bufio.Reader) (domains, suffixes []string, err error) {
+	version, err := r.ReadByte()
+	if err != nil {
+		return nil, nil, err
+	}
+	if version > 1 {
+		return nil, nil, fmt.Errorf("unsupported domain set version %d", version)
+	}
+	leaves, err := readUint64s(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	labelBitmap, err := readUint64s(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	labels, err := readBytes(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, err := trieKeys(leaves, labelBitmap, labels)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A root domain suffix "example.com" is stored either as "\nexample.com",
+	// or (in version 1 rule-sets) as both "example.com" and "\r.example.com".
+	exact := make(map[string]bool)
+	var dotSuffixes []string
+	for _, key := range keys {
+		key = reverseDomain(key)
+		switch {
+		case key == "":
+		case key[0] == suffixLabel:
+			dotSuffixes = append(dotSuffixes, key[1:])
+		case key[0] == rootSuffixLabel:
+			suffixes = append(suffixes, strings.TrimPrefix(key[1:], "."))
+		default:
+			exact[key] = true
+		}
+	}
+	for _, s := range dotSuffixes {
+		if root := s[1:]; len(s) > 1 && s[0] == '.' && exact[root] {
+			delete(exact, root)
+			suffixes = append(suffixes, root)
+			continue
+		}
+		suffixes = append(suffixes, s)
+	}
+	for _, key := range keys {
+		if d := reverseDomain(key); exact[d] {
+			domains = append(domains, d)
+		}
+	}
+	return domains, suffixes, nil
+}
+
+// trieKeys returns the keys of a LOUDS-encoded trie, in which the nodes are
+// numbered in breadth-first order. Each node is represented in labelBitmap by
+// a 0 bit for each of its children (whose labels are in labels, in the same
+// order) followed by a 1 bit. A node is the end of a key if its bit in leaves
+// is set.
+func trieKeys(leaves, labelBitmap []uint64, labels []byte) ([]string, error) {
+	nodes := len(labels) + 1
+	nodeKeys := make([]string, nodes)
+	node, child := 0, 1
+	for i := 0; node < nodes; i++ {
+		if i/64 >= len(labelBitmap) {
+			return nil, errors.New("truncated domain set")
+		}
+		if labelBitmap[i/64]>>(i%64)&1 != 0 {
+			node++
+			continue
+		}
+		if child >= nodes || node >= child {
+			return nil, errors.New("invalid domain set")
+		}
+		nodeKeys[child] = nodeKeys[node] + string(labels[child-1])
+		child++
+	}
+	var keys []string
+	for i, key := range nodeKeys {
+		if i/64 < len(leaves) && leaves[i/64]>>(i%64)&1 != 0 {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// reverseDomain reverses a string rune by rune, as the keys of the domain
+// trie are stored reversed.
+func reverseDomain(s string) string {
+	b := make([]byte, len(s))
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		i += n
+		if r == utf8.RuneError && n == 1 {
+			b[len(s)-i] = s[i-1] // invalid UTF-8, keep the byte
+			continue
+		}
+		utf8.EncodeRune(b[len(s)-i:], r)
+	}
+	return string(b)
+}
@@ -0,0 +1,327 @@
+package ruleset
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"maps"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// binaryWriter encodes rule-sets in the binary format, for tests.
+type binaryWriter struct {
+	bytes.Buffer
+}
+
+func (w *binaryWriter) uvarint(n int) {
+	w.Write(binary.AppendUvarint(nil, uint64(n)))
+}
+
+func (w *binaryWriter) bytes(b []byte) {
+	w.uvarint(len(b))
+	w.Write(b)
+}
+
+func (w *binaryWriter) strings(list []string) {
+	w.uvarint(len(list))
+	for _, s := range list {
+		w.bytes([]byte(s))
+	}
+}
+
+func (w *binaryWriter) uint64s(list []uint64) {
+	w.uvarint(len(list))
+	for _, n := range list {
+		w.Write(binary.BigEndian.AppendUint64(nil, n))
+	}
+}
+
+// domains writes a domain trie with the given keys, which are not reversed.
+func (w *binaryWriter) domains(keys []string) {
+	reversed := make([]string, len(keys))
+	for i, k := range keys {
+		reversed[i] = reverseDomain(k)
+	}
+	leaves, labelBitmap, labels := buildTrie(reversed)
+	w.WriteByte(1)
+	w.uint64s(leaves)
+	w.uint64s(labelBitmap)
+	w.bytes(labels)
+}
+
+// ipRanges writes an IP set of ranges, given as pairs of addresses.
+func (w *binaryWriter) ipRanges(addrs ...string) {
+	w.WriteByte(1)
+	w.Write(binary.BigEndian.AppendUint64(nil, uint64(len(addrs)/2)))
+	for _, a := range addrs {
+		w.bytes(netip.MustParseAddr(a).AsSlice())
+	}
+}
+
+// buildTrie encodes keys as a LOUDS trie, see trieKeys.
+func buildTrie(keys []string) (leaves, labelBitmap []uint64, labels []byte) {
+	type node struct {
+		children map[byte]*node
+		leaf     bool
+	}
+	root := &node{children: map[byte]*node{}}
+	for _, key := range keys {
+		n := root
+		for i := 0; i < len(key); i++ {
+			child, ok := n.children[key[i]]
+			if !ok {
+				child = &node{children: map[byte]*node{}}
+				n.children[key[i]] = child
+			}
+			n = child
+		}
+		n.leaf = true
+	}
+	setBit := func(bits *[]uint64, i int) {
+		for len(*bits) <= i/64 {
+			*bits = append(*bits, 0)
+		}
+		(*bits)[i/64] |= 1 << (i % 64)
+	}
+	queue := []*node{root}
+	bit := 0
+	for id := 0; id < len(queue); id++ {
+		n := queue[id]
+		if n.leaf {
+			setBit(&leaves, id)
+		}
+		for _, label := range slices.Sorted(maps.Keys(n.children)) {
+			labels = append(labels, label)
+			queue = append(queue, n.children[label])
+			bit++ // 0 bit for the child
+		}
+		setBit(&labelBitmap, bit)
+		bit++
+	}
+	return leaves, labelBitmap, labels
+}
+
+// encodeRuleSet returns a binary rule-set of the given version, with rules
+// written by the given function.
+func encodeRuleSet(t *testing.T, version byte, count int, rules func(w *binaryWriter)) []byte {
+	var body binaryWriter
+	body.uvarint(count)
+	rules(&body)
+	var buf bytes.Buffer
+	buf.Write(append(magicBytes[:], version))
+	zw := zlib.NewWriter(&buf)
+	_, err := zw.Write(body.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestReadBinary(t *testing.T) {
+	data := encodeRuleSet(t, 2, 3, func(w *binaryWriter) {
+		// Rule 1: domains, keywords and a regex
+		w.WriteByte(0)
+		w.WriteByte(itemDomain)
+		w.domains([]string{"www.example.com", "\nexample.org", "\r.example.net"})
+		w.WriteByte(itemDomainKeyword)
+		w.strings([]string{"ads"})
+		w.WriteByte(itemDomainRegex)
+		w.strings([]string{`^cdn\d+\.`})
+		w.WriteByte(itemFinal)
+		w.WriteByte(0)
+
+		// Rule 2: IP ranges, ports and network
+		w.WriteByte(0)
+		w.WriteByte(itemIPCIDR)
+		w.ipRanges("10.0.0.0", "10.255.255.255", "192.168.1.1", "192.168.1.6", "2001:db8::", "2001:db8::ffff")
+		w.WriteByte(itemPort)
+		w.uvarint(2)
+		w.Write([]byte{0, 80, 1, 187})
+		w.WriteByte(itemPortRange)
+		w.strings([]string{"8000:9000"})
+		w.WriteByte(itemNetwork)
+		w.strings([]string{"tcp"})
+		w.WriteByte(itemFinal)
+		w.WriteByte(0)
+
+		// Rule 3: inverted logical rule
+		w.WriteByte(1)
+		w.WriteByte(1) // or
+		w.uvarint(2)
+		w.WriteByte(0)
+		w.WriteByte(itemNetwork)
+		w.strings([]string{"udp"})
+		w.WriteByte(itemFinal)
+		w.WriteByte(0)
+		w.WriteByte(0)
+		w.WriteByte(itemPort)
+		w.uvarint(1)
+		w.Write([]byte{0, 53})
+		w.WriteByte(itemFinal)
+		w.WriteByte(1)
+		w.WriteByte(1) // invert
+	})
+
+	rs, err := Read(data)
+	require.NoError(t, err)
+	assert.Equal(t, 2, rs.Version)
+	require.Len(t, rs.Rules, 3)
+
+	r := rs.Rules[0]
+	assert.Equal(t, RuleTypeDefault, r.Type)
+	assert.Equal(t, []string{"www.example.com"}, r.Domain)
+	assert.ElementsMatch(t, []string{"example.org", ".example.net"}, r.DomainSuffix)
+	assert.Equal(t, []string{"ads"}, r.DomainKeyword)
+	assert.Equal(t, []string{`^cdn\d+\.`}, r.DomainRegex)
+
+	r = rs.Rules[1]
+	assert.Equal(t, []string{
+		"10.0.0.0/8",
+		"192.168.1.1/32", "192.168.1.2/31", "192.168.1.4/31", "192.168.1.6/32",
+		"2001:db8::/112",
+	}, r.IPCIDR)
+	assert.Equal(t, []uint16{80, 443}, r.Port)
+	assert.Equal(t, []string{"8000:9000"}, r.PortRange)
+	assert.Equal(t, []string{"tcp"}, r.Network)
+
+	r = rs.Rules[2]
+	assert.Equal(t, RuleTypeLogical, r.Type)
+	assert.Equal(t, LogicalOr, r.Mode)
+	assert.True(t, r.Invert)
+	require.Len(t, r.Rules, 2)
+	assert.Equal(t, []string{"udp"}, r.Rules[0].Network)
+	assert.Equal(t, []uint16{53}, r.Rules[1].Port)
+	assert.True(t, r.Rules[1].Invert)
+}
+
+func TestReadBinary_LegacyRootSuffix(t *testing.T) {
+	// Version 1 rule-sets store the suffix "example.com" as both the domain
+	// "example.com" and the suffix ".example.com".
+	data := encodeRuleSet(t, 1, 1, func(w *binaryWriter) {
+		w.WriteByte(0)
+		w.WriteByte(itemDomain)
+		w.domains([]string{"example.com", "\r.example.com", "a.example.com", "\r.b.com"})
+		w.WriteByte(itemFinal)
+		w.WriteByte(0)
+	})
+	rs, err := ReadBinary(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Len(t, rs.Rules, 1)
+	assert.Equal(t, []string{"a.example.com"}, rs.Rules[0].Domain)
+	assert.ElementsMatch(t, []string{"example.com", ".b.com"}, rs.Rules[0].DomainSuffix)
+}
+
+func TestReadBinary_Fixture(t *testing.T) {
+	// testdata/rules.srs is testdata/rules.json in the sing-box binary format,
+	// encoded following the writer in sing-box (common/srs) rather than
+	// binaryWriter: keys sorted before building the trie, best compression.
+	f, err := os.Open(filepath.Join("testdata", "rules.srs"))
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+	rs, err := ReadBinary(f)
+	require.NoError(t, err)
+	assert.Equal(t, 2, rs.Version)
+	require.Len(t, rs.Rules, 3)
+
+	r := rs.Rules[0]
+	assert.Equal(t, []string{"www.example.com"}, r.Domain)
+	assert.ElementsMatch(t, []string{"example.org", ".example.net"}, r.DomainSuffix)
+	assert.Equal(t, []string{"ads"}, r.DomainKeyword)
+	assert.Equal(t, []string{`^stun\.`}, r.DomainRegex)
+
+	r = rs.Rules[1]
+	assert.Equal(t, []string{"udp"}, r.Network)
+	assert.Equal(t, []string{"10.0.0.0/8", "2001:db8::/32"}, r.IPCIDR)
+	assert.Equal(t, []uint16{53, 443}, r.Port)
+	assert.Equal(t, []string{"1000:2000"}, r.PortRange)
+
+	r = rs.Rules[2]
+	assert.Equal(t, Rule{Type: RuleTypeLogical, Mode: LogicalOr, Invert: true, Rules: []Rule{
+		{Type: RuleTypeDefault, Domain: []string{"a.example"}},
+		{Type: RuleTypeDefault, IPCIDR: []string{"192.168.1.0/24"}, Invert: true},
+	}}, r)
+
+	// The source rule-set reads the same, up to the order of domain suffixes
+	source, err := os.ReadFile(filepath.Join("testdata", "rules.json"))
+	require.NoError(t, err)
+	want, err := ReadJSON(source)
+	require.NoError(t, err)
+	assert.Equal(t, want.Rules[1:], rs.Rules[1:])
+}
+
+func TestReadBinary_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"magic", []byte("XYZ\x01"), "not a binary rule-set"},
+		{"truncated", []byte("SR"), "read header"},
+		{"version", []byte("SRS\x09"), "unsupported rule-set version 9"},
+		{"unsupported item", encodeRuleSet(t, 1, 1, func(w *binaryWriter) {
+			w.WriteByte(0)
+			w.WriteByte(itemProcessName)
+			w.strings([]string{"curl"})
+			w.WriteByte(itemFinal)
+			w.WriteByte(0)
+		}), "rule 1: unsupported field process_name"},
+		{"unknown item", encodeRuleSet(t, 1, 1, func(w *binaryWriter) {
+			w.WriteByte(0)
+			w.WriteByte(0x80)
+		}), "unknown item type 128"},
+		{"invalid network", encodeRuleSet(t, 1, 1, func(w *binaryWriter) {
+			w.WriteByte(0)
+			w.WriteByte(itemNetwork)
+			w.strings([]string{"icmp"})
+			w.WriteByte(itemFinal)
+			w.WriteByte(0)
+		}), `unsupported network "icmp"`},
+		{"missing rules", encodeRuleSet(t, 1, 2, func(w *binaryWriter) {
+			w.WriteByte(0)
+			w.WriteByte(itemFinal)
+			w.WriteByte(0)
+		}), "rule 2: EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadBinary(bytes.NewReader(tt.data))
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestTrieKeys(t *testing.T) {
+	keys := []string{"a", "ab", "abc", "b", "ba", "xyz"}
+	leaves, labelBitmap, labels := buildTrie(keys)
+	got, err := trieKeys(leaves, labelBitmap, labels)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, keys, got)
+
+	_, err = trieKeys(leaves, labelBitmap[:0], labels)
+	assert.Error(t, err)
+}
+
+func TestRangePrefixes(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     []string
+	}{
+		{"0.0.0.0", "255.255.255.255", []string{"0.0.0.0/0"}},
+		{"1.1.1.1", "1.1.1.1", []string{"1.1.1.1/32"}},
+		{"10.0.0.255", "10.0.2.0", []string{"10.0.0.255/32", "10.0.1.0/24", "10.0.2.0/32"}},
+		{"::", "::1", []string{"::/127"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, p := range rangePrefixes(netip.MustParseAddr(tt.from), netip.MustParseAddr(tt.to)) {
+			got = append(got, p.String())
+		}
+		assert.Equal(t, tt.want, got, "%s-%s", tt.from, tt.to)
+	}
+}
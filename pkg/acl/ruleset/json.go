@@ -0,0 +1,170 @@
+package ruleset
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ReadJSON reads a rule-set in the sing-box JSON source format:
+//
+//	{
+//	  "version": 3,
+//	  "rules": [
+//	    {"domain_suffix": ["google.com"], "port": 443},
+//	    {"type": "logical", "mode": "and", "rules": [{"network": "udp"}, {"ip_cidr": "8.8.8.8/32"}]}
+//	  ]
+//	}
+//
+// As in sing-box, a list with a single item can be written as the item itself.
+// Fields that are not supported (such as "process_name") are reported as errors.
+func ReadJSON(data []byte) (*RuleSet, error) {
+	var raw struct {
+		Version int               `json:"version"`
+		Rules   []json.RawMessage `json:"rules"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid rule-set: %w", err)
+	}
+	s := &RuleSet{Version: raw.Version, Rules: make([]Rule, len(raw.Rules))}
+	for i, r := range raw.Rules {
+		if err := s.Rules[i].unmarshalJSON(r); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	return s, nil
+}
+
+func (r *Rule) unmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		value := fields[name]
+		var err error
+		switch name {
+		case "type":
+			err = json.Unmarshal(value, &r.Type)
+		case "mode":
+			err = json.Unmarshal(value, &r.Mode)
+		case "invert":
+			err = json.Unmarshal(value, &r.Invert)
+		case "rules":
+			var rules []json.RawMessage
+			err = json.Unmarshal(value, &rules)
+			r.Rules = make([]Rule, len(rules))
+			for i := 0; err == nil && i < len(rules); i++ {
+				if err = r.Rules[i].unmarshalJSON(rules[i]); err != nil {
+					err = fmt.Errorf("sub-rule %d: %w", i+1, err)
+				}
+			}
+		case "domain":
+			r.Domain, err = unmarshalListable[string](value)
+		case "domain_suffix":
+			r.DomainSuffix, err = unmarshalListable[string](value)
+		case "domain_keyword":
+			r.DomainKeyword, err = unmarshalListable[string](value)
+		case "domain_regex":
+			r.DomainRegex, err = unmarshalListable[string](value)
+		case "ip_cidr":
+			r.IPCIDR, err = unmarshalListable[string](value)
+		case "port":
+			r.Port, err = unmarshalListable[uint16](value)
+		case "port_range":
+			r.PortRange, err = unmarshalListable[string](value)
+		case "network":
+			r.Network, err = unmarshalListable[string](value)
+		default:
+			return fmt.Errorf("unsupported field %q", name)
+		}
+		if err != nil {
+			return fmt.Errorf("field %q: %w", name, err)
+		}
+	}
+	return r.check()
+}
+
+// unmarshalListable unmarshals a list, or a single value as a list of one.
+func unmarshalListable[T any](data []byte) ([]T, error) {
+	var list []T
+	if err := json.Unmarshal(data, &list); err == nil {
+		return list, nil
+	}
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return []T{v}, nil
+}
+
+// check normalizes the type and mode of the rule, and checks that it is
+// consistent.
+func (r *Rule) check() error {
+	switch r.Type {
+	case "", RuleTypeDefault:
+		r.Type = RuleTypeDefault
+		if r.Mode != "" || len(r.Rules) > 0 {
+			return fmt.Errorf("mode and rules are only allowed in logical rules")
+		}
+		for _, n := range r.Network {
+			if n != "tcp" && n != "udp" {
+				return fmt.Errorf("unsupported network %q", n)
+			}
+		}
+		for _, pr := range r.PortRange {
+			if _, _, err := ParsePortRange(pr); err != nil {
+				return err
+			}
+		}
+	case RuleTypeLogical:
+		r.Mode = strings.ToLower(r.Mode)
+		if r.Mode != LogicalAnd && r.Mode != LogicalOr {
+			return fmt.Errorf("invalid logical mode %q", r.Mode)
+		}
+		if len(r.Rules) == 0 {
+			return fmt.Errorf("logical rule without rules")
+		}
+		if r.HasAddress() || len(r.Port) > 0 || len(r.PortRange) > 0 || len(r.Network) > 0 {
+			return fmt.Errorf("logical rules cannot have conditions of their own")
+		}
+	default:
+		return fmt.Errorf("invalid rule type %q", r.Type)
+	}
+	return nil
+}
+
+// ParsePortRange parses a port range as used in PortRange: "1000:2000",
+// ":3000" (up to 3000) or "4000:" (from 4000).
+func ParsePortRange(s string) (start, end uint16, err error) {
+	startStr, endStr, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	start, end = 0, 65535
+	if startStr != "" {
+		if start, err = parsePort(startStr); err != nil {
+			return 0, 0, fmt.Errorf("invalid port range %q", s)
+		}
+	}
+	if endStr != "" {
+		if end, err = parsePort(endStr); err != nil {
+			return 0, 0, fmt.Errorf("invalid port range %q", s)
+		}
+	}
+	if start > end {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	return start, end, nil
+}
+
+func parsePort(s string) (uint16, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+	return uint16(port), err
+}
@@ -0,0 +1,152 @@
+// Package ruleset reads sing-box rule-sets, both the JSON source format and
+// the compiled binary (.srs) format.
+package ruleset
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/xflash-panda/acl-engine/pkg/acl/geodat"
+)
+
+// Rule types, see Rule.Type.
+const (
+	RuleTypeDefault = "default"
+	RuleTypeLogical = "logical"
+)
+
+// Logical rule modes, see Rule.Mode.
+const (
+	LogicalAnd = "and"
+	LogicalOr  = "or"
+)
+
+// RuleSet is a sing-box rule-set. It matches a connection if any of its rules does.
+type RuleSet struct {
+	Version int
+	Rules   []Rule
+}
+
+// Rule is a headless rule of a rule-set.
+//
+// A default rule matches if all of its conditions match, where the address
+// conditions (Domain, DomainSuffix, DomainKeyword, DomainRegex and IPCIDR)
+// count as one condition that matches if any of them does, and so do the port
+// conditions (Port and PortRange). A logical rule combines Rules with Mode.
+// Invert negates the result of either kind of rule.
+type Rule struct {
+	Type string // RuleTypeDefault or RuleTypeLogical
+
+	// Conditions of a default rule
+	Domain        []string
+	DomainSuffix  []string // "example.com" also matches example.com, ".example.com" only its subdomains
+	DomainKeyword []string
+	DomainRegex   []string
+	IPCIDR        []string
+	Port          []uint16
+	PortRange     []string // "1000:2000", ":3000" or "4000:"
+	Network       []string // "tcp" or "udp"
+
+	// Logical rule
+	Mode  string // LogicalAnd or LogicalOr
+	Rules []Rule
+
+	Invert bool
+}
+
+// HasAddress reports whether the rule has address conditions.
+func (r *Rule) HasAddress() bool {
+	return len(r.Domain) > 0 || len(r.DomainSuffix) > 0 || len(r.DomainKeyword) > 0 ||
+		len(r.DomainRegex) > 0 || len(r.IPCIDR) > 0
+}
+
+// Load reads a rule-set file, in either format.
+func Load(filename string) (*RuleSet, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Read(data)
+}
+
+// Read reads a rule-set, in the binary format if data starts with its magic
+// bytes, or in the JSON source format otherwise.
+func Read(data []byte) (*RuleSet, error) {
+	if bytes.HasPrefix(data, magicBytes[:]) {
+		return ReadBinary(bytes.NewReader(data))
+	}
+	return ReadJSON(data)
+}
+
+// GeoData returns the addresses matched by the rule-set, as GeoSite and GeoIP
+// lists (either of which may be empty) named code. It fails if the rule-set
+// has conditions other than addresses, or logical or inverted rules, since
+// those cannot be represented as lists.
+func (s *RuleSet) GeoData(code string) (*geodat.GeoSite, *geodat.GeoIP, error) {
+	site := &geodat.GeoSite{CountryCode: strings.ToUpper(code)}
+	ip := &geodat.GeoIP{CountryCode: strings.ToUpper(code)}
+	for i, r := range s.Rules {
+		switch {
+		case r.Type == RuleTypeLogical:
+			return nil, nil, fmt.Errorf("rule %d: logical rules cannot be used as address lists", i+1)
+		case r.Invert:
+			return nil, nil, fmt.Errorf("rule %d: inverted rules cannot be used as address lists", i+1)
+		case len(r.Port) > 0 || len(r.PortRange) > 0 || len(r.Network) > 0:
+			return nil, nil, fmt.Errorf("rule %d: port and network conditions cannot be used in address lists", i+1)
+		case !r.HasAddress():
+			return nil, nil, fmt.Errorf("rule %d: rule has no address conditions", i+1)
+		}
+		for _, d := range r.Domain {
+			site.Domain = append(site.Domain, &geodat.Domain{Type: geodat.Domain_Full, Value: d})
+		}
+		for _, d := range r.DomainSuffix {
+			if sub, ok := strings.CutPrefix(d, "."); ok {
+				// Subdomains only, which no other domain type expresses
+				site.Domain = append(site.Domain, &geodat.Domain{
+					Type:  geodat.Domain_Regex,
+					Value: `\.` + regexp.QuoteMeta(sub) + "$",
+				})
+				continue
+			}
+			site.Domain = append(site.Domain, &geodat.Domain{Type: geodat.Domain_RootDomain, Value: d})
+		}
+		for _, k := range r.DomainKeyword {
+			site.Domain = append(site.Domain, &geodat.Domain{Type: geodat.Domain_Plain, Value: k})
+		}
+		for _, re := range r.DomainRegex {
+			site.Domain = append(site.Domain, &geodat.Domain{Type: geodat.Domain_Regex, Value: re})
+		}
+		for _, c := range r.IPCIDR {
+			cidr, err := parseCIDR(c)
+			if err != nil {
+				return nil, nil, fmt.Errorf("rule %d: %w", i+1, err)
+			}
+			ip.Cidr = append(ip.Cidr, cidr)
+		}
+	}
+	return site, ip, nil
+}
+
+// parseCIDR parses a CIDR or a single IP address.
+func parseCIDR(s string) (*geodat.CIDR, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP CIDR %q", s)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &geodat.CIDR{Ip: ip4, Prefix: 32}, nil
+		}
+		return &geodat.CIDR{Ip: ip, Prefix: 128}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP CIDR %q", s)
+	}
+	ones, _ := ipNet.Mask.Size()
+	return &geodat.CIDR{Ip: ipNet.IP, Prefix: uint32(ones)}, nil // #nosec G115 -- at most 128
+}
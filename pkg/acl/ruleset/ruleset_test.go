@@ -0,0 +1,144 @@
+package ruleset
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xflash-panda/acl-engine/pkg/acl/geodat"
+)
+
+func TestReadJSON(t *testing.T) {
+	rs, err := Read([]byte(`{
+  "version": 3,
+  "rules": [
+    {"domain": "www.example.com", "domain_suffix": [".example.net", "example.org"]},
+    {"ip_cidr": ["10.0.0.0/8", "2001:db8::/32"], "port": 443, "port_range": ["8000:9000", ":22"], "network": "tcp"},
+    {"type": "logical", "mode": "AND", "rules": [{"network": ["udp"]}, {"domain_keyword": "ads", "invert": true}]}
+  ]
+}`))
+	require.NoError(t, err)
+	assert.Equal(t, &RuleSet{Version: 3, Rules: []Rule{
+		{Type: RuleTypeDefault, Domain: []string{"www.example.com"}, DomainSuffix: []string{".example.net", "example.org"}},
+		{
+			Type: RuleTypeDefault, IPCIDR: []string{"10.0.0.0/8", "2001:db8::/32"},
+			Port: []uint16{443}, PortRange: []string{"8000:9000", ":22"}, Network: []string{"tcp"},
+		},
+		{Type: RuleTypeLogical, Mode: LogicalAnd, Rules: []Rule{
+			{Type: RuleTypeDefault, Network: []string{"udp"}},
+			{Type: RuleTypeDefault, DomainKeyword: []string{"ads"}, Invert: true},
+		}},
+	}}, rs)
+}
+
+func TestReadJSON_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr string
+	}{
+		{"invalid", `{"rules": [`, "invalid rule-set"},
+		{"unknown top-level field", `{"rules": [], "extra": 1}`, "invalid rule-set"},
+		{"unsupported field", `{"rules": [{"process_name": "curl"}]}`, `rule 1: unsupported field "process_name"`},
+		{"nested unsupported field", `{"rules": [{"type": "logical", "mode": "or", "rules": [{"domain": "a.com"}, {"wifi_ssid": "x"}]}]}`,
+			`rule 1: field "rules": sub-rule 2: unsupported field "wifi_ssid"`},
+		{"invalid port", `{"rules": [{"port": "http"}]}`, `field "port"`},
+		{"invalid port range", `{"rules": [{"port_range": "9000:8000"}]}`, `invalid port range "9000:8000"`},
+		{"invalid network", `{"rules": [{"network": "icmp"}]}`, `unsupported network "icmp"`},
+		{"invalid type", `{"rules": [{"type": "headless"}]}`, `invalid rule type "headless"`},
+		{"invalid mode", `{"rules": [{"type": "logical", "mode": "xor", "rules": [{"domain": "a.com"}]}]}`, `invalid logical mode "xor"`},
+		{"empty logical", `{"rules": [{"type": "logical", "mode": "and"}]}`, "logical rule without rules"},
+		{"logical conditions", `{"rules": [{"type": "logical", "mode": "and", "domain": "a.com", "rules": [{"port": 1}]}]}`,
+			"logical rules cannot have conditions of their own"},
+		{"mode in default", `{"rules": [{"mode": "and", "domain": "a.com"}]}`, "only allowed in logical rules"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadJSON([]byte(tt.json))
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		s          string
+		start, end uint16
+		wantErr    bool
+	}{
+		{"1000:2000", 1000, 2000, false},
+		{":3000", 0, 3000, false},
+		{"4000:", 4000, 65535, false},
+		{"80:80", 80, 80, false},
+		{"80", 0, 0, true},
+		{"2000:1000", 0, 0, true},
+		{"1:70000", 0, 0, true},
+		{"a:b", 0, 0, true},
+	}
+	for _, tt := range tests {
+		start, end, err := ParsePortRange(tt.s)
+		if tt.wantErr {
+			assert.Error(t, err, tt.s)
+			continue
+		}
+		require.NoError(t, err, tt.s)
+		assert.Equal(t, [2]uint16{tt.start, tt.end}, [2]uint16{start, end}, tt.s)
+	}
+}
+
+func TestRuleSet_GeoData(t *testing.T) {
+	rs := &RuleSet{Rules: []Rule{
+		{Type: RuleTypeDefault, Domain: []string{"www.example.com"}, DomainSuffix: []string{"example.org", ".example.net"}},
+		{Type: RuleTypeDefault, DomainKeyword: []string{"ads"}, DomainRegex: []string{`^cdn\d+\.`}},
+		{Type: RuleTypeDefault, IPCIDR: []string{"10.0.0.0/8", "1.1.1.1", "2001:db8::1/32"}},
+	}}
+	site, ip, err := rs.GeoData("test")
+	require.NoError(t, err)
+	assert.Equal(t, "TEST", site.CountryCode)
+	assert.Equal(t, []*geodat.Domain{
+		{Type: geodat.Domain_Full, Value: "www.example.com"},
+		{Type: geodat.Domain_RootDomain, Value: "example.org"},
+		{Type: geodat.Domain_Regex, Value: `\.example\.net$`},
+		{Type: geodat.Domain_Plain, Value: "ads"},
+		{Type: geodat.Domain_Regex, Value: `^cdn\d+\.`},
+	}, site.Domain)
+	assert.Equal(t, []*geodat.CIDR{
+		{Ip: net.IP{10, 0, 0, 0}, Prefix: 8},
+		{Ip: net.IP{1, 1, 1, 1}, Prefix: 32},
+		{Ip: net.ParseIP("2001:db8::"), Prefix: 32},
+	}, ip.Cidr)
+
+	tests := []struct {
+		name    string
+		rule    Rule
+		wantErr string
+	}{
+		{"logical", Rule{Type: RuleTypeLogical, Mode: LogicalOr, Rules: []Rule{{Domain: []string{"a.com"}}}}, "logical rules"},
+		{"invert", Rule{Type: RuleTypeDefault, Domain: []string{"a.com"}, Invert: true}, "inverted rules"},
+		{"port", Rule{Type: RuleTypeDefault, Domain: []string{"a.com"}, Port: []uint16{443}}, "port and network conditions"},
+		{"network only", Rule{Type: RuleTypeDefault, Network: []string{"udp"}}, "port and network conditions"},
+		{"empty", Rule{Type: RuleTypeDefault}, "rule has no address conditions"},
+		{"invalid cidr", Rule{Type: RuleTypeDefault, IPCIDR: []string{"10.0.0.0/33"}}, `invalid IP CIDR "10.0.0.0/33"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := (&RuleSet{Rules: []Rule{tt.rule}}).GeoData("test")
+			assert.ErrorContains(t, err, "rule 1: "+tt.wantErr)
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	jsonFile := filepath.Join(dir, "a.json")
+	require.NoError(t, os.WriteFile(jsonFile, []byte(`{"version": 1, "rules": [{"domain": "a.com"}]}`), 0o644))
+	rs, err := Load(jsonFile)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.com"}, rs.Rules[0].Domain)
+
+	_, err = Load(filepath.Join(dir, "missing.srs"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,183 @@
+package acl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xflash-panda/acl-engine/pkg/acl/ruleset"
+)
+
+func TestRuleSetTextRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule ruleset.Rule
+		want string // formatted ACL rule
+	}{
+		{"domain", ruleset.Rule{Domain: []string{"www.example.com"}}, "proxy(www.example.com)"},
+		{"domain wildcard", ruleset.Rule{Domain: []string{"*.example.com"}}, "proxy(full:*.example.com)"},
+		{"suffix", ruleset.Rule{DomainSuffix: []string{"example.com"}}, "proxy(suffix:example.com)"},
+		{"dot suffix", ruleset.Rule{DomainSuffix: []string{".example.com"}}, "proxy(*.example.com)"},
+		{"keyword", ruleset.Rule{DomainKeyword: []string{"ads"}}, "proxy(keyword:ads)"},
+		{"regex", ruleset.Rule{DomainRegex: []string{`^cdn\d+\.`}}, `proxy(regexp:^cdn\d+\.)`},
+		{"cidr", ruleset.Rule{IPCIDR: []string{"10.0.0.0/8"}}, "proxy(10.0.0.0/8)"},
+		{"quoted regex", ruleset.Rule{Domain: []string{"b.com"}, DomainRegex: []string{`^a{1,3}\.com$`}},
+			`proxy(or(b.com, "regexp:^a{1,3}\.com$"))`},
+		{"addresses", ruleset.Rule{Domain: []string{"a.com"}, IPCIDR: []string{"1.1.1.1"}}, "proxy(or(a.com, 1.1.1.1))"},
+		{"ports", ruleset.Rule{Port: []uint16{80, 443}, PortRange: []string{"8000:9000"}}, `proxy(all, "*/80,443,8000-9000")`},
+		{"network", ruleset.Rule{Domain: []string{"a.com"}, Network: []string{"udp"}, Port: []uint16{443}}, "proxy(a.com, udp/443)"},
+		{"invert host", ruleset.Rule{IPCIDR: []string{"10.0.0.0/8"}, Invert: true}, "proxy(!10.0.0.0/8)"},
+		{"invert port", ruleset.Rule{Port: []uint16{22}, Invert: true}, `proxy(all, "*/0-21,23-65535")`},
+		{"and", ruleset.Rule{Type: ruleset.RuleTypeLogical, Mode: ruleset.LogicalAnd, Rules: []ruleset.Rule{
+			{Domain: []string{"a.com"}}, {Network: []string{"tcp"}},
+		}}, "proxy(a.com, tcp)"},
+		{"or", ruleset.Rule{Type: ruleset.RuleTypeLogical, Mode: ruleset.LogicalOr, Rules: []ruleset.Rule{
+			{Domain: []string{"a.com"}}, {DomainSuffix: []string{"b.com"}},
+		}}, "proxy(or(a.com, suffix:b.com))"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := RuleSetTextRules(&ruleset.RuleSet{Rules: []ruleset.Rule{tt.rule}}, "proxy")
+			require.NoError(t, err)
+			require.Len(t, rules, 1)
+			assert.Equal(t, tt.want+"\n", Format(rules))
+		})
+	}
+}
+
+func TestRuleSetTextRules_RegexComma(t *testing.T) {
+	rules, err := RuleSetTextRules(&ruleset.RuleSet{Rules: []ruleset.Rule{
+		{Domain: []string{"b.com"}, DomainRegex: []string{`^a{1,3}\.com$`, `^(x|y),?\.net$`}},
+	}}, "proxy")
+	require.NoError(t, err)
+	rs, err := Compile(rules, map[string]string{"proxy": "PROXY"}, 0, &NilGeoLoader{})
+	require.NoError(t, err)
+	for name, want := range map[string]string{
+		"b.com": "PROXY", "aaa.com": "PROXY", "aaaa.com": "", "y.net": "PROXY", "z.net": "",
+	} {
+		got, _ := rs.Match(HostInfo{Name: name}, ProtocolTCP, 443)
+		assert.Equal(t, want, got, name)
+	}
+}
+
+func TestRuleSetTextRules_Errors(t *testing.T) {
+	rs := &ruleset.RuleSet{Rules: []ruleset.Rule{
+		{Domain: []string{"a.com"}},
+		{Type: ruleset.RuleTypeLogical, Mode: ruleset.LogicalOr, Rules: []ruleset.Rule{
+			{Domain: []string{"b.com"}}, {Network: []string{"udp"}},
+		}},
+		{IPCIDR: []string{"10.0.0.0/33"}},
+		{Domain: []string{"geoip:cn"}},
+		{IPCIDR: []string{"1.1.1.1"}},
+		{DomainKeyword: []string{"ad server"}},
+	}}
+	_, err := RuleSetTextRules(rs, "direct", WithSource("test.srs"))
+	var ce *CompilationError
+	require.ErrorAs(t, err, &ce)
+	assert.Equal(t, 2, ce.LineNum)
+	assert.Contains(t, ce.Message, "OR cannot combine host and port sub-rules")
+
+	rules, err := RuleSetTextRules(rs, "direct", WithSource("test.srs"), WithAllErrors())
+	var errs ErrorList
+	require.ErrorAs(t, err, &errs)
+	require.Len(t, errs, 4)
+	assert.Contains(t, errs[1].Error(), "invalid IP CIDR 10.0.0.0/33")
+	assert.Contains(t, errs[2].Error(), "invalid domain geoip:cn")
+	assert.Contains(t, errs[3].Error(), "invalid domain ad server")
+	require.Len(t, rules, 2)
+	assert.Equal(t, TextRule{Outbound: "direct", Address: "a.com", Source: "test.srs", LineNum: 1}, rules[0])
+	assert.Equal(t, 5, rules[1].LineNum)
+}
+
+func TestCompile_RuleSet(t *testing.T) {
+	loader := newTestGeoLoader()
+	loader.RuleSet = map[string]*ruleset.RuleSet{
+		"ads": {Rules: []ruleset.Rule{
+			{Type: ruleset.RuleTypeDefault, DomainSuffix: []string{"ads.com", ".track.net"}},
+			{Type: ruleset.RuleTypeDefault, DomainKeyword: []string{"adserver"}, IPCIDR: []string{"6.6.6.0/24"}},
+		}},
+		"ports": {Rules: []ruleset.Rule{{Type: ruleset.RuleTypeDefault, Port: []uint16{22}}}},
+	}
+	outbounds := map[string]string{"direct": "DIRECT", "reject": "REJECT"}
+	rules, err := ParseTextRules("reject(ruleset:ads)\ndirect(all)\n")
+	require.NoError(t, err)
+	rs, err := Compile(rules, outbounds, 100, loader)
+	require.NoError(t, err)
+	tests := []struct {
+		host HostInfo
+		want string
+	}{
+		{HostInfo{Name: "ads.com"}, "REJECT"},
+		{HostInfo{Name: "x.ads.com"}, "REJECT"},
+		{HostInfo{Name: "track.net"}, "DIRECT"},
+		{HostInfo{Name: "a.track.net"}, "REJECT"},
+		{HostInfo{Name: "my-adserver.org"}, "REJECT"},
+		{HostInfo{IPv4: []byte{6, 6, 6, 6}}, "REJECT"},
+		{HostInfo{Name: "example.com"}, "DIRECT"},
+	}
+	for _, tt := range tests {
+		got, _ := rs.Match(tt.host, ProtocolTCP, 443)
+		assert.Equal(t, tt.want, got, "%s", tt.host)
+	}
+
+	for rule, wantErr := range map[string]string{
+		"reject(ruleset:missing)": "rule-set missing not found",
+		"reject(ruleset:ports)":   "rule-set ports cannot be used as an address",
+		"reject(ruleset:)":        "empty rule-set name",
+		"reject(ruleset:../x)":    "invalid rule-set name ../x",
+	} {
+		rules, err := ParseTextRules(rule)
+		require.NoError(t, err)
+		_, err = Compile(rules, outbounds, 100, loader)
+		assert.ErrorContains(t, err, wantErr, rule)
+	}
+
+	rules, err = ParseTextRules("reject(ruleset:ads)")
+	require.NoError(t, err)
+	_, err = Compile(rules, outbounds, 100, &struct{ GeoLoader }{&NilGeoLoader{}})
+	assert.ErrorContains(t, err, "not supported by the GeoLoader")
+}
+
+func TestFileGeoLoader_LoadRuleSet(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ads.json"),
+		[]byte(`{"version": 1, "rules": [{"domain_suffix": "ads.com"}]}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.json"), []byte(`{"rules": [{"process_name": "x"}]}`), 0o644))
+
+	loader := &FileGeoLoader{RuleSetDir: dir}
+	rs, err := loader.LoadRuleSet("ads")
+	require.NoError(t, err)
+	require.NotNil(t, rs)
+	assert.Equal(t, []string{"ads.com"}, rs.Rules[0].DomainSuffix)
+
+	// Cached after the first call
+	require.NoError(t, os.Remove(filepath.Join(dir, "ads.json")))
+	rs2, err := loader.LoadRuleSet("ads")
+	require.NoError(t, err)
+	assert.Same(t, rs, rs2)
+
+	rs, err = loader.LoadRuleSet("missing")
+	assert.NoError(t, err)
+	assert.Nil(t, rs)
+
+	_, err = loader.LoadRuleSet("bad")
+	assert.ErrorContains(t, err, "unsupported field")
+
+	_, err = loader.LoadRuleSet("../ads")
+	assert.ErrorContains(t, err, "invalid rule-set name")
+
+	// Without RuleSetDir, there are no rule-sets
+	rs, err = (&FileGeoLoader{}).LoadRuleSet("ads")
+	assert.NoError(t, err)
+	assert.Nil(t, rs)
+
+	// AutoGeoLoader uses DataDir by default
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ads.json"), []byte(`{"rules": [{"domain": "a.com"}]}`), 0o644))
+	rs, err = (&AutoGeoLoader{DataDir: dir}).LoadRuleSet("ads")
+	require.NoError(t, err)
+	require.NotNil(t, rs)
+	assert.Equal(t, []string{"a.com"}, rs.Rules[0].Domain)
+}
@@ -0,0 +1,221 @@
+package acl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule is a compiled schedule condition, e.g. "mon-fri 09:00-17:00 +08:00".
+// A rule with a schedule only matches while the schedule is active.
+type schedule struct {
+	Days   [7]bool // indexed by time.Weekday
+	Ranges []timeRange
+	Loc    *time.Location
+}
+
+// timeRange is a daily time window in seconds since midnight, [Start, End).
+// If End <= Start, the window spans midnight and ends on the next day.
+type timeRange struct {
+	Start int
+	End   int
+}
+
+const secondsPerDay = 24 * 60 * 60
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseSchedule parses a schedule condition. It is a whitespace-separated list
+// of up to one of each of the following, in any order:
+//
+//	mon-fri,sun              weekdays: names, ranges, "weekdays" or "weekends" (default: every day)
+//	09:00-12:00,13:00-17:00  time ranges, "22:00-06:00" spans midnight (default: all day)
+//	Europe/Berlin            time zone: IANA name, "UTC", "Local" or an offset like "+08:00" (default: Local)
+//
+// A time range spanning midnight belongs to the day it starts on.
+func parseSchedule(s string) (*schedule, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty schedule")
+	}
+	sch := &schedule{Loc: time.Local}
+	var haveDays, haveRanges, haveLoc bool
+	for _, f := range fields {
+		switch {
+		case f[0] >= '0' && f[0] <= '9' && strings.Contains(f, ":"):
+			if haveRanges {
+				return nil, fmt.Errorf("duplicate time ranges %q", f)
+			}
+			ranges, err := parseTimeRanges(f)
+			if err != nil {
+				return nil, err
+			}
+			sch.Ranges, haveRanges = ranges, true
+		case isTimeZone(f):
+			if haveLoc {
+				return nil, fmt.Errorf("duplicate time zone %q", f)
+			}
+			loc, err := parseTimeZone(f)
+			if err != nil {
+				return nil, err
+			}
+			sch.Loc, haveLoc = loc, true
+		default:
+			if haveDays {
+				return nil, fmt.Errorf("duplicate weekdays %q", f)
+			}
+			if err := parseWeekdays(f, &sch.Days); err != nil {
+				return nil, err
+			}
+			haveDays = true
+		}
+	}
+	if !haveDays {
+		sch.Days = [7]bool{true, true, true, true, true, true, true}
+	}
+	if !haveRanges {
+		sch.Ranges = []timeRange{{0, secondsPerDay}}
+	}
+	return sch, nil
+}
+
+func parseWeekdays(s string, days *[7]bool) error {
+	for _, item := range strings.Split(strings.ToLower(s), ",") {
+		switch item {
+		case "weekdays":
+			item = "mon-fri"
+		case "weekends":
+			item = "sat-sun"
+		}
+		startStr, endStr, isRange := strings.Cut(item, "-")
+		start, ok := weekdayNames[startStr]
+		if !ok {
+			return fmt.Errorf("invalid weekday %q", startStr)
+		}
+		end := start
+		if isRange {
+			if end, ok = weekdayNames[endStr]; !ok {
+				return fmt.Errorf("invalid weekday %q", endStr)
+			}
+		}
+		// Ranges may wrap around the end of the week, e.g. "sat-mon"
+		for d := start; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == end {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func parseTimeRanges(s string) ([]timeRange, error) {
+	var ranges []timeRange
+	for _, item := range strings.Split(s, ",") {
+		startStr, endStr, ok := strings.Cut(item, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid time range %q (expected HH:MM-HH:MM)", item)
+		}
+		start, err := parseTimeOfDay(startStr)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(endStr)
+		if err != nil {
+			return nil, err
+		}
+		if start == end {
+			return nil, fmt.Errorf("empty time range %q", item)
+		}
+		ranges = append(ranges, timeRange{start, end})
+	}
+	return ranges, nil
+}
+
+// parseTimeOfDay parses "HH:MM" into seconds since midnight. "24:00" is allowed.
+func parseTimeOfDay(s string) (int, error) {
+	hStr, mStr, ok := strings.Cut(s, ":")
+	h, herr := strconv.Atoi(hStr)
+	m, merr := strconv.Atoi(mStr)
+	if !ok || herr != nil || merr != nil || len(mStr) != 2 ||
+		h < 0 || m < 0 || m > 59 || h > 24 || h == 24 && m != 0 {
+		return 0, fmt.Errorf("invalid time %q (expected HH:MM)", s)
+	}
+	return (h*60 + m) * 60, nil
+}
+
+func isTimeZone(s string) bool {
+	return strings.Contains(s, "/") || s[0] == '+' || s[0] == '-' ||
+		strings.EqualFold(s, "utc") || strings.EqualFold(s, "local")
+}
+
+func parseTimeZone(s string) (*time.Location, error) {
+	switch {
+	case strings.EqualFold(s, "utc"):
+		return time.UTC, nil
+	case strings.EqualFold(s, "local"):
+		return time.Local, nil
+	case s[0] == '+' || s[0] == '-':
+		t, err := time.Parse("-07:00", s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone offset %q (expected +HH:MM)", s)
+		}
+		_, offset := t.Zone()
+		return time.FixedZone(s, offset), nil
+	default:
+		loc, err := time.LoadLocation(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", s, err)
+		}
+		return loc, nil
+	}
+}
+
+// Active reports whether the schedule is active at t.
+func (s *schedule) Active(t time.Time) bool {
+	t = t.In(s.Loc)
+	day := t.Weekday()
+	yesterday := (day + 6) % 7
+	sec := t.Hour()*3600 + t.Minute()*60 + t.Second()
+	for _, r := range s.Ranges {
+		if r.Start < r.End {
+			if s.Days[day] && sec >= r.Start && sec < r.End {
+				return true
+			}
+			continue
+		}
+		// Spans midnight
+		if s.Days[day] && sec >= r.Start || s.Days[yesterday] && sec < r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// NextChange returns the earliest time after t at which Active may change.
+// Changes happen at the start and end of time ranges, and at midnight, where
+// the weekday changes.
+func (s *schedule) NextChange(t time.Time) time.Time {
+	t = t.In(s.Loc)
+	year, month, day := t.Date()
+	next := time.Date(year, month, day+1, 0, 0, 0, 0, s.Loc)
+	for _, r := range s.Ranges {
+		for _, sec := range [2]int{r.Start, r.End} {
+			// time.Date handles daylight saving time transitions
+			b := time.Date(year, month, day, 0, 0, sec, 0, s.Loc)
+			if b.After(t) && b.Before(next) {
+				next = b
+			}
+		}
+	}
+	return next
+}
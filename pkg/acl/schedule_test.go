@@ -0,0 +1,111 @@
+package acl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseSchedule(t *testing.T) {
+	all := [7]bool{true, true, true, true, true, true, true}
+	tests := []struct {
+		name       string
+		s          string
+		wantDays   [7]bool
+		wantRanges []timeRange
+		wantOffset int // seconds east of UTC, checked if wantErr is empty
+		wantErr    string
+	}{
+		{"days only", "mon-fri", [7]bool{false, true, true, true, true, true, false}, []timeRange{{0, 86400}}, 0, ""},
+		{"wrapping days", "fri-mon", [7]bool{true, true, false, false, false, true, true}, []timeRange{{0, 86400}}, 0, ""},
+		{"day list", "Weekends,wed", [7]bool{true, false, false, true, false, false, true}, []timeRange{{0, 86400}}, 0, ""},
+		{"ranges only", "09:00-12:00,13:00-24:00", all, []timeRange{{32400, 43200}, {46800, 86400}}, 0, ""},
+		{"overnight", "22:30-06:00 UTC", all, []timeRange{{81000, 21600}}, 0, ""},
+		{"everything", "+08:00 weekdays 9:00-17:00", [7]bool{false, true, true, true, true, true, false}, []timeRange{{32400, 61200}}, 8 * 3600, ""},
+		{"negative offset", "-05:30", all, []timeRange{{0, 86400}}, -(5*3600 + 1800), ""},
+		{"empty", " ", all, nil, 0, "empty schedule"},
+		{"bad day", "mon-fry", all, nil, 0, `invalid weekday "fry"`},
+		{"bad time", "09:00-17:60", all, nil, 0, `invalid time "17:60"`},
+		{"empty range", "09:00-09:00", all, nil, 0, `empty time range "09:00-09:00"`},
+		{"missing end", "09:00", all, nil, 0, "expected HH:MM-HH:MM"},
+		{"bad offset", "+8", all, nil, 0, `invalid time zone offset "+8"`},
+		{"bad zone", "Mars/Olympus_Mons", all, nil, 0, `invalid time zone "Mars/Olympus_Mons"`},
+		{"duplicate days", "mon tue", all, nil, 0, `duplicate weekdays "tue"`},
+		{"duplicate ranges", "09:00-10:00 11:00-12:00", all, nil, 0, "duplicate time ranges"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSchedule(tt.s)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantDays, got.Days)
+			assert.Equal(t, tt.wantRanges, got.Ranges)
+			if tt.wantOffset != 0 || got.Loc != time.Local {
+				_, offset := time.Date(2024, 1, 1, 0, 0, 0, 0, got.Loc).Zone()
+				assert.Equal(t, tt.wantOffset, offset)
+			}
+		})
+	}
+}
+
+func Test_schedule_Active(t *testing.T) {
+	// 2024-06-07 is a Friday
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2024, 6, day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name string
+		s    string
+		t    time.Time
+		want bool
+	}{
+		{"in range", "mon-fri 09:00-17:00 UTC", at(7, 9, 0), true},
+		{"range end is exclusive", "mon-fri 09:00-17:00 UTC", at(7, 17, 0), false},
+		{"wrong day", "mon-fri 09:00-17:00 UTC", at(8, 12, 0), false},
+		{"second range", "09:00-10:00,14:00-15:00 UTC", at(7, 14, 30), true},
+		{"between ranges", "09:00-10:00,14:00-15:00 UTC", at(7, 12, 0), false},
+		{"overnight evening", "fri 22:00-06:00 UTC", at(7, 23, 0), true},
+		{"overnight next morning", "fri 22:00-06:00 UTC", at(8, 5, 59), true},
+		{"overnight wrong start day", "fri 22:00-06:00 UTC", at(7, 5, 0), false},
+		{"time zone", "fri 09:00-17:00 +08:00", at(7, 1, 0), true},
+		{"time zone shifts day", "sat +08:00", at(7, 16, 0), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sch, err := parseSchedule(tt.s)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, sch.Active(tt.t))
+		})
+	}
+}
+
+func Test_schedule_NextChange(t *testing.T) {
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2024, 6, day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name string
+		s    string
+		t    time.Time
+		want time.Time
+	}{
+		{"before range", "09:00-17:00 UTC", at(7, 8, 0), at(7, 9, 0)},
+		{"in range", "09:00-17:00 UTC", at(7, 9, 0), at(7, 17, 0)},
+		{"after range", "09:00-17:00 UTC", at(7, 18, 0), at(8, 0, 0)},
+		{"overnight", "22:00-06:00 UTC", at(7, 7, 0), at(7, 22, 0)},
+		{"days only", "mon UTC", at(7, 12, 0), at(8, 0, 0)},
+		{"time zone", "09:00-17:00 +08:00", at(7, 0, 0), at(7, 1, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sch, err := parseSchedule(tt.s)
+			require.NoError(t, err)
+			assert.True(t, tt.want.Equal(sch.NextChange(tt.t)), "got %v, want %v", sch.NextChange(tt.t), tt.want)
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package acl
+
+import (
+	"fmt"
+	"strings"
+)
+
+type setState int
+
+const (
+	setPending setState = iota
+	setCompiling
+	setCompiled
+)
+
+// addressSet is a named list of addresses, defined in an ACL file as
+//
+//	@lan = 10.0.0.0/8, 192.168.0.0/16, fd00::/8
+//
+// and referenced from rules as "@lan". A set matches a host if any of its
+// addresses does.
+type addressSet struct {
+	Rule    *TextRule
+	Matcher hostMatcher
+	Err     error
+	State   setState
+}
+
+// define registers an address set definition.
+func (c *compiler) define(rule *TextRule) *CompilationError {
+	name := strings.ToLower(rule.SetName)
+	if prev, ok := c.sets[name]; ok {
+		return &CompilationError{
+			Source:  rule.Source,
+			LineNum: rule.LineNum,
+			Column:  rule.Column,
+			Message: fmt.Sprintf("address set @%s already defined at %s", rule.SetName,
+				formatLocation(prev.Rule.Source, prev.Rule.LineNum, 0)),
+		}
+	}
+	set := &addressSet{Rule: rule}
+	c.sets[name] = set
+	c.setOrder = append(c.setOrder, set)
+	return nil
+}
+
+// resolveSet returns the shared matcher of an address set, compiling it on
+// first use. pos is the position of the reference, used to locate errors.
+func (c *compiler) resolveSet(name string, pos Position) (hostMatcher, error) {
+	set, ok := c.sets[name]
+	if !ok {
+		return nil, addressErrorAt(pos, suggest(name, mapKeys(c.sets)), "undefined address set @%s", name)
+	}
+	switch set.State {
+	case setCompiled:
+		if set.Err != nil {
+			def := set.Rule
+			return nil, addressErrorAt(pos, "", "address set @%s is invalid (see %s)", name,
+				formatLocation(def.Source, def.LineNum, 0))
+		}
+		return set.Matcher, nil
+	case setCompiling:
+		chain := append(chainFrom(c.setStack, name), name)
+		return nil, addressErrorAt(pos, "", "recursive reference to address set @%s (@%s)", name,
+			strings.Join(chain, " -> @"))
+	}
+	c.compileSet(name, set)
+	return set.Matcher, set.Err
+}
+
+// chainFrom returns the part of stack starting at the last occurrence of name.
+func chainFrom(stack []string, name string) []string {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i] == name {
+			return append([]string(nil), stack[i:]...)
+		}
+	}
+	return nil
+}
+
+// compileSet compiles the addresses of a set into a single matcher.
+// Errors are located in the definition, not at the reference.
+func (c *compiler) compileSet(name string, set *addressSet) {
+	set.State = setCompiling
+	c.setStack = append(c.setStack, name)
+	// Relative "list:" paths in a set are resolved against the directory of
+	// its definition, not of the rule referring to it
+	dir := c.dir
+	c.dir = set.Rule.dir
+	defer func() {
+		c.setStack = c.setStack[:len(c.setStack)-1]
+		c.dir = dir
+		set.State = setCompiled
+	}()
+
+	def := set.Rule
+	known := def.AddressPos.IsValid()
+	m, err := c.compileSetMatcher(def, known)
+	if err != nil {
+		ce, ok := err.(*CompilationError)
+		if !ok {
+			ce = &CompilationError{Message: err.Error()}
+		}
+		if ce.LineNum == 0 {
+			ce.LineNum, ce.Column = def.LineNum, def.Column
+		}
+		if ce.Source == "" {
+			ce.Source = def.Source
+		}
+		set.Err = ce
+		return
+	}
+	set.Matcher = m
+}
+
+func (c *compiler) compileSetMatcher(def *TextRule, known bool) (hostMatcher, error) {
+	s := newScannerAt(def.Address, def.AddressPos)
+	args, syntaxErr := s.scanList()
+	if syntaxErr != nil {
+		pos := Position{syntaxErr.LineNum, syntaxErr.Column}
+		if !known {
+			pos = Position{}
+		}
+		return nil, addressErrorAt(pos, "", "invalid syntax in @%s: %s", def.SetName, syntaxErr.Message)
+	}
+	matchers, err := c.compileAddressList(args, known, "@"+def.SetName)
+	if err != nil {
+		return nil, err
+	}
+	if len(matchers) == 1 {
+		return matchers[0], nil
+	}
+	return &orMatcher{matchers}, nil
+}
+
+// compileUnusedSets compiles the sets that no rule referenced, so that errors
+// in them are reported too. The errors are returned in definition order.
+func (c *compiler) compileUnusedSets() ErrorList {
+	var errs ErrorList
+	for _, set := range c.setOrder {
+		if set.State != setPending {
+			continue
+		}
+		c.compileSet(strings.ToLower(set.Rule.SetName), set)
+		if set.Err != nil {
+			errs = append(errs, set.Err)
+		}
+	}
+	return errs
+}
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"net"
 	"os"
 	"strings"
@@ -18,7 +20,8 @@ import (
 // These are typically set programmatically rather than from the config file.
 type BuildOptions struct {
 	GeoLoader acl.GeoLoader
-	CacheSize int // LRU cache size for rule matching (default: 1024)
+	CacheSize int  // LRU cache size for rule matching (default: 1024)
+	AllErrors bool // report all ACL errors at once, see router.WithAllErrors
 }
 
 // Config is the top-level configuration structure.
@@ -107,6 +110,18 @@ func Build(cfg *Config, bopts *BuildOptions) (*router.Router, error) {
 	if bopts != nil && bopts.CacheSize > 0 {
 		opts = append(opts, router.WithCacheSize(bopts.CacheSize))
 	}
+	if bopts != nil && bopts.AllErrors {
+		opts = append(opts, router.WithAllErrors())
+	}
+	if cfg.ACL.File != "" {
+		// Read by the router, so that includes are resolved relative to the file
+		r, err := router.NewFromFile(cfg.ACL.File, entries, geoLoader, opts...)
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) && pathErr.Path == cfg.ACL.File {
+			return nil, fmt.Errorf("read acl file: %w", err)
+		}
+		return r, err
+	}
 
 	return router.New(rules, entries, geoLoader, opts...)
 }
@@ -225,11 +240,8 @@ func buildRules(cfg *ACLConfig) (string, error) {
 		return "", fmt.Errorf("cannot specify both acl.file and acl.inline")
 	}
 	if cfg.File != "" {
-		data, err := os.ReadFile(cfg.File)
-		if err != nil {
-			return "", fmt.Errorf("read acl file: %w", err)
-		}
-		return string(data), nil
+		// Read by router.NewFromFile
+		return "", nil
 	}
 	return strings.Join(cfg.Inline, "\n"), nil
 }
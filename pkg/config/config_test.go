@@ -343,6 +343,14 @@ acl:
 `,
 			wantErr: "cannot specify both",
 		},
+		{
+			name: "missing acl file",
+			yaml: `
+acl:
+  file: /nonexistent/rules.acl
+`,
+			wantErr: "read acl file: open /nonexistent/rules.acl",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
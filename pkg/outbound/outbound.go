@@ -23,6 +23,7 @@ type Addr struct {
 	Host        string       // Hostname or IP address
 	Port        uint16       // Port number
 	ResolveInfo *ResolveInfo // Optional DNS resolution result
+	Session     *SessionInfo // Optional metadata of the session the connection belongs to
 }
 
 // String returns the address in host:port format.
@@ -46,6 +47,15 @@ func (a *Addr) NetworkAddr() string {
 	return a.String()
 }
 
+// SessionInfo contains metadata about where a connection came from.
+// It is used by the router for src:, inbound: and user: rules.
+type SessionInfo struct {
+	SrcIP   net.IP // Client IP address
+	SrcPort uint16 // Client port
+	Inbound string // Tag of the inbound (listener) that accepted the connection
+	User    string // Authenticated user, if any
+}
+
 // ResolveInfo contains DNS resolution results.
 type ResolveInfo struct {
 	IPv4 net.IP // Resolved IPv4 address, if any
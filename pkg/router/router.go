@@ -1,9 +1,12 @@
 package router
 
 import (
+	"errors"
+	"fmt"
 	"net"
-	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/xflash-panda/acl-engine/pkg/acl"
 	"github.com/xflash-panda/acl-engine/pkg/outbound"
@@ -24,16 +27,70 @@ type Router struct {
 type Option func(*routerOptions)
 
 type routerOptions struct {
-	cacheSize int
+	cacheSize   int
+	source      string
+	allErrors   bool
+	baseDir     string
+	hitCounters bool
+	cacheShards int
+	cacheTTL    time.Duration
 }
 
 // WithCacheSize sets the LRU cache size for rule matching results.
+// A size of 0 disables the cache.
 func WithCacheSize(size int) Option {
 	return func(o *routerOptions) {
 		o.cacheSize = size
 	}
 }
 
+// WithCacheShards sets the number of shards of the match cache, see
+// acl.WithCacheShards.
+func WithCacheShards(n int) Option {
+	return func(o *routerOptions) {
+		o.cacheShards = n
+	}
+}
+
+// WithCacheTTL makes cached match results expire after ttl, see acl.WithCacheTTL.
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(o *routerOptions) {
+		o.cacheTTL = ttl
+	}
+}
+
+// WithSource sets the name of the rules source, used in error messages.
+// NewFromFile sets it to the file name.
+func WithSource(name string) Option {
+	return func(o *routerOptions) {
+		o.source = name
+	}
+}
+
+// WithAllErrors makes New and NewFromFile report every syntax and compilation
+// error in the rules as an acl.ErrorList, instead of stopping at the first one.
+func WithAllErrors() Option {
+	return func(o *routerOptions) {
+		o.allErrors = true
+	}
+}
+
+// WithBaseDir sets the directory that relative paths in include directives and
+// "list:" addresses are resolved against. It defaults to the current working
+// directory for New, and to the directory of the rules file for NewFromFile.
+func WithBaseDir(dir string) Option {
+	return func(o *routerOptions) {
+		o.baseDir = dir
+	}
+}
+
+// WithHitCounters enables per-rule hit counters, see Router.RuleHits.
+func WithHitCounters() Option {
+	return func(o *routerOptions) {
+		o.hitCounters = true
+	}
+}
+
 // OutboundEntry represents an outbound with a name.
 type OutboundEntry struct {
 	Name     string
@@ -45,6 +102,24 @@ type OutboundEntry struct {
 // The outbounds parameter is a list of named outbounds.
 // The geoLoader is used to load GeoIP/GeoSite databases on demand.
 func New(rules string, outbounds []OutboundEntry, geoLoader acl.GeoLoader, opts ...Option) (*Router, error) {
+	return newRouter(func(aclOpts []acl.Option) ([]acl.TextRule, error) {
+		return acl.ParseTextRules(rules, aclOpts...)
+	}, outbounds, geoLoader, opts)
+}
+
+// NewFromFile creates a new Router from an ACL rules file.
+// Files included by the rules file are resolved relative to its directory.
+func NewFromFile(filename string, outbounds []OutboundEntry, geoLoader acl.GeoLoader, opts ...Option) (*Router, error) {
+	opts = append([]Option{WithBaseDir(filepath.Dir(filename))}, opts...)
+	return newRouter(func(aclOpts []acl.Option) ([]acl.TextRule, error) {
+		return acl.ParseFile(filename, aclOpts...)
+	}, outbounds, geoLoader, opts)
+}
+
+// newRouter parses the rules with parse and compiles them into a Router.
+func newRouter(parse func([]acl.Option) ([]acl.TextRule, error),
+	outbounds []OutboundEntry, geoLoader acl.GeoLoader, opts []Option,
+) (*Router, error) {
 	options := &routerOptions{
 		cacheSize: defaultCacheSize,
 	}
@@ -52,34 +127,72 @@ func New(rules string, outbounds []OutboundEntry, geoLoader acl.GeoLoader, opts
 		opt(options)
 	}
 
-	trs, err := acl.ParseTextRules(rules)
-	if err != nil {
-		return nil, err
+	var aclOpts []acl.Option
+	if options.source != "" {
+		aclOpts = append(aclOpts, acl.WithSource(options.source))
+	}
+	if options.allErrors {
+		aclOpts = append(aclOpts, acl.WithAllErrors())
+	}
+	if options.baseDir != "" {
+		aclOpts = append(aclOpts, acl.WithBaseDir(options.baseDir))
+	}
+
+	trs, parseErr := parse(aclOpts)
+	if options.hitCounters {
+		aclOpts = append(aclOpts, acl.WithHitCounters())
+	}
+	if options.cacheShards > 0 {
+		aclOpts = append(aclOpts, acl.WithCacheShards(options.cacheShards))
 	}
-	obMap := outboundsToMap(outbounds)
-	rs, err := acl.Compile[outbound.Outbound](trs, obMap, options.cacheSize, geoLoader)
+	if options.cacheTTL > 0 {
+		aclOpts = append(aclOpts, acl.WithCacheTTL(options.cacheTTL))
+	}
+	if parseErr != nil && (!options.allErrors || !errors.As(parseErr, new(acl.ErrorList))) {
+		// Either stopping at the first error, or the rules could not be read at all
+		return nil, parseErr
+	}
+	obMap, err := outboundsToMap(outbounds)
 	if err != nil {
 		return nil, err
 	}
+	rs, err := acl.Compile[outbound.Outbound](trs, obMap, options.cacheSize, geoLoader, aclOpts...)
+	if parseErr != nil || err != nil {
+		if !options.allErrors {
+			return nil, err
+		}
+		return nil, mergeErrors(parseErr, err)
+	}
 	return &Router{
 		ruleSet:  rs,
 		default_: obMap["default"],
 	}, nil
 }
 
-// NewFromFile creates a new Router from an ACL rules file.
-func NewFromFile(filename string, outbounds []OutboundEntry, geoLoader acl.GeoLoader, opts ...Option) (*Router, error) {
-	bs, err := os.ReadFile(filename)
-	if err != nil {
-		return nil, err
+// mergeErrors merges parse and compilation errors into a single sorted acl.ErrorList.
+func mergeErrors(errs ...error) acl.ErrorList {
+	var merged acl.ErrorList
+	for _, err := range errs {
+		var list acl.ErrorList
+		if errors.As(err, &list) {
+			merged = append(merged, list...)
+		} else if err != nil {
+			merged = append(merged, err)
+		}
 	}
-	return New(string(bs), outbounds, geoLoader, opts...)
+	merged.Sort()
+	return merged
 }
 
-func outboundsToMap(outbounds []OutboundEntry) map[string]outbound.Outbound {
+func outboundsToMap(outbounds []OutboundEntry) (map[string]outbound.Outbound, error) {
 	obMap := make(map[string]outbound.Outbound)
 	for _, ob := range outbounds {
-		obMap[strings.ToLower(ob.Name)] = ob.Outbound
+		name := strings.ToLower(ob.Name)
+		if name == "include" {
+			// Reserved for include directives in the rules
+			return nil, fmt.Errorf("invalid outbound name %s: reserved for include directives", ob.Name)
+		}
+		obMap[name] = ob.Outbound
 	}
 	// Add built-in outbounds if not overridden
 	if _, ok := obMap["direct"]; !ok {
@@ -95,7 +208,7 @@ func outboundsToMap(outbounds []OutboundEntry) map[string]outbound.Outbound {
 			obMap["default"] = obMap["direct"]
 		}
 	}
-	return obMap
+	return obMap, nil
 }
 
 func (r *Router) resolve(addr *outbound.Addr) {
@@ -136,17 +249,32 @@ func (r *Router) match(addr *outbound.Addr, proto acl.Protocol) outbound.Outboun
 		hostInfo.IPv4 = addr.ResolveInfo.IPv4
 		hostInfo.IPv6 = addr.ResolveInfo.IPv6
 	}
-	ob, hijackIP := r.ruleSet.Match(hostInfo, proto, addr.Port)
+	if addr.Session != nil {
+		hostInfo.SrcIP = addr.Session.SrcIP
+		hostInfo.SrcPort = addr.Session.SrcPort
+		hostInfo.Inbound = addr.Session.Inbound
+		hostInfo.User = addr.Session.User
+	}
+	ob, hijack := r.ruleSet.MatchAddress(hostInfo, proto, addr.Port)
 	if ob == nil {
 		return r.default_
 	}
-	if hijackIP != nil {
-		// Rewrite both Host & ResolveInfo
-		addr.Host = hijackIP.String()
-		if ip4 := hijackIP.To4(); ip4 != nil {
-			addr.ResolveInfo = &outbound.ResolveInfo{IPv4: ip4}
+	if hijack != nil {
+		if hijack.Port != 0 {
+			addr.Port = hijack.Port
+		}
+		if hijack.IP != nil {
+			// Rewrite both Host & ResolveInfo
+			addr.Host = hijack.IP.String()
+			if ip4 := hijack.IP.To4(); ip4 != nil {
+				addr.ResolveInfo = &outbound.ResolveInfo{IPv4: ip4}
+			} else {
+				addr.ResolveInfo = &outbound.ResolveInfo{IPv6: hijack.IP}
+			}
 		} else {
-			addr.ResolveInfo = &outbound.ResolveInfo{IPv6: hijackIP}
+			// Resolve the new host, without matching the rules again
+			addr.Host = hijack.Domain
+			r.resolve(addr)
 		}
 	}
 	return ob
@@ -165,3 +293,28 @@ func (r *Router) DialUDP(addr *outbound.Addr) (outbound.UDPConn, error) {
 	ob := r.match(addr, acl.ProtocolUDP)
 	return ob.DialUDP(addr)
 }
+
+// RuleHits returns how many connections each rule matched since the router was
+// created or the counters were reset. It is empty unless WithHitCounters is used.
+// Connections that no rule matched go to the default outbound and are counted
+// in NoMatch.
+func (r *Router) RuleHits() acl.HitStats {
+	return r.ruleSet.HitStats()
+}
+
+// ResetRuleHits resets the hit counters, and returns their values before the reset.
+func (r *Router) ResetRuleHits() acl.HitStats {
+	return r.ruleSet.ResetHitStats()
+}
+
+// CacheStats returns the size and hit rate of the match cache.
+func (r *Router) CacheStats() acl.CacheStats {
+	return r.ruleSet.CacheStats()
+}
+
+// NeverMatched returns the rules that have not matched any connection since
+// the router was created or the counters were reset. It is empty unless
+// WithHitCounters is used.
+func (r *Router) NeverMatched() []acl.RuleHits {
+	return r.ruleSet.HitStats().NeverMatched()
+}
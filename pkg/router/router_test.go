@@ -2,7 +2,10 @@ package router
 
 import (
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -35,6 +38,23 @@ func TestNewWithOptions(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, r)
 	})
+
+	t.Run("with cache shards and TTL", func(t *testing.T) {
+		r, err := New(rules, outbounds, geoLoader, WithCacheSize(2048), WithCacheShards(8), WithCacheTTL(time.Minute))
+		require.NoError(t, err)
+		stats := r.CacheStats()
+		assert.Equal(t, 8, stats.Shards)
+		assert.Equal(t, 2048, stats.Capacity)
+	})
+
+	t.Run("without cache", func(t *testing.T) {
+		r, err := New(rules, outbounds, geoLoader, WithCacheSize(0))
+		require.NoError(t, err)
+		addr := &outbound.Addr{Host: "10.0.0.1", Port: 80}
+		r.resolve(addr)
+		r.match(addr, acl.ProtocolTCP)
+		assert.Equal(t, acl.CacheStats{}, r.CacheStats())
+	})
 }
 
 func TestNewWithCustomOutbounds(t *testing.T) {
@@ -69,6 +89,60 @@ func TestNewInvalidRules(t *testing.T) {
 	})
 }
 
+func TestNewAllErrors(t *testing.T) {
+	rules := `
+direct(all, tcp
+prxy(all)
+direct(10.0.0.0/33)
+`
+	_, err := New(rules, []OutboundEntry{{"proxy", outbound.NewReject()}}, &acl.NilGeoLoader{},
+		WithSource("rules.acl"), WithAllErrors())
+	var errs acl.ErrorList
+	require.ErrorAs(t, err, &errs)
+	require.Len(t, errs, 3, err.Error())
+	assert.Contains(t, errs[0].Error(), "rules.acl line 2")
+	assert.Contains(t, errs[1].Error(), `did you mean "proxy"?`)
+	assert.Contains(t, errs[2].Error(), "invalid CIDR address")
+}
+
+func TestNewFromFileInclude(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.acl")
+	lan := filepath.Join(dir, "lan.acl")
+	require.NoError(t, os.WriteFile(main, []byte("include(lan.acl)\nproxy(all)\n"), 0o644))
+	require.NoError(t, os.WriteFile(lan, []byte("@lan = 10.0.0.0/8\nreject(@lan)\n"), 0o644))
+	outbounds := []OutboundEntry{{"proxy", outbound.NewDirect(outbound.DirectModeAuto)}}
+
+	r, err := NewFromFile(main, outbounds, &acl.NilGeoLoader{})
+	require.NoError(t, err)
+	// Should be rejected because 10.1.2.3 is in @lan from lan.acl
+	_, err = r.DialTCP(&outbound.Addr{
+		Host: "10.1.2.3",
+		Port: 80,
+	})
+	require.Error(t, err)
+
+	// Errors in included files are reported with the included file name
+	require.NoError(t, os.WriteFile(lan, []byte("@lan = 10.0.0.0/8\nprxy(@lan)\n"), 0o644))
+	_, err = NewFromFile(main, outbounds, &acl.NilGeoLoader{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), lan+" line 2")
+}
+
+func TestNewFromFileDomainList(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.acl")
+	require.NoError(t, os.WriteFile(main, []byte("reject(list:ads.txt)\nproxy(all)\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ads.txt"), []byte("0.0.0.0 ads.example.com\n"), 0o644))
+	outbounds := []OutboundEntry{{"proxy", outbound.NewDirect(outbound.DirectModeAuto)}}
+
+	// Relative list paths are resolved against the directory of the rules file
+	r, err := NewFromFile(main, outbounds, &acl.NilGeoLoader{}, WithHitCounters())
+	require.NoError(t, err)
+	r.match(&outbound.Addr{Host: "ads.example.com", Port: 80}, acl.ProtocolTCP)
+	assert.Equal(t, uint64(1), r.RuleHits().Rules[0].Hits)
+}
+
 func TestBuiltInOutbounds(t *testing.T) {
 	rules := `
 direct(1.1.1.1)
@@ -83,7 +157,8 @@ direct(all)
 
 func TestOutboundsToMap(t *testing.T) {
 	t.Run("empty outbounds", func(t *testing.T) {
-		m := outboundsToMap(nil)
+		m, err := outboundsToMap(nil)
+		require.NoError(t, err)
 		assert.NotNil(t, m["direct"])
 		assert.NotNil(t, m["reject"])
 		assert.NotNil(t, m["default"])
@@ -96,7 +171,8 @@ func TestOutboundsToMap(t *testing.T) {
 		outbounds := []OutboundEntry{
 			{"proxy", proxy},
 		}
-		m := outboundsToMap(outbounds)
+		m, err := outboundsToMap(outbounds)
+		require.NoError(t, err)
 		assert.Equal(t, proxy, m["proxy"])
 		// default should be first outbound
 		assert.Equal(t, proxy, m["default"])
@@ -107,7 +183,8 @@ func TestOutboundsToMap(t *testing.T) {
 		outbounds := []OutboundEntry{
 			{"direct", customDirect},
 		}
-		m := outboundsToMap(outbounds)
+		m, err := outboundsToMap(outbounds)
+		require.NoError(t, err)
 		assert.Equal(t, customDirect, m["direct"])
 	})
 
@@ -116,9 +193,17 @@ func TestOutboundsToMap(t *testing.T) {
 		outbounds := []OutboundEntry{
 			{"PROXY", proxy},
 		}
-		m := outboundsToMap(outbounds)
+		m, err := outboundsToMap(outbounds)
+		require.NoError(t, err)
 		assert.Equal(t, proxy, m["proxy"])
 	})
+
+	t.Run("reserved name", func(t *testing.T) {
+		_, err := outboundsToMap([]OutboundEntry{{"Include", outbound.NewReject()}})
+		assert.ErrorContains(t, err, "reserved for include directives")
+		_, err = New("direct(all)", []OutboundEntry{{"include", outbound.NewReject()}}, &acl.NilGeoLoader{})
+		assert.ErrorContains(t, err, "reserved for include directives")
+	})
 }
 
 func TestRouterDialTCP(t *testing.T) {
@@ -194,6 +279,35 @@ direct(all)
 	require.Error(t, err)
 }
 
+func TestRouterSessionMatching(t *testing.T) {
+	rules := `
+reject(and(src:10.1.0.0/16, 192.168.0.0/16))
+reject(user:mallory)
+direct(all)
+`
+	r, err := New(rules, nil, &acl.NilGeoLoader{})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		session    *outbound.SessionInfo
+		wantReject bool
+	}{
+		{"no session", nil, false},
+		{"matching source", &outbound.SessionInfo{SrcIP: net.ParseIP("10.1.0.5"), SrcPort: 50000}, true},
+		{"other source", &outbound.SessionInfo{SrcIP: net.ParseIP("10.2.0.5")}, false},
+		{"user", &outbound.SessionInfo{User: "mallory"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := &outbound.Addr{Host: "192.168.1.1", Port: 80, Session: tt.session}
+			r.resolve(addr)
+			_, isReject := r.match(addr, acl.ProtocolTCP).(*outbound.Reject)
+			assert.Equal(t, tt.wantReject, isReject)
+		})
+	}
+}
+
 func TestRouterResolveWithIPHost(t *testing.T) {
 	rules := `direct(all)`
 	r, err := New(rules, nil, &acl.NilGeoLoader{})
@@ -219,3 +333,103 @@ func TestRouterResolveIPv6(t *testing.T) {
 	assert.NotNil(t, addr.ResolveInfo)
 	assert.NotNil(t, addr.ResolveInfo.IPv6)
 }
+
+func TestRouterHijack(t *testing.T) {
+	r, err := New(`
+direct(all, udp/53, 127.0.0.1:5353)
+direct(1.1.1.1, tcp/443, [::1])
+direct(2.2.2.2, tcp/80, localhost:8080)
+direct(all)
+`, nil, &acl.NilGeoLoader{})
+	require.NoError(t, err)
+
+	// IP and port
+	addr := &outbound.Addr{Host: "dns.example.com", Port: 53}
+	r.match(addr, acl.ProtocolUDP)
+	assert.Equal(t, "127.0.0.1", addr.Host)
+	assert.Equal(t, uint16(5353), addr.Port)
+	assert.Equal(t, net.ParseIP("127.0.0.1").To4(), addr.ResolveInfo.IPv4)
+
+	// IP only, the port is kept
+	addr = &outbound.Addr{Host: "1.1.1.1", Port: 443}
+	r.resolve(addr)
+	r.match(addr, acl.ProtocolTCP)
+	assert.Equal(t, "::1", addr.Host)
+	assert.Equal(t, uint16(443), addr.Port)
+	assert.Equal(t, net.IPv6loopback, addr.ResolveInfo.IPv6)
+	assert.Nil(t, addr.ResolveInfo.IPv4)
+
+	// Domains are resolved again
+	addr = &outbound.Addr{Host: "2.2.2.2", Port: 80}
+	r.resolve(addr)
+	r.match(addr, acl.ProtocolTCP)
+	assert.Equal(t, "localhost", addr.Host)
+	assert.Equal(t, uint16(8080), addr.Port)
+	require.NotNil(t, addr.ResolveInfo)
+	assert.NotEqual(t, net.IPv4(2, 2, 2, 2).To4(), addr.ResolveInfo.IPv4)
+
+	// Other connections are not changed
+	addr = &outbound.Addr{Host: "2.2.2.2", Port: 443}
+	r.resolve(addr)
+	r.match(addr, acl.ProtocolTCP)
+	assert.Equal(t, "2.2.2.2", addr.Host)
+	assert.Equal(t, uint16(443), addr.Port)
+}
+
+func TestRouterRuleHits(t *testing.T) {
+	rules := `
+reject(10.0.0.0/8)
+direct(192.168.0.0/16)
+reject(example.com)
+`
+	r, err := New(rules, nil, &acl.NilGeoLoader{}, WithHitCounters())
+	require.NoError(t, err)
+
+	for _, host := range []string{"10.0.0.1", "10.0.0.1", "10.0.0.2", "192.168.1.1", "8.8.8.8"} {
+		addr := &outbound.Addr{Host: host, Port: 80}
+		r.resolve(addr)
+		r.match(addr, acl.ProtocolTCP)
+	}
+
+	stats := r.RuleHits()
+	require.Len(t, stats.Rules, 3)
+	// The second 10.0.0.1 comes from the cache, and is still counted
+	assert.Equal(t, uint64(3), stats.Rules[0].Hits)
+	assert.Equal(t, uint64(1), stats.Rules[1].Hits)
+	assert.Equal(t, uint64(0), stats.Rules[2].Hits)
+	assert.Equal(t, uint64(1), stats.NoMatch)
+
+	never := r.NeverMatched()
+	require.Len(t, never, 1)
+	assert.Equal(t, 4, never[0].Rule.LineNum)
+	assert.Equal(t, "example.com", never[0].Rule.Address)
+
+	assert.Equal(t, stats, r.ResetRuleHits())
+	assert.Len(t, r.NeverMatched(), 3)
+	assert.Zero(t, r.RuleHits().NoMatch)
+}
+
+func TestRouterRuleHitsDisabled(t *testing.T) {
+	r, err := New(`direct(all)`, nil, &acl.NilGeoLoader{})
+	require.NoError(t, err)
+	addr := &outbound.Addr{Host: "10.0.0.1", Port: 80}
+	r.resolve(addr)
+	r.match(addr, acl.ProtocolTCP)
+	assert.Empty(t, r.RuleHits().Rules)
+	assert.Empty(t, r.NeverMatched())
+}
+
+func TestRouterCacheStats(t *testing.T) {
+	r, err := New(`direct(all)`, nil, &acl.NilGeoLoader{}, WithCacheSize(16))
+	require.NoError(t, err)
+	for _, host := range []string{"10.0.0.1", "10.0.0.1", "10.0.0.2"} {
+		addr := &outbound.Addr{Host: host, Port: 80}
+		r.resolve(addr)
+		r.match(addr, acl.ProtocolTCP)
+	}
+	stats := r.CacheStats()
+	assert.Equal(t, 2, stats.Len)
+	assert.Equal(t, 16, stats.Capacity)
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(2), stats.Misses)
+}